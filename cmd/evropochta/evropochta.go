@@ -12,6 +12,8 @@ import (
 
 	"github.com/egorka-gh/pixlpark/evropochta"
 	cycle "github.com/egorka-gh/pixlpark/photocycle"
+	"github.com/egorka-gh/pixlpark/pixlpark/oauth"
+	"github.com/egorka-gh/pixlpark/pixlpark/service"
 	log "github.com/go-kit/kit/log"
 	"github.com/kardianos/osext"
 	service1 "github.com/kardianos/service"
@@ -147,6 +149,7 @@ func initEvropochta() (*group.Group, error) {
 		viper.GetString("evropochta.pass"),
 		viper.GetString("evropochta.serviceNumber"),
 		viper.GetString("evropochta.outFolder"),
+		time.Duration(viper.GetInt("evropochta.cacheTTL"))*time.Hour,
 		logger)
 	if err != nil {
 		return nil, err
@@ -154,8 +157,29 @@ func initEvropochta() (*group.Group, error) {
 
 	//init proxy
 	pcfg := evropochta.HandlerConfig{
-		Client: evropochtaClient,
-		Logger: logger,
+		Client:         evropochtaClient,
+		Logger:         logger,
+		GoodsID:        viper.GetString("evropochta.goodsID"),
+		DeliveryTypeID: viper.GetString("evropochta.deliveryTypeID"),
+		TrackingURL:    viper.GetString("evropochta.trackingURL"),
+		FontFile:       viper.GetString("evropochta.fontFile"),
+	}
+
+	//pixelpark client to create shipments by order
+	if viper.GetString("pixelpark.oauth.PublicKey") != "" && viper.GetString("pixelpark.oauth.PrivateKey") != "" {
+		cnf := &oauth.Config{
+			PublicKey:  viper.GetString("pixelpark.oauth.PublicKey"),
+			PrivateKey: viper.GetString("pixelpark.oauth.PrivateKey"),
+			Endpoint: oauth.Endpoint{
+				RequestURL: "http://api.pixlpark.com/oauth/requesttoken",
+				RefreshURL: "http://api.pixlpark.com/oauth/refreshtoken",
+				TokenURL:   "http://api.pixlpark.com/oauth/accesstoken",
+			},
+		}
+		oauthClient := cnf.Client(context.Background(), nil)
+		pcfg.PixelClient, _ = service.New("http://api.pixlpark.com", defaultHTTPOptions(oauthClient), nil)
+	} else {
+		dLogger.Info("Pixelpark oauth is not set, shipment api disabled.")
 	}
 
 	server := &http.Server{
@@ -212,6 +236,13 @@ func readConfig() error {
 	viper.SetDefault("evropochta.pass", "")
 	viper.SetDefault("evropochta.serviceNumber", "")
 	viper.SetDefault("evropochta.outFolder", ".\\evropochta")
+	viper.SetDefault("evropochta.cacheTTL", 72)        //stickers cache lifetime (hours), 0 - disable cache
+	viper.SetDefault("evropochta.goodsID", "")         //shipment goods type id
+	viper.SetDefault("evropochta.deliveryTypeID", "")  //shipment delivery type id
+	viper.SetDefault("evropochta.trackingURL", "")     //tracking page url format, %s - track number
+	viper.SetDefault("evropochta.fontFile", "")        //UTF-8 ttf font for labels manifest, cyrillic is transliterated if empty
+	viper.SetDefault("pixelpark.oauth.PublicKey", "")  //oauth PublicKey, to create shipments by pixelpark order
+	viper.SetDefault("pixelpark.oauth.PrivateKey", "") //oauth PrivateKey
 
 	path, err := osext.ExecutableFolder()
 	if err != nil {
@@ -0,0 +1,17 @@
+package main
+
+import (
+	http0 "net/http"
+
+	"github.com/go-kit/kit/transport/http"
+)
+
+var methods = []string{"GetOrder", "SetTrackingNumber"}
+
+func defaultHTTPOptions(cli *http0.Client) map[string][]http.ClientOption {
+	options := map[string][]http.ClientOption{}
+	for _, v := range methods {
+		options[v] = append(options[v], http.SetClient(cli))
+	}
+	return options
+}
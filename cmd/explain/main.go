@@ -0,0 +1,75 @@
+//explain checks unziped order item folder and explains how files will be mapped to book sheets
+//usage: explain [-cover] [-fake_cover] [-maket spreads] [-qty copies] [-json] -pages N folder
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/egorka-gh/pixlpark/transform"
+)
+
+func main() {
+	var p transform.LayoutParams
+	maket := -1
+	qty := 1
+	asJSON := false
+	flag.BoolVar(&p.HasCover, "cover", false, "alias has cover")
+	flag.BoolVar(&p.FakeCover, "fake_cover", false, "sku fake_cover is set (placeholder for cover)")
+	flag.IntVar(&maket, "maket", -1, "sku maket spreads (page_n/cover_n naming), -1 - surface_[n] naming")
+	flag.IntVar(&p.PageCount, "pages", 0, "order item page count")
+	flag.IntVar(&qty, "qty", 1, "order item quantity (copies of personalised book in copy_N subfolders or copy_N_ prefixed files)")
+	flag.BoolVar(&asJSON, "json", false, "print report as json")
+	flag.Parse()
+	if flag.NArg() != 1 || p.PageCount <= 0 || qty <= 0 {
+		fmt.Println("usage: explain [-cover] [-fake_cover] [-maket spreads] [-qty copies] [-json] -pages N folder")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	if maket >= 0 {
+		p.Maket = true
+		p.Spreads = maket
+	}
+
+	books, err := transform.ValidateBookFolder(flag.Arg(0), qty, p)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	if asJSON {
+		b, _ := json.MarshalIndent(books, "", "  ")
+		fmt.Println(string(b))
+	}
+	ok := true
+	for _, b := range books {
+		report := b.Report
+		ok = ok && report.Ok()
+		if asJSON {
+			continue
+		}
+		if b.Copy > 0 {
+			fmt.Printf("Экземпляр %d\n", b.Copy)
+		}
+		fmt.Printf("Ожидается разворотов: %d (%d-%d)\n", report.Sheets, report.First, report.First+report.Sheets-1)
+		for _, f := range report.Files {
+			if f.Skipped != "" {
+				fmt.Printf("  %-60s пропущен (%s)\n", f.Name, f.Skipped)
+			} else {
+				fmt.Printf("  %-60s разворот %d\n", f.Name, f.Sheet)
+			}
+		}
+		for _, is := range report.Issues {
+			level := "предупреждение"
+			if is.Fatal {
+				level = "ошибка"
+			}
+			fmt.Printf("%s: %s\n", level, is.Message)
+		}
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
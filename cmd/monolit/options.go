@@ -10,9 +10,10 @@ import (
 	"github.com/go-kit/kit/endpoint"
 	log "github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/transport/http"
+	"github.com/spf13/viper"
 )
 
-var methods = []string{"CountOrders", "GetOrders", "GetOrderItems", "GetOrder", "SetOrderStatus", "AddOrderComment", "dummi"}
+var methods = []string{"CountOrders", "GetOrders", "GetOrderItems", "GetOrder", "SetOrderStatus", "AddOrderComment", "SetTrackingNumber", "dummi"}
 
 func defaultHTTPOptions(cli *http0.Client, logger log.Logger) map[string][]http.ClientOption {
 	//cli := defaultHttpClient()
@@ -27,15 +28,43 @@ func defaultHTTPOptions(cli *http0.Client, logger log.Logger) map[string][]http.
 	return options
 }
 
-func defaultHTTPMiddleware(logger log.Logger) (mw map[string][]endpoint.Middleware) {
+func defaultHTTPMiddleware(logger log.Logger, breakers *service.Breakers) (mw map[string][]endpoint.Middleware) {
 	mw = map[string][]endpoint.Middleware{}
 
 	for _, v := range methods {
+		//applied from inner to outer: breaker -> rate limit -> logging
+		limits := methodLimits(v)
+		if breakers != nil {
+			if m := breakers.Middleware(v, limits); m != nil {
+				mw[v] = append(mw[v], m)
+			}
+		}
+		if m := service.RateLimitMiddleware(limits); m != nil {
+			mw[v] = append(mw[v], m)
+		}
 		mw[v] = append(mw[v], loggingMiddlware(log.With(logger, "method", v)))
 	}
 	return
 }
 
+//methodLimits reads method limits from config
+//pixelpark.limits.default holds common values, pixelpark.limits.<method> overrides them
+func methodLimits(method string) service.Limits {
+	get := func(key string) string {
+		k := "pixelpark.limits." + method + "." + key
+		if viper.IsSet(k) {
+			return k
+		}
+		return "pixelpark.limits.default." + key
+	}
+	return service.Limits{
+		Rate:        viper.GetFloat64(get("rate")),
+		Burst:       viper.GetInt(get("burst")),
+		Failures:    uint32(viper.GetInt(get("failures"))),
+		OpenTimeout: time.Duration(viper.GetInt(get("timeout"))) * time.Second,
+	}
+}
+
 func beforeURILogger(l log.Logger) http.ClientOption {
 	return http.ClientBefore(
 		func(ctx context.Context, r *http0.Request) context.Context {
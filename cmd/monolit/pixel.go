@@ -10,11 +10,13 @@ import (
 	"path"
 	"time"
 
+	"github.com/egorka-gh/pixlpark/evropochta"
 	cycle "github.com/egorka-gh/pixlpark/photocycle"
 	"github.com/egorka-gh/pixlpark/photocycle/repo"
 	proxy "github.com/egorka-gh/pixlpark/photocycle/service"
 	"github.com/egorka-gh/pixlpark/pixlpark/oauth"
 	"github.com/egorka-gh/pixlpark/pixlpark/service"
+	"github.com/egorka-gh/pixlpark/storage"
 	"github.com/egorka-gh/pixlpark/transform"
 	log "github.com/go-kit/kit/log"
 	_ "github.com/go-sql-driver/mysql"
@@ -124,7 +126,8 @@ func (p *program) run() {
 }
 
 func (p *program) Stop(s service1.Service) error {
-	// Stop should not block. Return with a few seconds.
+	// Stop blocks till managers drain, up to shutdown.drain + shutdown.grace,
+	// keep it within service manager stop timeout (service is killed mid-drain otherwise).
 	dLogger.Info("Pixel Stopping!")
 	//interrupt service
 	close(p.interrupt)
@@ -136,12 +139,9 @@ func (p *program) Stop(s service1.Service) error {
 
 func initPixel() (*group.Group, cycle.Repository, error) {
 	//TODO check settings
-	instanseID := viper.GetInt("source.id")
-	if instanseID == 0 {
-		return nil, nil, errors.New("Не задано ID источника")
-	}
-	if viper.GetString("pixelpark.oauth.PublicKey") == "" || viper.GetString("pixelpark.oauth.PrivateKey") == "" {
-		return nil, nil, errors.New("Не заданы параметры oauth")
+	sources, err := readSources()
+	if err != nil {
+		return nil, nil, err
 	}
 	if viper.GetString("mysql") == "" {
 		return nil, nil, errors.New("Не задано подключение mysql")
@@ -153,62 +153,82 @@ func initPixel() (*group.Group, cycle.Repository, error) {
 
 	logger := initLoger(viper.GetString("folders.log"), "pixel")
 
-	cnf := &oauth.Config{
-		PublicKey:  viper.GetString("pixelpark.oauth.PublicKey"),
-		PrivateKey: viper.GetString("pixelpark.oauth.PrivateKey"),
-		Endpoint: oauth.Endpoint{
-			RequestURL: "http://api.pixlpark.com/oauth/requesttoken",
-			RefreshURL: "http://api.pixlpark.com/oauth/refreshtoken",
-			TokenURL:   "http://api.pixlpark.com/oauth/accesstoken",
-		},
-		//Logger: logger,
-	}
-
-	url := "http://api.pixlpark.com"
-	oauthClient := cnf.Client(context.Background(), nil)
-	ppClient, _ := service.New(url, defaultHTTPOptions(oauthClient, nil), defaultHTTPMiddleware(log.With(logger, "level", "transport")))
-
 	//create repro
 	rep, err := repo.New(viper.GetString("mysql"), false)
 	if err != nil {
 		logger.Log("Open database error", err.Error())
 		return nil, nil, fmt.Errorf("Ошибка подключения к базе данных %s", err.Error())
 	}
-
-	//create factory
-	fc := transform.NewFactory(ppClient, rep, instanseID, viper.GetInt("production.pixel"), viper.GetString("folders.zip"), viper.GetString("folders.in"), viper.GetString("folders.prn"), viper.GetString("pixelpark.user"), log.With(logger, "level", "factory"))
-
+	out, err := initStorage()
+	if err != nil {
+		logger.Log("Storage error", err.Error())
+		return nil, nil, fmt.Errorf("Ошибка подключения к хранилищу %s", err.Error())
+	}
 	//check test mode
 	if viper.GetBool("debug") {
 		dLogger.Info("Run in debug mode.")
-		fc.SetDebug(true)
+	}
+	//transforms limit shared by sources
+	budget := transform.NewBudget(viper.GetInt("threadsTotal"))
+	//download speed limit shared by sources
+	var windows []transform.BandwidthWindow
+	if err := viper.UnmarshalKey("bandwidth", &windows); err != nil {
+		return nil, nil, fmt.Errorf("Ошибка чтения ограничений скорости загрузки: %s", err.Error())
+	}
+	bandwidth, err := transform.NewBandwidth(windows)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Ошибка чтения ограничений скорости загрузки: %s", err.Error())
+	}
+	//order claims of services sharing cycle database
+	cluster := transform.Cluster{
+		Owner: viper.GetString("cluster.owner"),
+		Lease: time.Duration(viper.GetInt("cluster.lease")) * time.Second,
+	}
+	if cluster.Owner == "" {
+		host, _ := os.Hostname()
+		cluster.Owner = fmt.Sprintf("%s:%d", host, os.Getpid())
 	}
 
-	//create manager
-	mn := transform.NewManager(fc, viper.GetInt("threads"), viper.GetInt("interval"), logger)
-	g := &group.Group{}
-
-	//init transform manager
-	g.Add(func() error {
-		mn.Start()
-		mn.Wait()
-		return nil
-	}, func(error) {
-		mn.Quit()
-	})
+	//health endpoints
+	health := &proxy.HealthConfig{
+		FreeDegraded: uint64(viper.GetFloat64("health.freeDegradedGB") * (1 << 30)),
+		FreeFailed:   uint64(viper.GetFloat64("health.freeFailedGB") * (1 << 30)),
+		SlowLatency:  time.Duration(viper.GetInt("health.slowLatency")) * time.Millisecond,
+		Timeout:      time.Duration(viper.GetInt("health.timeout")) * time.Second,
+		Heartbeat:    time.Duration(viper.GetInt("health.heartbeat")) * time.Minute,
+	}
+	if viper.GetBool("health.evropochta") {
+		health.Evropochta, err = evropochta.NewClient(
+			viper.GetString("evropochta.baseURL"),
+			viper.GetString("evropochta.user"),
+			viper.GetString("evropochta.pass"),
+			viper.GetString("evropochta.serviceNumber"),
+			"", 0, log.With(logger, "level", "health"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("Ошибка настройки evropochta %s", err.Error())
+		}
+	}
 
-	//init proxy
-	//init proxy
-	pcfg := proxy.Config{
-		PixelClient: ppClient,
-		CycleClient: rep,
-		Manager:     mn,
-		Source:      instanseID,
+	g := &group.Group{}
+	pcfgs := make([]*proxy.Config, 0, len(sources))
+	for _, sc := range sources {
+		pcfg, err := initSource(sc, rep, out, budget, bandwidth, cluster, g, log.With(logger, "source", sc.ID))
+		if err != nil {
+			return nil, nil, err
+		}
+		pcfg.Health = health
+		//remote cycle folders are not checked
+		if viper.GetString("storage.type") != "sftp" {
+			pcfg.CycleFolder = sc.Folders.In
+			pcfg.CyclePrtFolder = sc.Folders.Prn
+		}
+		pcfgs = append(pcfgs, pcfg)
 	}
 
+	//init proxy
 	server := &http.Server{
 		Addr:         viper.GetString("proxy.address"),
-		Handler:      proxy.New(&pcfg),
+		Handler:      proxy.NewMulti(pcfgs),
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  15 * 60 * time.Second,
@@ -227,6 +247,128 @@ func initPixel() (*group.Group, cycle.Repository, error) {
 	return g, rep, nil
 }
 
+//initSource creates source factory and manager, adds manager to group, returns source proxy config
+func initSource(sc sourceConfig, rep cycle.Repository, out storage.Storage, budget *transform.Budget, bandwidth *transform.Bandwidth, cluster transform.Cluster, g *group.Group, logger log.Logger) (*proxy.Config, error) {
+	var fc transform.Factory
+	var ppClient service.PPService
+	var breakers *service.Breakers
+	var probeClient service.PPService
+	var token oauth.TokenSource
+	if sc.Type == sourceDropFolder {
+		//local drop folder orders
+		src := transform.NewDropFolder(sc.Folders.Drop, sc.Production, log.With(logger, "level", "source"))
+		fc = transform.NewSourceFactory(src, rep, sc.ID, sc.Production, sc.Folders.Zip, sc.Folders.In, sc.Folders.Prn, sc.User, log.With(logger, "level", "factory"))
+	} else {
+		cnf := &oauth.Config{
+			PublicKey:  sc.PublicKey,
+			PrivateKey: sc.PrivateKey,
+			Endpoint: oauth.Endpoint{
+				RequestURL: "http://api.pixlpark.com/oauth/requesttoken",
+				RefreshURL: "http://api.pixlpark.com/oauth/refreshtoken",
+				TokenURL:   "http://api.pixlpark.com/oauth/accesstoken",
+			},
+			//Logger: logger,
+		}
+
+		url := "http://api.pixlpark.com"
+		//token source is shared with health check
+		token = cnf.TokenSource(context.Background(), nil)
+		oauthClient := oauth.NewClient(context.Background(), token)
+		breakers = service.NewBreakers(log.With(logger, "level", "transport"))
+		ppClient, _ = service.New(url, defaultHTTPOptions(oauthClient, nil), defaultHTTPMiddleware(log.With(logger, "level", "transport"), breakers))
+		//health probe client, no rate limits and breakers
+		probeClient, _ = service.New(url, defaultHTTPOptions(oauthClient, nil), nil)
+
+		//create factory
+		fc = transform.NewFactory(ppClient, rep, sc.ID, sc.Production, sc.Folders.Zip, sc.Folders.In, sc.Folders.Prn, sc.User, log.With(logger, "level", "factory"))
+	}
+
+	//check test mode
+	if viper.GetBool("debug") {
+		fc.SetDebug(true)
+	}
+	fc.SetPreflight(transform.Preflight{
+		Enabled:     viper.GetBool("preflight.enabled"),
+		ThumbFolder: sc.Folders.Thumbs,
+		ThumbSize:   viper.GetInt("preflight.thumbSize"),
+		Quality:     viper.GetInt("preflight.quality"),
+	})
+	fc.SetStorage(out)
+	fc.SetRouting(sc.Routing)
+	fc.SetCluster(cluster)
+	fc.SetCopyOptions(transform.CopyOptions{
+		StreamZip:   viper.GetBool("copy.streamZip"),
+		HardLinks:   viper.GetBool("copy.hardLinks"),
+		Workers:     viper.GetInt("copy.workers"),
+		ItemWorkers: viper.GetInt("copy.itemWorkers"),
+	})
+	var archive *transform.Archive
+	var err error
+	if sc.Folders.Archive != "" {
+		archive, err = transform.NewArchive(transform.ArchiveConfig{
+			Folder:    sc.Folders.Archive,
+			Retention: time.Duration(viper.GetInt("archive.retentionDays")) * 24 * time.Hour,
+			MaxSize:   int64(viper.GetFloat64("archive.maxSizeGB") * (1 << 30)),
+		})
+		if err != nil {
+			logger.Log("Archive error", err.Error())
+			return nil, fmt.Errorf("Ошибка открытия архива заказов %s", err.Error())
+		}
+		fc.SetArchive(archive)
+	}
+
+	//create manager
+	mn := transform.NewManager(fc, sc.Threads, viper.GetInt("interval"), logger)
+	mn.SetStages(transform.StageLimits{
+		Download:  viper.GetInt("stages.download"),
+		Extract:   viper.GetInt("stages.extract"),
+		Transform: viper.GetInt("stages.transform"),
+		Publish:   viper.GetInt("stages.publish"),
+	})
+	if breakers != nil {
+		mn.SetBreaker(breakers)
+	}
+	mn.SetBudget(budget)
+	if len(sc.Bandwidth) > 0 {
+		//source limit within common limit
+		if bandwidth, err = bandwidth.Sub(sc.Bandwidth); err != nil {
+			return nil, fmt.Errorf("Ошибка чтения ограничений скорости загрузки источника %d: %s", sc.ID, err.Error())
+		}
+	}
+	mn.SetBandwidth(bandwidth)
+	if viper.GetInt("janitor.interval") > 0 {
+		mn.SetJanitor(transform.NewJanitor(transform.JanitorConfig{
+			Interval:   time.Duration(viper.GetInt("janitor.interval")) * time.Minute,
+			Retention:  time.Duration(viper.GetInt("janitor.retentionHours")) * time.Hour,
+			Quarantine: viper.GetBool("janitor.quarantine"),
+			HighWater:  int64(viper.GetFloat64("janitor.highWaterGB") * (1 << 30)),
+		}, rep, sc.ID, sc.Folders.Zip, sc.Folders.In, out))
+	}
+
+	//init transform manager
+	g.Add(func() error {
+		mn.Start()
+		mn.Wait()
+		return nil
+	}, func(error) {
+		//complete running transforms, stop at stage boundary after drain timeout
+		//service Stop waits drain + grace, keep it within service stop timeout
+		mn.Drain(time.Duration(viper.GetInt("shutdown.drain"))*time.Second, time.Duration(viper.GetInt("shutdown.grace"))*time.Second)
+	})
+
+	return &proxy.Config{
+		PixelClient: ppClient,
+		CycleClient: rep,
+		Manager:     mn,
+		Source:      sc.ID,
+		ThumbFolder: sc.Folders.Thumbs,
+		Archive:     archive,
+		WorkFolder:  sc.Folders.Zip,
+		Token:       token,
+		ProbeClient: probeClient,
+	}, nil
+}
+
 func initLoger(logPath, fileName string) log.Logger {
 	var logger log.Logger
 	if logPath == "" {
@@ -249,6 +391,24 @@ func initLoger(logPath, fileName string) log.Logger {
 	return logger
 }
 
+//initStorage creates output storage of cycle folders (folders.in, folders.prn)
+func initStorage() (storage.Storage, error) {
+	switch viper.GetString("storage.type") {
+	case "", "local":
+		return storage.NewLocal(), nil
+	case "sftp":
+		return storage.DialSFTP(storage.SFTPConfig{
+			Address:    viper.GetString("storage.sftp.address"),
+			User:       viper.GetString("storage.sftp.user"),
+			Password:   viper.GetString("storage.sftp.password"),
+			KeyFile:    viper.GetString("storage.sftp.keyFile"),
+			KnownHosts: viper.GetString("storage.sftp.knownHosts"),
+		})
+	default:
+		return nil, fmt.Errorf("Неизвестный тип хранилища '%s'", viper.GetString("storage.type"))
+	}
+}
+
 //ReadConfig init/read viper config
 func readConfig() error {
 
@@ -257,7 +417,8 @@ func readConfig() error {
 	viper.SetDefault("production.pixel", 0)                                                   //production pixelparck id (0 - all orders)
 	viper.SetDefault("production.cycle", 0)                                                   //corresponding production cycle id
 	viper.SetDefault("interval", 10)                                                          //processing interval (min)
-	viper.SetDefault("threads", 3)                                                            //processing threads
+	viper.SetDefault("threads", 3)                                                            //processing threads, default of stage pools (stages.*)
+	viper.SetDefault("threadsTotal", 0)                                                       //processing threads of all sources (0 - unlimited)
 	viper.SetDefault("folders.zip", "D:\\Buffer\\pp\\wrk")                                    //work folder for loaded  and unpacked zips
 	viper.SetDefault("folders.in", "D:\\Buffer\\ftp\\in\\PXP")                                //cycle work folder (in ftp)
 	viper.SetDefault("folders.prn", "D:\\Buffer\\ftp\\out\\PXP")                              //cycle print folder (out)
@@ -267,8 +428,49 @@ func readConfig() error {
 	viper.SetDefault("pixelpark.oauth.PrivateKey", "upss")                                    //oauth PrivateKey
 	viper.SetDefault("proxy.address", ":8888")                                                //localhost
 	viper.SetDefault("paperIdMap", map[string]string{"10": "Глянцевая", "11": "Матовая", "12": "Металлик", "13": "Шелк"})
-	viper.SetDefault("debug", false)       //set debug mode (will not change pixel orders states)
-	viper.SetDefault("debug_cycle", false) //set cycle debug mode (prevent changes in cycle repository)
+	viper.SetDefault("pixelpark.limits.default.rate", 5)     //requests per second to pixelpark api by method (0 - unlimited)
+	viper.SetDefault("pixelpark.limits.default.burst", 5)    //max requests at once by method
+	viper.SetDefault("pixelpark.limits.default.failures", 5) //consecutive failures to open circuit breaker (0 - disabled)
+	viper.SetDefault("pixelpark.limits.default.timeout", 60) //circuit breaker open state duration (sec)
+	viper.SetDefault("preflight.enabled", false)             //normalise images (orientation, format) before transform
+	viper.SetDefault("preflight.thumbSize", 300)             //preview thumbnail size (px)
+	viper.SetDefault("preflight.quality", 95)                //jpeg quality of converted images
+	viper.SetDefault("folders.thumbs", "")                   //preview thumbnails folder (empty - no thumbnails)
+	viper.SetDefault("storage.type", "local")                //cycle folders storage: local (disk or mounted share), sftp (folders.in, folders.prn are remote paths)
+	viper.SetDefault("storage.sftp.address", "")             //sftp host:port
+	viper.SetDefault("storage.sftp.user", "")                //sftp user
+	viper.SetDefault("storage.sftp.password", "")            //sftp password
+	viper.SetDefault("storage.sftp.keyFile", "")             //sftp private key file
+	viper.SetDefault("storage.sftp.knownHosts", "")          //known_hosts file to check sftp server key (required)
+	viper.SetDefault("copy.streamZip", false)                //read files directly from zip without unzip (ignored if preflight enabled)
+	viper.SetDefault("copy.hardLinks", false)                //hard link files instead of copy if on the same volume
+	viper.SetDefault("copy.workers", 4)                      //parallel file copy workers
+	viper.SetDefault("copy.itemWorkers", 1)                  //parallel transformed items of one order (extra workers use free slots of threadsTotal)
+	viper.SetDefault("book.thicknessTolerance", 1.0)         //max difference of editor and calculated book thickness (mm), 0 - not checked
+	viper.SetDefault("archive.folder", "")                   //archive of finished order zips for reprints (empty - zips are removed)
+	viper.SetDefault("archive.retentionDays", 90)            //days to keep archived zips (0 - unlimited)
+	viper.SetDefault("archive.maxSizeGB", 0)                 //archive size cap (GB), oldest zips are removed first (0 - unlimited)
+	viper.SetDefault("janitor.interval", 0)                  //work folder cleanup interval (min), 0 - disabled
+	viper.SetDefault("janitor.retentionHours", 72)           //orphan zips and folders (not in transform or cycle) are kept (hours)
+	viper.SetDefault("janitor.quarantine", true)             //move orphans to .quarantine subfolder instead of delete
+	viper.SetDefault("janitor.highWaterGB", 0)               //work folder size cap (GB), orphans are removed regardless of retention, 0 - not checked
+	viper.SetDefault("bandwidth", nil)                       //zip download speed limits by day time [{from: "8:00", to: "20:00", mbps: 20}], not matched time - unlimited
+	viper.SetDefault("stages.download", 0)                   //parallel zip downloads of source (0 - threads)
+	viper.SetDefault("stages.extract", 0)                    //parallel unzip and preflight of source (0 - threads)
+	viper.SetDefault("stages.transform", 0)                  //parallel transforms of source, take slots of threadsTotal (0 - threads)
+	viper.SetDefault("stages.publish", 0)                    //parallel cycle orders publications of source (0 - threads)
+	viper.SetDefault("cluster.lease", 0)                     //order claim lease (sec) for services sharing pixelpark and cycle database, 0 - single service (no claims)
+	viper.SetDefault("cluster.owner", "")                    //service id in cluster (empty - host:pid)
+	viper.SetDefault("shutdown.drain", 15)                   //on stop running transforms are completed (sec), then stopped at stage boundary; drain + grace has to fit service stop timeout (windows kills service on shutdown after WaitToKillServiceTimeout, 20 sec by default)
+	viper.SetDefault("shutdown.grace", 5)                    //transforms not stopped at stage boundary are canceled after (sec)
+	viper.SetDefault("health.freeDegradedGB", 10)            //free space of work and cycle folders (GB), /health/ready is degraded below
+	viper.SetDefault("health.freeFailedGB", 1)               //free space of work and cycle folders (GB), /health/ready is failed below
+	viper.SetDefault("health.slowLatency", 1000)             //mysql and pixelpark answer time (ms), check is degraded above (0 - not checked)
+	viper.SetDefault("health.timeout", 5)                    //dependency check timeout (sec)
+	viper.SetDefault("health.heartbeat", 60)                 //longest transform (min), manager loop is failed without progress during 4 x interval + heartbeat
+	viper.SetDefault("health.evropochta", false)             //check evropochta JWT (evropochta.* credentials), check uses own client and token
+	viper.SetDefault("debug", false)                         //set debug mode (will not change pixel orders states)
+	viper.SetDefault("debug_cycle", false)                   //set cycle debug mode (prevent changes in cycle repository)
 
 	path, err := osext.ExecutableFolder()
 	if err != nil {
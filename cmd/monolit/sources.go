@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/egorka-gh/pixlpark/transform"
+	"github.com/spf13/viper"
+)
+
+//sourceDropFolder source type of local drop folder orders
+const sourceDropFolder = "folder"
+
+//sourceConfig pixelpark account or drop folder (photocycle source) settings
+type sourceConfig struct {
+	ID int `mapstructure:"id"`
+	//Type pixlpark (default) or folder
+	Type       string `mapstructure:"type"`
+	Production int    `mapstructure:"production"`
+	PublicKey  string `mapstructure:"publicKey"`
+	PrivateKey string `mapstructure:"privateKey"`
+	User       string `mapstructure:"user"`
+	Threads    int    `mapstructure:"threads"`
+	Folders    struct {
+		Zip     string `mapstructure:"zip"`
+		In      string `mapstructure:"in"`
+		Prn     string `mapstructure:"prn"`
+		Thumbs  string `mapstructure:"thumbs"`
+		Archive string `mapstructure:"archive"`
+		//Drop orders folder of drop folder source
+		Drop string `mapstructure:"drop"`
+	} `mapstructure:"folders"`
+	//Routing production routing rules
+	Routing transform.Routing `mapstructure:"routing"`
+	//Bandwidth source download speed limits, applied within common limits
+	Bandwidth []transform.BandwidthWindow `mapstructure:"bandwidth"`
+}
+
+//readSources reads sources list (sources), if not set - single source from common settings
+//not set source values are taken from common settings, folders - common folder/source id
+func readSources() ([]sourceConfig, error) {
+	common := sourceConfig{
+		ID:         viper.GetInt("source.id"),
+		Production: viper.GetInt("production.pixel"),
+		PublicKey:  viper.GetString("pixelpark.oauth.PublicKey"),
+		PrivateKey: viper.GetString("pixelpark.oauth.PrivateKey"),
+		User:       viper.GetString("pixelpark.user"),
+		Threads:    viper.GetInt("threads"),
+	}
+	common.Folders.Zip = viper.GetString("folders.zip")
+	common.Folders.In = viper.GetString("folders.in")
+	common.Folders.Prn = viper.GetString("folders.prn")
+	common.Folders.Thumbs = viper.GetString("folders.thumbs")
+	common.Folders.Archive = viper.GetString("archive.folder")
+	if err := viper.UnmarshalKey("routing", &common.Routing); err != nil {
+		return nil, fmt.Errorf("Ошибка чтения правил маршрутизации: %s", err.Error())
+	}
+
+	var res []sourceConfig
+	if !viper.IsSet("sources") {
+		res = []sourceConfig{common}
+	} else if err := viper.UnmarshalKey("sources", &res); err != nil {
+		return nil, fmt.Errorf("Ошибка чтения списка источников: %s", err.Error())
+	}
+	if len(res) == 0 {
+		return nil, errors.New("Пустой список источников")
+	}
+
+	ids := make(map[int]bool, len(res))
+	for i := range res {
+		sc := &res[i]
+		if sc.ID == 0 {
+			return nil, errors.New("Не задано ID источника")
+		}
+		if ids[sc.ID] {
+			return nil, fmt.Errorf("Источник %d указан повторно", sc.ID)
+		}
+		ids[sc.ID] = true
+		switch sc.Type {
+		case sourceDropFolder:
+			if sc.Folders.Drop == "" {
+				return nil, fmt.Errorf("Не задана папка заказов источника %d", sc.ID)
+			}
+		case "", "pixlpark":
+			if sc.PublicKey == "" && sc.PrivateKey == "" {
+				sc.PublicKey, sc.PrivateKey = common.PublicKey, common.PrivateKey
+			}
+			if sc.PublicKey == "" || sc.PrivateKey == "" {
+				return nil, fmt.Errorf("Не заданы параметры oauth источника %d", sc.ID)
+			}
+		default:
+			return nil, fmt.Errorf("Неизвестный тип источника %d: %s", sc.ID, sc.Type)
+		}
+		if sc.User == "" {
+			sc.User = common.User
+		}
+		if sc.Production == 0 {
+			sc.Production = common.Production
+		}
+		if len(sc.Routing.Rules) == 0 && sc.Routing.Default == 0 {
+			sc.Routing = common.Routing
+		}
+		if sc.Threads == 0 {
+			sc.Threads = common.Threads
+		}
+		if len(res) > 1 {
+			sub := strconv.Itoa(sc.ID)
+			sc.Folders.Zip = sourceFolder(sc.Folders.Zip, common.Folders.Zip, sub)
+			sc.Folders.In = sourceFolder(sc.Folders.In, common.Folders.In, sub)
+			sc.Folders.Prn = sourceFolder(sc.Folders.Prn, common.Folders.Prn, sub)
+			sc.Folders.Thumbs = sourceFolder(sc.Folders.Thumbs, common.Folders.Thumbs, sub)
+			sc.Folders.Archive = sourceFolder(sc.Folders.Archive, common.Folders.Archive, sub)
+		}
+	}
+	return res, nil
+}
+
+//sourceFolder returns folder if set, else source subfolder of common folder (empty if common is empty)
+func sourceFolder(folder, common, sub string) string {
+	if folder != "" || common == "" {
+		return folder
+	}
+	return filepath.Join(common, sub)
+}
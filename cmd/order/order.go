@@ -20,7 +20,13 @@ import (
 func main() {
 
 	var oid string
-	if len(os.Args) > 1 {
+	//retransform <cycle order id> - rebuild one order item into its cycle order
+	retransform := len(os.Args) > 1 && os.Args[1] == "retransform"
+	if retransform {
+		if len(os.Args) > 2 {
+			oid = os.Args[2]
+		}
+	} else if len(os.Args) > 1 {
 		oid = os.Args[1]
 	}
 	if oid == "" {
@@ -75,6 +81,17 @@ func main() {
 		return
 	}
 	fc := transform.NewFactory(ttClient, rep, viper.GetInt("source.id"), viper.GetInt("production"), viper.GetString("folders.zip"), viper.GetString("folders.in"), viper.GetString("folders.prn"), viper.GetString("pixelpark.user"), log.With(logger, "level", "factory"))
+	if retransform {
+		fmt.Printf("Подготовка элемента заказа %s\n", oid)
+		order, err := fc.RetransformItem(context.Background(), oid)
+		if err != nil {
+			logger.Log("RetransformError", err.Error())
+			fmt.Printf("Ошибка обработки элемента заказа %s\n", err.Error())
+			return
+		}
+		fmt.Printf("Элемент заказа обработан, cycle id %s, статус %d\n", order.ID, order.State)
+		return
+	}
 	fmt.Println("Run pixel in debug mode.")
 	fc.SetDebug(true)
 	//oid := "1874839**"
@@ -3,11 +3,19 @@ package evropochta
 import (
 	"bytes"
 	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 
 	log "github.com/go-kit/kit/log"
@@ -15,6 +23,10 @@ import (
 
 type Evropochta interface {
 	GetPostSticker(ctx context.Context, trackNum string) (*Sticker, error)
+	GetPostStickers(ctx context.Context, trackNums []string) (*Sticker, error)
+	CreateShipment(ctx context.Context, s Shipment) (string, error)
+	GetTracking(ctx context.Context, trackNum string) ([]TrackEvent, error)
+	CancelShipment(ctx context.Context, trackNum string) error
 	GetToken(ctx context.Context) error
 	HasToken() bool
 }
@@ -25,6 +37,12 @@ type Sticker struct {
 	LoсalPath string
 }
 
+//token lifetime if JWT has no exp claim
+const defaultTokenTTL = 30 * time.Minute
+
+//token is refreshed this time before expiration
+const tokenRefreshMargin = time.Minute
+
 type client struct {
 	client        *http.Client
 	url           *url.URL
@@ -32,15 +50,29 @@ type client struct {
 	pass          string
 	serviceNumber string
 	outFolder     string
-	jwt           string
+	cacheTTL      time.Duration
 	logger        log.Logger
+
+	mu        sync.Mutex // guards jwt, jwtExpire
+	jwt       string
+	jwtExpire time.Time
 }
 
-func NewClient(baseURL, user, pass, serviceNumber, outFolder string, logger log.Logger) (Evropochta, error) {
+//NewClient creates Evropochta client
+//stickers are cached in outFolder for cacheTTL, empty outFolder or zero cacheTTL disables cache
+func NewClient(baseURL, user, pass, serviceNumber, outFolder string, cacheTTL time.Duration, logger log.Logger) (Evropochta, error) {
 	u, err := url.Parse(baseURL)
 	if err != nil {
 		return nil, err
 	}
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	if outFolder != "" && cacheTTL > 0 {
+		if err = os.MkdirAll(outFolder, 0755); err != nil {
+			return nil, err
+		}
+	}
 
 	return &client{
 		url:           u,
@@ -48,89 +80,179 @@ func NewClient(baseURL, user, pass, serviceNumber, outFolder string, logger log.
 		pass:          pass,
 		serviceNumber: serviceNumber,
 		outFolder:     outFolder,
+		cacheTTL:      cacheTTL,
 		client:        &http.Client{Timeout: time.Second * 10},
 		logger:        logger,
 	}, nil
 }
 
 func (c *client) HasToken() bool {
-	return c.jwt != ""
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.jwt != "" && time.Now().Before(c.jwtExpire)
 }
 
-func (c *client) GetPostSticker(ctx context.Context, trackNum string) (*Sticker, error) {
-	if c.jwt == "" {
-		err := c.GetToken(ctx)
-		if err != nil {
-			return nil, err
+//token returns valid token, gets new one if empty or expired
+func (c *client) token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.jwt != "" && time.Now().Add(tokenRefreshMargin).Before(c.jwtExpire) {
+		return c.jwt, nil
+	}
+	jwt, err := c.requestToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.jwt = jwt
+	c.jwtExpire = tokenExpire(jwt)
+	return c.jwt, nil
+}
+
+//resetToken drops token if it is still the same (not refreshed by concurrent request)
+func (c *client) resetToken(jwt string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.jwt == jwt {
+		c.jwt = ""
+		c.jwtExpire = time.Time{}
+	}
+}
+
+//tokenExpire reads exp claim from JWT payload
+func tokenExpire(jwt string) time.Time {
+	parts := strings.Split(jwt, ".")
+	if len(parts) == 3 {
+		payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+		if err == nil {
+			var claims struct {
+				Exp int64 `json:"exp"`
+			}
+			if err = json.Unmarshal(payload, &claims); err == nil && claims.Exp > 0 {
+				return time.Unix(claims.Exp, 0)
+			}
 		}
 	}
+	return time.Now().Add(defaultTokenTTL)
+}
 
-	param := requestParams{
-		Packet: baseParams{
-			JWT:           c.jwt,
-			MethodName:    "Postal.GetPDF",
-			ServiceNumber: c.serviceNumber,
-			Data: getStickerData{
-				SerialNumber: []getStickerSerialNumber{{SerialNumber: trackNum}},
-			},
-		},
+func (c *client) GetPostSticker(ctx context.Context, trackNum string) (*Sticker, error) {
+	return c.GetPostStickers(ctx, []string{trackNum})
+}
+
+//GetPostStickers loads stickers for trackNums in one pdf
+func (c *client) GetPostStickers(ctx context.Context, trackNums []string) (*Sticker, error) {
+	trackNums = uniqueTrackNums(trackNums)
+	if len(trackNums) == 0 {
+		return nil, fmt.Errorf("empty track numbers list")
 	}
-	buffer := new(bytes.Buffer)
-	err := json.NewEncoder(buffer).Encode(param)
-	if err != nil {
-		return nil, err
+	sticker := &Sticker{
+		FileName: stickerFileName(trackNums),
+	}
+	if c.cacheEnabled() {
+		sticker.LoсalPath = filepath.Join(c.outFolder, sticker.FileName)
+		if data, ok := c.cacheGet(sticker.LoсalPath); ok {
+			sticker.FileData = data
+			return sticker, nil
+		}
 	}
 
-	request, err := http.NewRequestWithContext(ctx, "POST", c.url.String(), buffer)
+	var err error
+	sticker.FileData, err = c.getPDF(ctx, trackNums)
 	if err != nil {
 		return nil, err
 	}
 
-	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("Accept", "*/*")
-	resp, err := c.client.Do(request)
-	if err != nil {
-		c.jwt = ""
-		return nil, err
+	if c.cacheEnabled() {
+		if err := c.cachePut(sticker.LoсalPath, sticker.FileData); err != nil {
+			c.logger.Log("cache", sticker.LoсalPath, "error", err.Error())
+			sticker.LoсalPath = ""
+		}
 	}
+	return sticker, nil
+}
 
-	defer resp.Body.Close()
+//getPDF calls Postal.GetPDF, retries once with new token on error response
+func (c *client) getPDF(ctx context.Context, trackNums []string) ([]byte, error) {
+	var err error
+	var data []byte
+	for attempt := 0; attempt < 2; attempt++ {
+		var jwt string
+		jwt, err = c.token(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var errResp *errResponseItem
+		data, errResp, err = c.requestPDF(ctx, jwt, trackNums)
+		if err != nil {
+			return nil, err
+		}
+		if errResp == nil {
+			return data, nil
+		}
+		//token can be expired or revoked, reset and try again
+		c.resetToken(jwt)
+		err = fmt.Errorf("error while getting sticker: %s, %s, %s", errResp.Error, errResp.ErrorDescription, errResp.ErrorInfo)
+	}
+	return nil, err
+}
 
-	sticker := &Sticker{
-		FileName: fmt.Sprintf("%s.pdf", trackNum),
+func (c *client) requestPDF(ctx context.Context, jwt string, trackNums []string) ([]byte, *errResponseItem, error) {
+	serials := make([]getStickerSerialNumber, 0, len(trackNums))
+	for _, n := range trackNums {
+		serials = append(serials, getStickerSerialNumber{SerialNumber: n})
+	}
+	param := requestParams{
+		Packet: baseParams{
+			JWT:           jwt,
+			MethodName:    "Postal.GetPDF",
+			ServiceNumber: c.serviceNumber,
+			Data: getStickerData{
+				SerialNumber: serials,
+			},
+		},
 	}
-	sticker.FileData, err = ioutil.ReadAll(resp.Body)
+	data, status, err := c.post(ctx, param)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	switch resp.StatusCode {
+	switch status {
 	case http.StatusOK:
-		if len(sticker.FileData) == 0 {
-			c.jwt = ""
-			return nil, fmt.Errorf("got empty response body")
+		if len(data) == 0 {
+			return nil, nil, fmt.Errorf("got empty response body")
 		}
 		// Try to parse error
 		var rawResp genericResponse
-		err = json.Unmarshal(sticker.FileData, &rawResp)
+		err = json.Unmarshal(data, &rawResp)
 		if err == nil && len(rawResp.Table) != 0 {
 			// some error response
-			c.jwt = ""
 			var errResp errResponseItem
 			err = json.Unmarshal(rawResp.Table[0], &errResp)
 			if err != nil {
-				return nil, fmt.Errorf("error while decoding to `Error response`: %w", err)
+				return nil, nil, fmt.Errorf("error while decoding to `Error response`: %w", err)
 			}
-			return nil, fmt.Errorf("error while getting sticker: %s, %s, %s", errResp.Error, errResp.ErrorDescription, errResp.ErrorInfo)
+			return nil, &errResp, nil
 		}
 	default:
-		return nil, fmt.Errorf("error while getting sticker, status: %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+		return nil, nil, fmt.Errorf("error while getting sticker, status: %d %s", status, http.StatusText(status))
 	}
-
-	return sticker, nil
+	return data, nil, nil
 }
 
 func (c *client) GetToken(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	jwt, err := c.requestToken(ctx)
+	if err != nil {
+		return err
+	}
+	c.jwt = jwt
+	c.jwtExpire = tokenExpire(jwt)
+	return nil
+}
+
+//requestToken calls GetJWT
+func (c *client) requestToken(ctx context.Context) (string, error) {
 	param := requestParams{
 		Packet: baseParams{
 			JWT:           "null",
@@ -143,62 +265,140 @@ func (c *client) GetToken(ctx context.Context) error {
 			},
 		},
 	}
-	buffer := new(bytes.Buffer)
-	err := json.NewEncoder(buffer).Encode(param)
+	data, status, err := c.post(ctx, param)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	request, err := http.NewRequestWithContext(ctx, "POST", c.url.String(), buffer)
-	if err != nil {
-		return err
-	}
-	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("Accept", "*/*")
-
-	resp, err := c.client.Do(request)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-
-	switch resp.StatusCode {
+	switch status {
 	case http.StatusOK:
 		var rawResp genericResponse
 		err = json.Unmarshal(data, &rawResp)
 		if err != nil {
-			return fmt.Errorf("error while decoding `Table` response: %w", err)
+			return "", fmt.Errorf("error while decoding `Table` response: %w", err)
 		}
 		if len(rawResp.Table) == 0 {
-			return fmt.Errorf("empty response, Table is empty")
+			return "", fmt.Errorf("empty response, Table is empty")
 		}
 
 		var errResp errResponseItem
 		err = json.Unmarshal(rawResp.Table[0], &errResp)
 		if err != nil {
-			return fmt.Errorf("error while decoding to `Error response`: %w", err)
+			return "", fmt.Errorf("error while decoding to `Error response`: %w", err)
 		}
 		if errResp.Error != "" {
-			return fmt.Errorf("error while getting token: %s, %s, %s", errResp.Error, errResp.ErrorDescription, errResp.ErrorInfo)
+			return "", fmt.Errorf("error while getting token: %s, %s, %s", errResp.Error, errResp.ErrorDescription, errResp.ErrorInfo)
 		}
 
 		var tokenResp getTokenResponseItem
 		err = json.Unmarshal(rawResp.Table[0], &tokenResp)
 		if err != nil {
-			return fmt.Errorf("error while decoding to `token response`: %w", err)
+			return "", fmt.Errorf("error while decoding to `token response`: %w", err)
 		}
 		if tokenResp.JWT == "" {
-			return fmt.Errorf("got empty token, or unknown struct")
+			return "", fmt.Errorf("got empty token, or unknown struct")
 		}
-
-		c.jwt = tokenResp.JWT
-		return nil
+		return tokenResp.JWT, nil
 	default:
-		return fmt.Errorf("error while getting token status: %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+		return "", fmt.Errorf("error while getting token status: %d %s", status, http.StatusText(status))
+	}
+}
+
+//post sends packet, returns response body and http status
+func (c *client) post(ctx context.Context, param requestParams) ([]byte, int, error) {
+	buffer := new(bytes.Buffer)
+	err := json.NewEncoder(buffer).Encode(param)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", c.url.String(), buffer)
+	if err != nil {
+		return nil, 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "*/*")
+
+	resp, err := c.client.Do(request)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, resp.StatusCode, nil
+}
+
+func (c *client) cacheEnabled() bool {
+	return c.outFolder != "" && c.cacheTTL > 0
+}
+
+//cacheGet reads cached sticker, expired file is removed
+func (c *client) cacheGet(path string) ([]byte, bool) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	if time.Since(fi.ModTime()) > c.cacheTTL {
+		os.Remove(path)
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil || len(data) == 0 {
+		return nil, false
+	}
+	return data, true
+}
+
+//cachePut writes sticker to temp file then renames it, so concurrent readers never get partial file
+func (c *client) cachePut(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+//uniqueTrackNums removes empty and duplicated track numbers, keeps order
+func uniqueTrackNums(trackNums []string) []string {
+	res := make([]string, 0, len(trackNums))
+	added := make(map[string]bool, len(trackNums))
+	for _, n := range trackNums {
+		n = strings.TrimSpace(n)
+		if n == "" || added[n] {
+			continue
+		}
+		added[n] = true
+		res = append(res, n)
+	}
+	return res
+}
+
+//safeName track number that can be used as file name
+var safeName = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+
+//stickerFileName returns trackNum.pdf for one sticker or name by hash of track numbers for batch
+//pdf pages follow request order, so batch name depends on track numbers order
+func stickerFileName(trackNums []string) string {
+	if len(trackNums) == 1 && safeName.MatchString(trackNums[0]) {
+		return fmt.Sprintf("%s.pdf", trackNums[0])
 	}
+	h := sha1.Sum([]byte(strings.Join(trackNums, ",")))
+	return fmt.Sprintf("stickers_%d_%s.pdf", len(trackNums), hex.EncodeToString(h[:8]))
 }
@@ -2,17 +2,200 @@ package evropochta
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	pp "github.com/egorka-gh/pixlpark/pixlpark/service"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestClient_GetToken(t *testing.T) {
 
-	cl, err := NewClient("https://api.eurotorg.by:10352/Json", "user", "pass", "servNum", "", nil)
+	cl, err := NewClient("https://api.eurotorg.by:10352/Json", "user", "pass", "servNum", "", 0, nil)
 
 	assert.Nil(t, err)
 	err = cl.GetToken(context.TODO())
 	assert.Nil(t, err)
 	assert.True(t, cl.HasToken())
 }
+
+//fakeServer emulates evropochta packet protocol (GetJWT, Postal.GetPDF)
+type fakeServer struct {
+	mu        sync.Mutex
+	jwt       string
+	tokens    int
+	pdfs      int
+	lastBatch []string
+	shipments []putOrderData
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Packet struct {
+			JWT        string          `json:"JWT"`
+			MethodName string          `json:"MethodName"`
+			Data       json.RawMessage `json:"Data"`
+		} `json:"Packet"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch req.Packet.MethodName {
+	case "GetJWT":
+		f.tokens++
+		fmt.Fprintf(w, `{"Table":[{"JWT":"%s"}]}`, f.jwt)
+	case "Postal.GetPDF":
+		if req.Packet.JWT != f.jwt {
+			fmt.Fprint(w, `{"Table":[{"Error":"1","ErrorDescription":"JWT expired","ErrorInfo":""}]}`)
+			return
+		}
+		var data getStickerData
+		json.Unmarshal(req.Packet.Data, &data)
+		f.pdfs++
+		f.lastBatch = f.lastBatch[:0]
+		for _, s := range data.SerialNumber {
+			f.lastBatch = append(f.lastBatch, s.SerialNumber)
+		}
+		fmt.Fprintf(w, "%%PDF-1.4 %s", strings.Join(f.lastBatch, ","))
+	case methodPutOrder:
+		var data putOrderData
+		json.Unmarshal(req.Packet.Data, &data)
+		f.shipments = append(f.shipments, data)
+		fmt.Fprintf(w, `{"Table":[{"Number":"BY%s"}]}`, data.CustomerOrderID)
+	case methodTracking:
+		fmt.Fprint(w, `{"Table":[{"Timex":"2021-03-01","InfoTrack":"Принято","Place":"Минск"},{"Timex":"2021-03-02","InfoTrack":"Вручено","Place":"Гомель"}]}`)
+	case methodCancelOrder:
+		fmt.Fprint(w, `{"Table":[{"Error":"","Result":"OK"}]}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func fakeJWT(exp time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, exp.Unix())))
+	return "header." + payload + ".sign"
+}
+
+func TestClient_GetPostStickers(t *testing.T) {
+	fake := &fakeServer{jwt: fakeJWT(time.Now().Add(time.Hour))}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	folder, err := ioutil.TempDir("", "evropochta")
+	assert.Nil(t, err)
+	defer os.RemoveAll(folder)
+
+	cl, err := NewClient(ts.URL, "user", "pass", "servNum", folder, time.Hour, nil)
+	assert.Nil(t, err)
+
+	st, err := cl.GetPostStickers(context.Background(), []string{"BY1", "BY2", "BY1", ""})
+	assert.Nil(t, err)
+	assert.Equal(t, "%PDF-1.4 BY1,BY2", string(st.FileData))
+	assert.Equal(t, []string{"BY1", "BY2"}, fake.lastBatch)
+	assert.FileExists(t, st.LoсalPath)
+
+	//reprint from cache
+	st, err = cl.GetPostStickers(context.Background(), []string{"BY1", "BY2"})
+	assert.Nil(t, err)
+	assert.Equal(t, "%PDF-1.4 BY1,BY2", string(st.FileData))
+	assert.Equal(t, 1, fake.pdfs)
+	assert.Equal(t, 1, fake.tokens)
+
+	//same batch in other order, pages follow request order
+	st, err = cl.GetPostStickers(context.Background(), []string{"BY2", "BY1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "%PDF-1.4 BY2,BY1", string(st.FileData))
+	assert.Equal(t, 2, fake.pdfs)
+
+	st, err = cl.GetPostSticker(context.Background(), "BY3")
+	assert.Nil(t, err)
+	assert.Equal(t, "BY3.pdf", st.FileName)
+	assert.Equal(t, 3, fake.pdfs)
+	assert.Equal(t, 1, fake.tokens)
+}
+
+func TestClient_TokenLifecycle(t *testing.T) {
+	//expired token
+	fake := &fakeServer{jwt: fakeJWT(time.Now().Add(-time.Minute))}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	cl, err := NewClient(ts.URL, "user", "pass", "servNum", "", 0, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, cl.GetToken(context.Background()))
+	assert.False(t, cl.HasToken())
+
+	//refresh expired before request
+	fake.mu.Lock()
+	fake.jwt = fakeJWT(time.Now().Add(time.Hour))
+	fake.mu.Unlock()
+	_, err = cl.GetPostSticker(context.Background(), "BY1")
+	assert.Nil(t, err)
+	assert.True(t, cl.HasToken())
+	assert.Equal(t, 2, fake.tokens)
+
+	//token revoked by server, client retries with new token
+	fake.mu.Lock()
+	fake.jwt = fakeJWT(time.Now().Add(2 * time.Hour))
+	fake.mu.Unlock()
+	_, err = cl.GetPostSticker(context.Background(), "BY2")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, fake.tokens)
+
+	//concurrent requests share one token
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := cl.GetPostSticker(context.Background(), fmt.Sprintf("BY%d", i))
+			assert.Nil(t, err)
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 3, fake.tokens)
+}
+
+func TestClient_Shipment(t *testing.T) {
+	fake := &fakeServer{jwt: fakeJWT(time.Now().Add(time.Hour))}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	cl, err := NewClient(ts.URL, "user", "pass", "servNum", "", 0, nil)
+	assert.Nil(t, err)
+
+	order := &pp.Order{
+		ID:              "1874839",
+		DeliveryAddress: pp.DeliveryAddress{FullName: "иван петров", ZipCode: "220000", City: "Минск", Phone: "+375291111111"},
+		TotalPrice:      50,
+		PaidPrice:       20,
+	}
+	s := NewShipment(order)
+	assert.Equal(t, "Петров Иван", s.FullName)
+	assert.Equal(t, 30.0, s.CashOnDelivery)
+
+	track, err := cl.CreateShipment(context.Background(), s)
+	assert.Nil(t, err)
+	assert.Equal(t, "BY1874839", track)
+	assert.Equal(t, "30.00", fake.shipments[0].CashOnDeliverySum)
+
+	_, err = cl.CreateShipment(context.Background(), Shipment{OrderID: "1"})
+	assert.NotNil(t, err)
+
+	events, err := cl.GetTracking(context.Background(), track)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, "Вручено", events[1].Status)
+
+	assert.Nil(t, cl.CancelShipment(context.Background(), track))
+}
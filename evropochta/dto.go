@@ -41,3 +41,35 @@ type getTokenResponseItem struct {
 type genericResponse struct {
 	Table []json.RawMessage `json:"Table"`
 }
+
+type putOrderData struct {
+	GoodsID             string `json:"GoodsId"`
+	PostDeliveryTypeID  string `json:"PostDeliveryTypeId"`
+	CashOnDeliverySum   string `json:"CashOnDeliverySum"`
+	Name                string `json:"Name"`
+	PhoneNumberReciever string `json:"PhoneNumberReciever"`
+	PostalCode          string `json:"PostalCode"`
+	Region              string `json:"Region"`
+	City                string `json:"City"`
+	Street              string `json:"Street"`
+	House               string `json:"House"`
+	CustomerOrderID     string `json:"CustomerOrderId"`
+}
+
+type putOrderResponseItem struct {
+	Number string `json:"Number"`
+}
+
+type trackingData struct {
+	Number string `json:"Number"`
+}
+
+type trackingResponseItem struct {
+	Timex     string `json:"Timex"`
+	InfoTrack string `json:"InfoTrack"`
+	Place     string `json:"Place"`
+}
+
+type cancelOrderData struct {
+	Number string `json:"Number"`
+}
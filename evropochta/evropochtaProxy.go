@@ -1,19 +1,35 @@
 package evropochta
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/render"
 	"github.com/go-kit/kit/log"
+
+	pp "github.com/egorka-gh/pixlpark/pixlpark/service"
 )
 
 //HandlerConfig to create mux
 type HandlerConfig struct {
 	Client Evropochta
 	Logger log.Logger
+	//PixelClient to load orders and write back track numbers, shipment routes are disabled if nil
+	PixelClient pp.PPService
+	//GoodsID and DeliveryTypeID are used to create shipments
+	GoodsID        string
+	DeliveryTypeID string
+	//TrackingURL format of tracking page url (%s - track number), optional
+	TrackingURL string
+	//FontFile UTF-8 TTF font for labels manifest, optional
+	FontFile string
 }
 
 type proxy struct {
@@ -47,6 +63,24 @@ func createRouter(config *HandlerConfig) *chi.Mux {
 		r.Route("/sticker/{trackNum}", func(r chi.Router) {
 			r.Get("/", config.GetSticker)
 		})
+
+		//merged stickers of batch (track and/or order params), cycle allways uses Post
+		r.Route("/stickers", func(r chi.Router) {
+			r.Get("/", config.GetStickers)
+			r.Post("/", config.GetStickers)
+		})
+
+		//create shipment for pp order, cycle allways uses Post
+		r.Route("/shipment/{orderID}", func(r chi.Router) {
+			r.Use(config.OrderCtx)
+			r.Post("/", config.CreateShipment)
+			r.Post("/cancel", config.CancelShipment)
+		})
+
+		r.Route("/tracking/{trackNum}", func(r chi.Router) {
+			r.Get("/", config.GetTracking)
+			r.Post("/", config.GetTracking)
+		})
 	})
 	return r
 }
@@ -75,6 +109,226 @@ func (c *HandlerConfig) GetSticker(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+//GetStickers returns one pdf with manifest and stickers of all requested items.
+//Params: track - track numbers, order - pp order ids (both repeatable or comma separated), nup - stickers per A4 page.
+//Failed items are listed in manifest and counted in X-Labels-Failed header.
+func (c *HandlerConfig) GetStickers(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	nUp := 0
+	if v := r.Form.Get("nup"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			render.Render(w, r, ErrInvalidRequest(fmt.Errorf("wrong nup %q", v)))
+			return
+		}
+		nUp = n
+	}
+
+	items := make([]*LabelItem, 0)
+	for _, id := range formList(r.Form["order"]) {
+		items = append(items, c.orderLabel(r.Context(), id))
+	}
+	for _, t := range formList(r.Form["track"]) {
+		items = append(items, &LabelItem{TrackNum: t})
+	}
+	if len(items) == 0 {
+		render.Render(w, r, ErrInvalidRequest(errors.New("empty track and order list")))
+		return
+	}
+
+	for _, it := range items {
+		if it.Err == "" {
+			sticker, err := c.Client.GetPostSticker(r.Context(), it.TrackNum)
+			if err != nil {
+				c.Logger.Log("track", it.TrackNum, "GetPostSticker", err.Error())
+				it.Err = err.Error()
+			} else {
+				it.Data = sticker.FileData
+			}
+		}
+	}
+
+	sheet := LabelSheet{NUp: nUp, FontFile: c.FontFile}
+	data, err := sheet.Build(items)
+	if err != nil {
+		c.Logger.Log("labels", err.Error())
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	failed := 0
+	for _, it := range items {
+		if it.Err != "" {
+			failed++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=labels_%s.pdf", time.Now().Format("20060102_150405")))
+	w.Header().Set("X-Labels-Total", strconv.Itoa(len(items)))
+	w.Header().Set("X-Labels-Failed", strconv.Itoa(failed))
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		c.Logger.Log(err.Error())
+	}
+}
+
+//orderLabel loads pp order track number and recipient
+func (c *HandlerConfig) orderLabel(ctx context.Context, orderID string) *LabelItem {
+	it := &LabelItem{OrderID: orderID}
+	if c.PixelClient == nil {
+		it.Err = "pixelpark client not configured"
+		return it
+	}
+	order, err := c.PixelClient.GetOrder(ctx, orderID)
+	if err != nil {
+		c.Logger.Log("order", orderID, "GetOrder", err.Error())
+		it.Err = err.Error()
+		return it
+	}
+	it.Recipient = order.MailProperties()["lastname"]
+	it.TrackNum = order.TrackingNumber
+	if it.TrackNum == "" {
+		it.Err = "no track number"
+	}
+	return it
+}
+
+//formList splits comma separated values, skips empty
+func formList(values []string) []string {
+	res := make([]string, 0, len(values))
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				res = append(res, s)
+			}
+		}
+	}
+	return res
+}
+
+// OrderCtx middleware is used to load an Order object from pixelpark.
+func (c *HandlerConfig) OrderCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.PixelClient == nil {
+			render.Render(w, r, ErrNotConfigured)
+			return
+		}
+		orderID := chi.URLParam(r, "orderID")
+		if orderID == "" {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		order, err := c.PixelClient.GetOrder(r.Context(), orderID)
+		if err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		ctx := context.WithValue(r.Context(), orderKey, &order)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+//CreateShipment registers postal item for pp order and writes track number back to pp
+//if order allready has track number returns it, use force=true to create new one
+func (c *HandlerConfig) CreateShipment(w http.ResponseWriter, r *http.Request) {
+	order := r.Context().Value(orderKey).(*pp.Order)
+	if order.TrackingNumber != "" && r.FormValue("force") != "true" {
+		render.Render(w, r, &ShipmentResponse{OrderID: order.ID, TrackNum: order.TrackingNumber})
+		return
+	}
+
+	s := NewShipment(order)
+	s.GoodsID = c.GoodsID
+	s.DeliveryTypeID = c.DeliveryTypeID
+	trackNum, err := c.Client.CreateShipment(r.Context(), s)
+	if err != nil {
+		c.Logger.Log("order", order.ID, "CreateShipment", err.Error())
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	resp := &ShipmentResponse{OrderID: order.ID, TrackNum: trackNum, Created: true}
+	if err = c.PixelClient.SetTrackingNumber(r.Context(), order.ID, trackNum, c.trackingURL(trackNum)); err != nil {
+		//shipment is created, just report
+		c.Logger.Log("order", order.ID, "track", trackNum, "SetTrackingNumber", err.Error())
+		resp.Warning = err.Error()
+	}
+	render.Render(w, r, resp)
+}
+
+//CancelShipment cancels order postal item and clears track number in pp
+func (c *HandlerConfig) CancelShipment(w http.ResponseWriter, r *http.Request) {
+	order := r.Context().Value(orderKey).(*pp.Order)
+	if order.TrackingNumber == "" {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err := c.Client.CancelShipment(r.Context(), order.TrackingNumber); err != nil {
+		c.Logger.Log("order", order.ID, "CancelShipment", err.Error())
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	resp := &ShipmentResponse{OrderID: order.ID, TrackNum: order.TrackingNumber}
+	if err := c.PixelClient.SetTrackingNumber(r.Context(), order.ID, "", ""); err != nil {
+		c.Logger.Log("order", order.ID, "SetTrackingNumber", err.Error())
+		resp.Warning = err.Error()
+	}
+	render.Render(w, r, resp)
+}
+
+//GetTracking returns postal item tracking history
+func (c *HandlerConfig) GetTracking(w http.ResponseWriter, r *http.Request) {
+	trackNum := chi.URLParam(r, "trackNum")
+	if trackNum == "" {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	events, err := c.Client.GetTracking(r.Context(), trackNum)
+	if err != nil {
+		c.Logger.Log("track", trackNum, "GetTracking", err.Error())
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	render.Render(w, r, &TrackingResponse{TrackNum: trackNum, Events: events})
+}
+
+func (c *HandlerConfig) trackingURL(trackNum string) string {
+	if c.TrackingURL == "" {
+		return ""
+	}
+	return fmt.Sprintf(c.TrackingURL, trackNum)
+}
+
+type ctxKey string
+
+const orderKey ctxKey = "order"
+
+//ShipmentResponse is create/cancel shipment response
+type ShipmentResponse struct {
+	OrderID  string `json:"order_id"`
+	TrackNum string `json:"track"`
+	Created  bool   `json:"created"`
+	Warning  string `json:"warning,omitempty"`
+}
+
+//Render implement Renderer
+func (s *ShipmentResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+//TrackingResponse is tracking history response
+type TrackingResponse struct {
+	TrackNum string       `json:"track"`
+	Events   []TrackEvent `json:"events"`
+}
+
+//Render implement Renderer
+func (t *TrackingResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
 //--
 // Error response payloads & renderers
 //--
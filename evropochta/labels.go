@@ -0,0 +1,236 @@
+package evropochta
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/jung-kurt/gofpdf/contrib/gofpdi"
+	realgofpdi "github.com/phpdave11/gofpdi"
+)
+
+//LabelItem is one postal item of labels batch
+type LabelItem struct {
+	OrderID   string
+	Recipient string
+	TrackNum  string
+	//Err is item failure, item is listed in manifest but has no sticker
+	Err string
+	//Data sticker pdf
+	Data []byte
+
+	pages int
+	sizes map[int]map[string]map[string]float64
+}
+
+//LabelSheet lays out stickers of batch into one pdf
+type LabelSheet struct {
+	//NUp stickers per A4 page (1, 2, 4, 6, 8), 0 - keep sticker page size
+	NUp int
+	//FontFile UTF-8 TTF font for manifest, if empty core font is used and cyrillic is transliterated
+	FontFile string
+}
+
+//a4 size, margin and gap between stickers in mm
+const (
+	a4Width   = 210.0
+	a4Height  = 297.0
+	sheetGap  = 5.0
+	ptToMM    = 25.4 / 72
+	pageBox   = "/MediaBox"
+	fontAlias = "manifest"
+)
+
+//nUpGrid columns x rows of a4 page
+var nUpGrid = map[int][2]int{
+	1: {1, 1},
+	2: {1, 2},
+	4: {2, 2},
+	6: {2, 3},
+	8: {2, 4},
+}
+
+//Build returns merged pdf, first page(s) is manifest, then stickers of not failed items.
+//Item that sticker can't be read is marked as failed, other items are not affected.
+func (s LabelSheet) Build(items []*LabelItem) ([]byte, error) {
+	if s.NUp != 0 {
+		if _, ok := nUpGrid[s.NUp]; !ok {
+			return nil, fmt.Errorf("unsupported n-up %d, expected one of 0, 1, 2, 4, 6, 8", s.NUp)
+		}
+	}
+	//check stickers before pdf is touched, gofpdi panics on broken pdf
+	for _, it := range items {
+		if it.Err == "" {
+			it.Err = inspectSticker(it)
+		}
+	}
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetCreator("pixlpark evropochta", true)
+	pdf.SetMargins(10, 10, 10)
+	pdf.SetAutoPageBreak(true, 10)
+	s.manifest(pdf, items)
+	if err := pdf.Error(); err != nil {
+		return nil, err
+	}
+
+	imp := gofpdi.NewImporter()
+	//gofpdi keys sources by reader address, so slice is allocated once and never grows
+	readers := make([]io.ReadSeeker, len(items))
+	cell := 0
+	for i, it := range items {
+		if it.Err != "" {
+			continue
+		}
+		readers[i] = bytes.NewReader(it.Data)
+		for p := 1; p <= it.pages; p++ {
+			tpl := imp.ImportPageFromStream(pdf, &readers[i], p, pageBox)
+			w, h := it.sizes[p][pageBox]["w"]*ptToMM, it.sizes[p][pageBox]["h"]*ptToMM
+			if s.NUp == 0 {
+				pdf.AddPageFormat("P", gofpdf.SizeType{Wd: w, Ht: h})
+				imp.UseImportedTemplate(pdf, tpl, 0, 0, w, h)
+				continue
+			}
+			if cell%s.NUp == 0 {
+				pdf.AddPageFormat("P", gofpdf.SizeType{Wd: a4Width, Ht: a4Height})
+			}
+			x, y, cw, ch := s.fit(cell%s.NUp, w, h)
+			imp.UseImportedTemplate(pdf, tpl, x, y, cw, ch)
+			cell++
+		}
+	}
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//fit returns position and size of sticker w x h in n-up cell idx, aspect ratio is kept
+func (s LabelSheet) fit(idx int, w, h float64) (x, y, fw, fh float64) {
+	grid := nUpGrid[s.NUp]
+	cols, rows := grid[0], grid[1]
+	cellW := (a4Width - sheetGap*float64(cols+1)) / float64(cols)
+	cellH := (a4Height - sheetGap*float64(rows+1)) / float64(rows)
+	scale := cellW / w
+	if sh := cellH / h; sh < scale {
+		scale = sh
+	}
+	fw, fh = w*scale, h*scale
+	col, row := idx%cols, idx/cols
+	x = sheetGap + float64(col)*(cellW+sheetGap) + (cellW-fw)/2
+	y = sheetGap + float64(row)*(cellH+sheetGap) + (cellH-fh)/2
+	return
+}
+
+//manifest writes batch list
+func (s LabelSheet) manifest(pdf *gofpdf.Fpdf, items []*LabelItem) {
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	txt := func(s string) string { return tr(translit(s)) }
+	family := "Helvetica"
+	if s.FontFile != "" {
+		pdf.AddUTF8Font(fontAlias, "", s.FontFile)
+		if pdf.Err() {
+			//font is optional, fallback to core font
+			pdf.ClearError()
+		} else {
+			family = fontAlias
+			txt = func(s string) string { return s }
+		}
+	}
+
+	failed := 0
+	for _, it := range items {
+		if it.Err != "" {
+			failed++
+		}
+	}
+
+	pdf.AddPage()
+	pdf.SetFont(family, "", 14)
+	pdf.CellFormat(0, 8, txt(fmt.Sprintf("Манифест %s", time.Now().Format("02.01.2006 15:04"))), "", 1, "L", false, 0, "")
+	pdf.SetFont(family, "", 10)
+	pdf.CellFormat(0, 6, txt(fmt.Sprintf("Всего: %d, ошибок: %d", len(items), failed)), "", 1, "L", false, 0, "")
+	pdf.Ln(2)
+
+	widths := []float64{10, 25, 60, 40, 55}
+	header := []string{"№", "Заказ", "Получатель", "Трек номер", "Статус"}
+	for i, h := range header {
+		pdf.CellFormat(widths[i], 7, txt(h), "1", 0, "C", false, 0, "")
+	}
+	pdf.Ln(-1)
+	for i, it := range items {
+		status := "OK"
+		if it.Err != "" {
+			status = it.Err
+		}
+		row := []string{fmt.Sprintf("%d", i+1), it.OrderID, it.Recipient, it.TrackNum, status}
+		for j, v := range row {
+			pdf.CellFormat(widths[j], 6, fitText(pdf, v, widths[j]-2, txt), "1", 0, "L", false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+}
+
+//inspectSticker reads sticker pages, returns error text if sticker can't be used
+func inspectSticker(it *LabelItem) (errText string) {
+	if len(it.Data) == 0 {
+		return "пустой стикер"
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			errText = fmt.Sprintf("не корректный pdf: %v", r)
+		}
+	}()
+	imp := realgofpdi.NewImporter()
+	var rs io.ReadSeeker = bytes.NewReader(it.Data)
+	imp.SetSourceStream(&rs)
+	it.pages = imp.GetNumPages()
+	it.sizes = imp.GetPageSizes()
+	if it.pages == 0 {
+		return "стикер без страниц"
+	}
+	for p := 1; p <= it.pages; p++ {
+		if it.sizes[p][pageBox]["w"] <= 0 || it.sizes[p][pageBox]["h"] <= 0 {
+			return fmt.Sprintf("не задан размер страницы %d", p)
+		}
+	}
+	return ""
+}
+
+//fitText cuts text to cell width, returns translated text
+func fitText(pdf *gofpdf.Fpdf, s string, width float64, txt func(string) string) string {
+	r := []rune(s)
+	for len(r) > 0 && pdf.GetStringWidth(txt(string(r))) > width {
+		r = r[:len(r)-1]
+	}
+	return txt(string(r))
+}
+
+var translitTable = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh", 'з': "z",
+	'и': "i", 'й': "y", 'і': "i", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p",
+	'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ў': "u", 'ф': "f", 'х': "h", 'ц': "ts", 'ч': "ch",
+	'ш': "sh", 'щ': "sch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya", '№': "N",
+}
+
+//translit converts cyrillic to latin, core pdf fonts have no cyrillic glyphs
+func translit(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		lr := []rune(strings.ToLower(string(r)))[0]
+		t, ok := translitTable[lr]
+		if !ok {
+			b.WriteRune(r)
+			continue
+		}
+		if lr != r && t != "" {
+			t = strings.ToUpper(t[:1]) + t[1:]
+		}
+		b.WriteString(t)
+	}
+	return b.String()
+}
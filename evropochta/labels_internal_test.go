@@ -0,0 +1,75 @@
+package evropochta
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+	realgofpdi "github.com/phpdave11/gofpdi"
+	"github.com/stretchr/testify/assert"
+)
+
+func testStickerPDF(t *testing.T, text string, pages int) []byte {
+	pdf := gofpdf.NewCustom(&gofpdf.InitType{UnitStr: "mm", Size: gofpdf.SizeType{Wd: 100, Ht: 150}})
+	pdf.SetFont("Helvetica", "", 12)
+	for i := 0; i < pages; i++ {
+		pdf.AddPage()
+		pdf.Text(10, 20, text)
+	}
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testPageCount(t *testing.T, data []byte) int {
+	imp := realgofpdi.NewImporter()
+	var rs io.ReadSeeker = bytes.NewReader(data)
+	imp.SetSourceStream(&rs)
+	return imp.GetNumPages()
+}
+
+func TestLabelSheet_Build(t *testing.T) {
+	newItems := func() []*LabelItem {
+		return []*LabelItem{
+			{OrderID: "100", Recipient: "Иванов Иван", TrackNum: "BY001", Data: testStickerPDF(t, "BY001", 1)},
+			{TrackNum: "BY002", Data: testStickerPDF(t, "BY002", 2)},
+			{TrackNum: "BY003", Data: []byte("not a pdf")},
+			{OrderID: "101", Err: "no track number"},
+		}
+	}
+	tests := []struct {
+		name  string
+		nUp   int
+		pages int
+	}{
+		{"original size", 0, 1 + 3},
+		{"1-up", 1, 1 + 3},
+		{"2-up", 2, 1 + 2},
+		{"4-up", 4, 1 + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := newItems()
+			data, err := LabelSheet{NUp: tt.nUp}.Build(items)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, tt.pages, testPageCount(t, data))
+			assert.Empty(t, items[0].Err)
+			assert.Empty(t, items[1].Err)
+			assert.NotEmpty(t, items[2].Err, "broken sticker must be reported")
+			assert.Equal(t, "no track number", items[3].Err)
+		})
+	}
+
+	_, err := LabelSheet{NUp: 3}.Build(newItems())
+	assert.Error(t, err)
+}
+
+func TestTranslit(t *testing.T) {
+	assert.Equal(t, "Ivanov Ivan, Minsk 12", translit("Иванов Иван, Минск 12"))
+	assert.Equal(t, "Schuchin", translit("Щучин"))
+}
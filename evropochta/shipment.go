@@ -0,0 +1,164 @@
+package evropochta
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	pp "github.com/egorka-gh/pixlpark/pixlpark/service"
+)
+
+//Evropochta API methods
+const (
+	methodPutOrder    = "Postal.PutOrder"
+	methodTracking    = "Postal.Tracking"
+	methodCancelOrder = "Postal.CancelOrder"
+)
+
+//Shipment describes postal item to register in Evropochta
+type Shipment struct {
+	OrderID  string
+	FullName string
+	Phone    string
+	PostCode string
+	Region   string
+	City     string
+	Street   string
+	House    string
+	//CashOnDelivery sum to collect from recipient
+	CashOnDelivery float64
+	//GoodsID and DeliveryTypeID are Evropochta directory ids (contract specific)
+	GoodsID        string
+	DeliveryTypeID string
+}
+
+//TrackEvent is postal item tracking history record
+type TrackEvent struct {
+	Date   string `json:"date"`
+	Status string `json:"status"`
+	Place  string `json:"place"`
+}
+
+//NewShipment creates Shipment from PP order delivery address
+func NewShipment(order *pp.Order) Shipment {
+	props := order.MailProperties()
+	cod, _ := strconv.ParseFloat(props["debt_sum"], 64)
+	if cod < 0 {
+		cod = 0
+	}
+	return Shipment{
+		OrderID:        order.ID,
+		FullName:       props["lastname"],
+		Phone:          props["phone"],
+		PostCode:       props["postal"],
+		Region:         props["region"],
+		City:           props["city"],
+		Street:         props["street"],
+		House:          props["home"],
+		CashOnDelivery: cod,
+	}
+}
+
+//CreateShipment registers postal item, returns track number
+func (c *client) CreateShipment(ctx context.Context, s Shipment) (string, error) {
+	if s.PostCode == "" || s.FullName == "" {
+		return "", fmt.Errorf("shipment %s: recipient name or postal code is empty", s.OrderID)
+	}
+	data := putOrderData{
+		GoodsID:             s.GoodsID,
+		PostDeliveryTypeID:  s.DeliveryTypeID,
+		CashOnDeliverySum:   fmt.Sprintf("%.2f", s.CashOnDelivery),
+		Name:                s.FullName,
+		PhoneNumberReciever: s.Phone,
+		PostalCode:          s.PostCode,
+		Region:              s.Region,
+		City:                s.City,
+		Street:              s.Street,
+		House:               s.House,
+		CustomerOrderID:     s.OrderID,
+	}
+	table, err := c.callTable(ctx, methodPutOrder, data)
+	if err != nil {
+		return "", err
+	}
+	var item putOrderResponseItem
+	if err = json.Unmarshal(table[0], &item); err != nil {
+		return "", fmt.Errorf("error while decoding to `put order response`: %w", err)
+	}
+	if item.Number == "" {
+		return "", fmt.Errorf("got empty track number, or unknown struct")
+	}
+	return item.Number, nil
+}
+
+//GetTracking returns postal item tracking history
+func (c *client) GetTracking(ctx context.Context, trackNum string) ([]TrackEvent, error) {
+	table, err := c.callTable(ctx, methodTracking, trackingData{Number: trackNum})
+	if err != nil {
+		return nil, err
+	}
+	events := make([]TrackEvent, 0, len(table))
+	for _, raw := range table {
+		var item trackingResponseItem
+		if err = json.Unmarshal(raw, &item); err != nil {
+			return nil, fmt.Errorf("error while decoding to `tracking response`: %w", err)
+		}
+		events = append(events, TrackEvent{Date: item.Timex, Status: item.InfoTrack, Place: item.Place})
+	}
+	return events, nil
+}
+
+//CancelShipment cancels registered postal item
+func (c *client) CancelShipment(ctx context.Context, trackNum string) error {
+	_, err := c.callTable(ctx, methodCancelOrder, cancelOrderData{Number: trackNum})
+	return err
+}
+
+//callTable calls method that returns Table response, retries once with new token on error response
+func (c *client) callTable(ctx context.Context, method string, data interface{}) ([]json.RawMessage, error) {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var jwt string
+		jwt, err = c.token(ctx)
+		if err != nil {
+			return nil, err
+		}
+		param := requestParams{
+			Packet: baseParams{
+				JWT:           jwt,
+				MethodName:    method,
+				ServiceNumber: c.serviceNumber,
+				Data:          data,
+			},
+		}
+		var body []byte
+		var status int
+		body, status, err = c.post(ctx, param)
+		if err != nil {
+			return nil, err
+		}
+		if status != http.StatusOK {
+			return nil, fmt.Errorf("error while calling %s, status: %d %s", method, status, http.StatusText(status))
+		}
+		var rawResp genericResponse
+		if err = json.Unmarshal(body, &rawResp); err != nil {
+			return nil, fmt.Errorf("error while decoding `Table` response: %w", err)
+		}
+		if len(rawResp.Table) == 0 {
+			return nil, fmt.Errorf("empty response, Table is empty")
+		}
+		var errResp errResponseItem
+		if err = json.Unmarshal(rawResp.Table[0], &errResp); err != nil {
+			return nil, fmt.Errorf("error while decoding to `Error response`: %w", err)
+		}
+		if errResp.Error == "" {
+			return rawResp.Table, nil
+		}
+		//token can be expired or revoked, reset and try again
+		c.resetToken(jwt)
+		err = fmt.Errorf("error while calling %s: %s, %s, %s", method, errResp.Error, errResp.ErrorDescription, errResp.ErrorInfo)
+	}
+	return nil, err
+}
@@ -14,6 +14,8 @@ type Repository interface {
 	SetOrderState(ctx context.Context, orderID string, state int) error
 	LoadAlias(ctx context.Context, alias string) (Alias, error)
 	ClearGroup(ctx context.Context, source, group int, keepID string) error
+	//DeleteOrder deletes one order (vs print groups and extra info)
+	DeleteOrder(ctx context.Context, id string) error
 	AddExtraInfo(ctx context.Context, ei OrderExtraInfo) error
 	SetGroupState(ctx context.Context, source, state, group int, keepID string) error
 	GetGroupState(ctx context.Context, baseID string, source, group int) (GroupState, error)
@@ -21,9 +23,26 @@ type Repository interface {
 	LoadBaseOrderByState(ctx context.Context, source, state int) (Order, error)
 	LoadBaseOrderByChildState(ctx context.Context, source, baseState, childState int) ([]Order, error)
 	FillOrders(ctx context.Context, orders []Order) error
+	//ReplaceOrder deletes order (vs print groups and extra info) and inserts new one in one transaction
+	//order is replaced only if its state <= maxState, else returns sql.ErrNoRows
+	ReplaceOrder(ctx context.Context, o Order, maxState int) error
 	StartOrders(ctx context.Context, source, group int, skipID string) error
 	CountCurrentOrders(ctx context.Context, source int) (int, error)
 	GetCurrentOrders(ctx context.Context, source int) ([]GroupState, error)
+	LoadPhotoFormats(ctx context.Context) ([]PhotoFormat, error)
+	LoadBookThickness(ctx context.Context) ([]BookThickness, error)
+	LoadPrintGroups(ctx context.Context, orderID string) ([]PrintGroup, error)
+	//AddPrintGroups adds print groups vs files to existing orders (reprints)
+	AddPrintGroups(ctx context.Context, groups []PrintGroup) error
+	//ClaimOrder takes order claim for owner till lease expires (several services share cycle database)
+	//returns false if order is claimed by other owner and claim is not expired, expired claim is taken over
+	ClaimOrder(ctx context.Context, orderID, owner string, lease time.Duration) (bool, error)
+	//RenewClaim extends owner claim (heartbeat), returns false if claim is lost (expired and taken over)
+	RenewClaim(ctx context.Context, orderID, owner string, lease time.Duration) (bool, error)
+	//ReleaseClaim releases owner claim
+	ReleaseClaim(ctx context.Context, orderID, owner string) error
+	//Ping checks database connection
+	Ping(ctx context.Context) error
 	Close()
 }
 
@@ -37,6 +56,43 @@ type Alias struct {
 	ForwardState int    `json:"forward" db:"forward"`
 }
 
+//PhotoFormat represents the pp_photo_format db object,
+//maps pp photo print sku (size, paper) to cycle print parameters
+type PhotoFormat struct {
+	Width  int `json:"width" db:"width"`   //sku width
+	Height int `json:"height" db:"height"` //sku height
+	Paper  int `json:"paper" db:"paper"`   //sku paper, 0 - any paper
+	//print group parameters, 0 - use sku value
+	PrintWidth  int `json:"print_width" db:"print_width"`
+	PrintHeight int `json:"print_height" db:"print_height"`
+	PrintPaper  int `json:"print_paper" db:"print_paper"`
+	//cutting and frame for photos with borders
+	Cutting int `json:"cutting" db:"cutting"`
+	Frame   int `json:"frame" db:"frame"`
+	//cutting and frame for photos without borders
+	NBCutting int `json:"nb_cutting" db:"nb_cutting"`
+	NBFrame   int `json:"nb_frame" db:"nb_frame"`
+	//MinDPI min effective resolution of image, 0 - not checked
+	MinDPI int `json:"min_dpi" db:"min_dpi"`
+}
+
+//thickness table kinds
+const (
+	ThicknessPaper      = "paper"
+	ThicknessBinding    = "binding"
+	ThicknessEndPaper   = "endpaper"
+	ThicknessInterLayer = "interlayer"
+)
+
+//BookThickness represents the pp_book_thickness db object,
+//thickness (mm) of book component by sku value
+type BookThickness struct {
+	Kind string `json:"kind" db:"kind"` //paper, binding, endpaper, interlayer
+	Code string `json:"code" db:"code"` //sku value (binding - alias book type), empty - any value
+	//Value mm, paper and interlayer per sheet, binding and endpaper per book
+	Value float64 `json:"value" db:"value"`
+}
+
 //Order represents the Order db object
 type Order struct {
 	ID          string    `json:"id" db:"id"`
@@ -92,6 +148,12 @@ type OrderExtraInfo struct {
 	Title         string    `json:"calc_title" db:"calc_title"`
 }
 
+//book parts (book_pg_template.book_part)
+const (
+	BookPartCover = 1
+	BookPartBlock = 2
+)
+
 //PrintGroup represents the PrintGroup of db object
 type PrintGroup struct {
 	ID         string    `json:"id" db:"id"`
@@ -115,6 +177,8 @@ type PrintGroup struct {
 	IsDuplex   bool      `json:"is_duplex" db:"is_duplex"`
 	Prints     int       `json:"prints" db:"prints"`
 	Butt       int       `json:"butt" db:"butt"`
+	//ReprintOf print group reprinted by this group, empty - not reprint
+	ReprintOf string `json:"reprint_of" db:"reprint_of"`
 
 	//4 internal use
 	Files []PrintGroupFile
@@ -0,0 +1,19 @@
+-- photo print catalogue, pp sku (width, height, paper) -> cycle print group params
+-- paper = 0 - any paper; print_* = 0 - use sku value; min_dpi = 0 - not checked
+CREATE TABLE pp_photo_format (
+  width int(5) NOT NULL,
+  height int(5) NOT NULL,
+  paper int(5) NOT NULL DEFAULT 0,
+  print_width int(5) NOT NULL DEFAULT 0,
+  print_height int(5) NOT NULL DEFAULT 0,
+  print_paper int(5) NOT NULL DEFAULT 0,
+  cutting int(5) NOT NULL DEFAULT 20,
+  frame int(5) NOT NULL DEFAULT 15,
+  nb_cutting int(5) NOT NULL DEFAULT 19,
+  nb_frame int(5) NOT NULL DEFAULT 0,
+  min_dpi int(5) NOT NULL DEFAULT 0,
+  PRIMARY KEY (width, height, paper)
+)
+ENGINE = INNODB
+CHARACTER SET utf8
+COLLATE utf8_general_ci;
@@ -0,0 +1,11 @@
+-- book thickness table (mm), kind: paper, interlayer - per sheet; binding, endpaper - per book
+-- code: sku value (binding - book_synonym.book_type), '' - any value
+CREATE TABLE pp_book_thickness (
+  kind varchar(20) NOT NULL,
+  code varchar(50) NOT NULL DEFAULT '',
+  value decimal(6, 3) NOT NULL DEFAULT 0,
+  PRIMARY KEY (kind, code)
+)
+ENGINE = INNODB
+CHARACTER SET utf8
+COLLATE utf8_general_ci;
@@ -0,0 +1,3 @@
+-- reprint print groups, reprint_of - reprinted print group id
+ALTER TABLE print_group
+  ADD COLUMN reprint_of varchar(50) DEFAULT NULL;
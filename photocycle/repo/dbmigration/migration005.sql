@@ -0,0 +1,12 @@
+-- order claims of pixel services sharing one cycle database (failover), claim is renewed by owner heartbeat till expires
+CREATE TABLE pp_order_claim (
+  order_id varchar(50) NOT NULL,
+  owner varchar(100) NOT NULL,
+  claimed datetime NOT NULL,
+  heartbeat datetime NOT NULL,
+  expires datetime NOT NULL,
+  PRIMARY KEY (order_id)
+)
+ENGINE = INNODB
+CHARACTER SET utf8
+COLLATE utf8_general_ci;
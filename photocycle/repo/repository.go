@@ -2,7 +2,9 @@ package repo
 
 import (
 	"context"
+	"database/sql"
 	"strings"
+	"time"
 
 	cycle "github.com/egorka-gh/pixlpark/photocycle"
 	"github.com/jmoiron/sqlx"
@@ -38,6 +40,10 @@ func (b *basicRepository) Close() {
 	b.db.Close()
 }
 
+func (b *basicRepository) Ping(ctx context.Context) error {
+	return b.db.PingContext(ctx)
+}
+
 func (b *basicRepository) CreateOrder(ctx context.Context, o cycle.Order) error {
 	if b.readOnly {
 		return nil
@@ -56,6 +62,49 @@ func (b *basicRepository) FillOrders(ctx context.Context, orders []cycle.Order)
 	if b.readOnly {
 		return nil
 	}
+	t, err := b.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = fillOrders(t, orders); err != nil {
+		t.Rollback()
+		return err
+	}
+	return t.Commit()
+}
+
+//ReplaceOrder replaces order (vs print groups and extra info) in one transaction,
+//existing order is kept if new one is not inserted
+//returns sql.ErrNoRows if order not exists or its state > maxState
+func (b *basicRepository) ReplaceOrder(ctx context.Context, o cycle.Order, maxState int) error {
+	if b.readOnly {
+		return nil
+	}
+	t, err := b.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	res, err := t.Exec("DELETE FROM orders WHERE id = ? AND state <= ?", o.ID, maxState)
+	if err != nil {
+		t.Rollback()
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		t.Rollback()
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+	if err = fillOrders(t, []cycle.Order{o}); err != nil {
+		t.Rollback()
+		return err
+	}
+	return t.Commit()
+}
+
+//fillOrders inserts orders in transaction t
+func fillOrders(t *sqlx.Tx, orders []cycle.Order) error {
 	//insert orders
 	oSQL := "INSERT INTO orders (id, source, src_id, src_date, data_ts, state, state_date, group_id, ftp_folder, fotos_num, client_id, production) VALUES "
 	oVals := make([]string, 0, len(orders))
@@ -113,40 +162,29 @@ func (b *basicRepository) FillOrders(ctx context.Context, orders []cycle.Order)
 	pSQL = pSQL + strings.Join(pVals, ",")
 	fSQL = fSQL + strings.Join(fVals, ",")
 
-	//run in transaction
-	t, err := b.db.BeginTxx(ctx, nil)
-	if err != nil {
-		return err
-	}
-
-	_, err = t.Exec(gSQL, gArgs...)
+	_, err := t.Exec(gSQL, gArgs...)
 	if err != nil {
-		t.Rollback()
 		return err
 	}
 
 	_, err = t.Exec(oSQL, oArgs...)
 	if err != nil {
-		t.Rollback()
 		return err
 	}
 
 	_, err = t.Exec(xSQL, xArgs...)
 	if err != nil {
-		t.Rollback()
 		return err
 	}
 	if len(pVals) > 0 {
 		_, err = t.Exec(pSQL, pArgs...)
 		if err != nil {
-			t.Rollback()
 			return err
 		}
 	}
 	if len(fVals) > 0 {
 		_, err = t.Exec(fSQL, fArgs...)
 		if err != nil {
-			t.Rollback()
 			return err
 		}
 	}
@@ -158,13 +196,12 @@ func (b *basicRepository) FillOrders(ctx context.Context, orders []cycle.Order)
 		if o.ForwardState > 0 {
 			_, err = t.Exec(fSQL, o.ID, o.ForwardState)
 			if err != nil {
-				t.Rollback()
 				return err
 			}
 		}
 	}
 
-	return t.Commit()
+	return nil
 }
 
 func (b *basicRepository) ClearGroup(ctx context.Context, source, group int, keepID string) error {
@@ -176,6 +213,15 @@ func (b *basicRepository) ClearGroup(ctx context.Context, source, group int, kee
 	return err
 }
 
+func (b *basicRepository) DeleteOrder(ctx context.Context, id string) error {
+	if b.readOnly {
+		return nil
+	}
+	sql := "DELETE FROM orders WHERE id = ?"
+	_, err := b.db.ExecContext(ctx, sql, id)
+	return err
+}
+
 func (b *basicRepository) SetGroupState(ctx context.Context, source, state, group int, keepID string) error {
 	if b.readOnly {
 		return nil
@@ -288,3 +334,118 @@ func (b *basicRepository) GetCurrentOrders(ctx context.Context, source int) ([]c
 	err := b.db.SelectContext(ctx, &res, sql, source)
 	return res, err
 }
+
+func (b *basicRepository) LoadPhotoFormats(ctx context.Context) ([]cycle.PhotoFormat, error) {
+	res := []cycle.PhotoFormat{}
+	sql := "SELECT pf.width, pf.height, pf.paper, pf.print_width, pf.print_height, pf.print_paper, pf.cutting, pf.frame, pf.nb_cutting, pf.nb_frame, pf.min_dpi FROM pp_photo_format pf"
+	err := b.db.SelectContext(ctx, &res, sql)
+	return res, err
+}
+
+func (b *basicRepository) LoadBookThickness(ctx context.Context) ([]cycle.BookThickness, error) {
+	res := []cycle.BookThickness{}
+	sql := "SELECT bt.kind, bt.code, bt.value FROM pp_book_thickness bt"
+	err := b.db.SelectContext(ctx, &res, sql)
+	return res, err
+}
+
+func (b *basicRepository) LoadPrintGroups(ctx context.Context, orderID string) ([]cycle.PrintGroup, error) {
+	res := []cycle.PrintGroup{}
+	var sb strings.Builder
+	sb.WriteString("SELECT pg.id, pg.order_id, pg.state, pg.state_date, pg.width, pg.height, pg.paper, pg.frame, pg.correction, pg.cutting, pg.path, pg.alias, pg.file_num, pg.book_type, pg.book_part, pg.book_num, pg.sheet_num, pg.is_pdf, pg.is_duplex, pg.prints, pg.butt, IFNULL(pg.reprint_of, '') reprint_of")
+	sb.WriteString(" FROM print_group pg")
+	sb.WriteString(" WHERE pg.order_id = ?")
+	sb.WriteString(" ORDER BY pg.id")
+	sql := sb.String()
+	err := b.db.SelectContext(ctx, &res, sql, orderID)
+	return res, err
+}
+
+func (b *basicRepository) AddPrintGroups(ctx context.Context, groups []cycle.PrintGroup) error {
+	if b.readOnly || len(groups) == 0 {
+		return nil
+	}
+	pSQL := "INSERT INTO print_group (id, order_id, state, state_date, width, height, paper, frame, correction, cutting, path, alias, file_num, book_type, book_part, book_num, sheet_num, is_pdf, is_duplex, prints, butt, reprint_of) VALUES "
+	pVals := make([]string, 0, len(groups))
+	pArgs := []interface{}{}
+
+	fSQL := "INSERT INTO print_group_file (print_group, file_name, prt_qty, book_num, page_num, caption, book_part) VALUES"
+	fVals := make([]string, 0, len(groups)*10)
+	fArgs := []interface{}{}
+
+	for _, p := range groups {
+		pVals = append(pVals, "(?, ?, ?, NOW(), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''))")
+		pArgs = append(pArgs, p.ID, p.OrderID, p.State, p.Width, p.Height, p.Paper, p.Frame, p.Correction, p.Cutting, p.Path, p.Alias, p.FileNum, p.BookType, p.BookPart, p.BookNum, p.SheetNum, p.IsPDF, p.IsDuplex, p.Prints, p.Butt, p.ReprintOf)
+		for _, f := range p.Files {
+			fVals = append(fVals, "(?, ?, ?, ?, ?, ?, ?)")
+			fArgs = append(fArgs, f.PrintGroupID, f.FileName, f.PrintQtty, f.Book, f.Page, f.Caption, f.BookPart)
+		}
+	}
+
+	t, err := b.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	_, err = t.Exec(pSQL+strings.Join(pVals, ","), pArgs...)
+	if err != nil {
+		t.Rollback()
+		return err
+	}
+	if len(fVals) > 0 {
+		_, err = t.Exec(fSQL+strings.Join(fVals, ","), fArgs...)
+		if err != nil {
+			t.Rollback()
+			return err
+		}
+	}
+	return t.Commit()
+}
+
+func (b *basicRepository) ClaimOrder(ctx context.Context, orderID, owner string, lease time.Duration) (bool, error) {
+	if b.readOnly {
+		return true, nil
+	}
+	//new claim
+	ssql := "INSERT IGNORE INTO pp_order_claim (order_id, owner, claimed, heartbeat, expires) VALUES (?, ?, NOW(), NOW(), NOW() + INTERVAL ? SECOND)"
+	res, err := b.db.ExecContext(ctx, ssql, orderID, owner, int(lease.Seconds()))
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return n > 0, err
+	}
+	//own or expired claim, row is locked by update so only one owner can take over
+	ssql = "UPDATE pp_order_claim SET owner = ?, claimed = NOW(), heartbeat = NOW(), expires = NOW() + INTERVAL ? SECOND WHERE order_id = ? AND (owner = ? OR expires < NOW())"
+	if _, err = b.db.ExecContext(ctx, ssql, owner, int(lease.Seconds()), orderID, owner); err != nil {
+		return false, err
+	}
+	return b.isClaimOwner(ctx, orderID, owner)
+}
+
+//isClaimOwner checks claim owner (mysql affected rows are 0 if update doesn't change values)
+func (b *basicRepository) isClaimOwner(ctx context.Context, orderID, owner string) (bool, error) {
+	var cnt int
+	ssql := "SELECT COUNT(*) FROM pp_order_claim WHERE order_id = ? AND owner = ?"
+	err := b.db.GetContext(ctx, &cnt, ssql, orderID, owner)
+	return cnt > 0, err
+}
+
+func (b *basicRepository) RenewClaim(ctx context.Context, orderID, owner string, lease time.Duration) (bool, error) {
+	if b.readOnly {
+		return true, nil
+	}
+	ssql := "UPDATE pp_order_claim SET heartbeat = NOW(), expires = NOW() + INTERVAL ? SECOND WHERE order_id = ? AND owner = ?"
+	if _, err := b.db.ExecContext(ctx, ssql, int(lease.Seconds()), orderID, owner); err != nil {
+		return false, err
+	}
+	return b.isClaimOwner(ctx, orderID, owner)
+}
+
+func (b *basicRepository) ReleaseClaim(ctx context.Context, orderID, owner string) error {
+	if b.readOnly {
+		return nil
+	}
+	ssql := "DELETE FROM pp_order_claim WHERE order_id = ? AND owner = ?"
+	_, err := b.db.ExecContext(ctx, ssql, orderID, owner)
+	return err
+}
@@ -0,0 +1,290 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/egorka-gh/pixlpark/evropochta"
+	pp "github.com/egorka-gh/pixlpark/pixlpark/service"
+)
+
+//health check levels, service level is the worst level of checks
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+	HealthFailed   = "failed"
+)
+
+var healthLevels = map[string]int{HealthOK: 0, HealthDegraded: 1, HealthFailed: 2}
+
+//HealthConfig health checks settings, shared by sources
+type HealthConfig struct {
+	//Evropochta evropochta client, JWT check is skipped if nil
+	//service doesn't use evropochta (shipments run by cmd/evropochta), so check has own client
+	//and own token, it checks credentials and token issue, not token of shipments process
+	Evropochta evropochta.Evropochta
+	//FreeDegraded, FreeFailed free space limits of work and cycle folders (bytes)
+	FreeDegraded uint64
+	FreeFailed   uint64
+	//SlowLatency check is degraded if dependency answers slower
+	SlowLatency time.Duration
+	//Timeout dependency check timeout
+	Timeout time.Duration
+	//Heartbeat longest manager step (transform), manager loop is failed if there is no progress during sleep interval + Heartbeat
+	Heartbeat time.Duration
+}
+
+//HealthResponse health endpoints response (monitoring), not wrapped in BaseResponse
+type HealthResponse struct {
+	Status string        `json:"status"`
+	Time   time.Time     `json:"time"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+//Render implement Renderer
+func (h *HealthResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	if h.Status == HealthFailed {
+		render.Status(r, http.StatusServiceUnavailable)
+	}
+	return nil
+}
+
+//HealthCheck dependency check result
+type HealthCheck struct {
+	Name    string  `json:"name"`
+	Source  int     `json:"source,omitempty"`
+	Status  string  `json:"status"`
+	Latency float64 `json:"latency_ms,omitempty"`
+	Message string  `json:"message,omitempty"`
+	//Path, Free, Total folder checks
+	Path  string `json:"path,omitempty"`
+	Free  uint64 `json:"free,omitempty"`
+	Total uint64 `json:"total,omitempty"`
+}
+
+//healthCheck dependency check, run fills check result res (name, source are set, status is ok)
+type healthCheck struct {
+	HealthCheck
+	run func(ctx context.Context, res HealthCheck) HealthCheck
+}
+
+//healthRoutes adds /health/live (manager loops) and /health/ready (all dependencies) routes
+//response status is 503 if any check is failed, degraded checks keep 200
+func healthRoutes(r chi.Router, sources []*Config) {
+	r.Get("/health/live", func(w http.ResponseWriter, r *http.Request) {
+		render.Render(w, r, runHealth(r.Context(), liveChecks(sources), healthTimeout(sources)))
+	})
+	r.Get("/health/ready", func(w http.ResponseWriter, r *http.Request) {
+		render.Render(w, r, runHealth(r.Context(), readyChecks(sources), healthTimeout(sources)))
+	})
+}
+
+func healthConfig(sources []*Config) *HealthConfig {
+	for _, c := range sources {
+		if c.Health != nil {
+			return c.Health
+		}
+	}
+	return &HealthConfig{}
+}
+
+func healthTimeout(sources []*Config) time.Duration {
+	if t := healthConfig(sources).Timeout; t > 0 {
+		return t
+	}
+	return 5 * time.Second
+}
+
+//runHealth runs checks in parallel, waits checks till timeout
+//checks that are still running (some checks can't be canceled, e.g. hung network share) are failed by timeout
+func runHealth(ctx context.Context, checks []healthCheck, timeout time.Duration) *HealthResponse {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	res := &HealthResponse{Status: HealthOK, Time: time.Now(), Checks: make([]HealthCheck, len(checks))}
+	type result struct {
+		i     int
+		check HealthCheck
+	}
+	//buffered, late checks don't block
+	chRes := make(chan result, len(checks))
+	for i, c := range checks {
+		res.Checks[i] = c.HealthCheck
+		res.Checks[i].Status, res.Checks[i].Message = HealthFailed, "timeout"
+		go func(i int, c healthCheck) {
+			check := c.HealthCheck
+			check.Status = HealthOK
+			chRes <- result{i, c.run(ctx, check)}
+		}(i, c)
+	}
+Wait:
+	for n := 0; n < len(checks); n++ {
+		select {
+		case r := <-chRes:
+			res.Checks[r.i] = r.check
+		case <-ctx.Done():
+			break Wait
+		}
+	}
+	for _, c := range res.Checks {
+		if healthLevels[c.Status] > healthLevels[res.Status] {
+			res.Status = c.Status
+		}
+	}
+	return res
+}
+
+func liveChecks(sources []*Config) []healthCheck {
+	hc := healthConfig(sources)
+	res := make([]healthCheck, 0, len(sources))
+	for _, c := range sources {
+		if c.Manager != nil {
+			res = append(res, managerCheck(c, hc.Heartbeat))
+		}
+	}
+	return res
+}
+
+func readyChecks(sources []*Config) []healthCheck {
+	hc := healthConfig(sources)
+	res := liveChecks(sources)
+	//sources share cycle database, checked once
+	if len(sources) > 0 && sources[0].CycleClient != nil {
+		res = append(res, mysqlCheck(sources[0], hc.SlowLatency))
+	}
+	folders := make(map[string]bool)
+	for _, c := range sources {
+		if c.ProbeClient != nil {
+			res = append(res, pixlparkCheck(c, hc.SlowLatency))
+		}
+		if c.Token != nil {
+			res = append(res, tokenCheck(c))
+		}
+		for _, f := range []struct{ name, path string }{
+			{"wrkFolder", c.WorkFolder},
+			{"cycleFolder", c.CycleFolder},
+			{"cyclePrtFolder", c.CyclePrtFolder},
+		} {
+			//sources may share folders
+			if f.path == "" || folders[filepath.Clean(f.path)] {
+				continue
+			}
+			folders[filepath.Clean(f.path)] = true
+			res = append(res, folderCheck(c.Source, f.name, f.path, hc.FreeDegraded, hc.FreeFailed))
+		}
+	}
+	if hc.Evropochta != nil {
+		res = append(res, evropochtaCheck(hc.Evropochta))
+	}
+	return res
+}
+
+//managerCheck manager loop heartbeat
+//not started or stuck loop is failed, paused or draining manager is degraded
+func managerCheck(c *Config, heartbeat time.Duration) healthCheck {
+	return healthCheck{HealthCheck{Name: "manager", Source: c.Source}, func(ctx context.Context, res HealthCheck) HealthCheck {
+		h := c.Manager.Health(heartbeat)
+		switch {
+		case !h.Started:
+			res.Status, res.Message = HealthFailed, "Менеджер не запущен"
+		case h.Draining:
+			res.Status, res.Message = HealthDegraded, "Остановка сервиса"
+		case h.Paused:
+			res.Status, res.Message = HealthDegraded, "Пауза"
+		case h.IsStale(time.Now()):
+			res.Status, res.Message = HealthFailed, fmt.Sprintf("Нет активности с %s", h.Beat.Format("2006-01-02 15:04:05"))
+		default:
+			res.Message = fmt.Sprintf("Активность %s", h.Beat.Format("2006-01-02 15:04:05"))
+		}
+		return res
+	}}
+}
+
+//mysqlCheck cycle database connection and latency
+func mysqlCheck(c *Config, slow time.Duration) healthCheck {
+	return healthCheck{HealthCheck{Name: "mysql"}, func(ctx context.Context, res HealthCheck) HealthCheck {
+		start := time.Now()
+		err := c.CycleClient.Ping(ctx)
+		res.latency(time.Since(start), slow)
+		if err != nil {
+			res.Status, res.Message = HealthFailed, err.Error()
+		}
+		return res
+	}}
+}
+
+//pixlparkCheck Pixlpark API reachability (authorized request) and latency
+//probe client bypasses rate limits and breakers, so open breaker doesn't hide API recovery
+func pixlparkCheck(c *Config, slow time.Duration) healthCheck {
+	return healthCheck{HealthCheck{Name: "pixlpark", Source: c.Source}, func(ctx context.Context, res HealthCheck) HealthCheck {
+		start := time.Now()
+		_, err := c.ProbeClient.CountOrders(ctx, []string{pp.StatePrinted})
+		res.latency(time.Since(start), slow)
+		if err != nil {
+			res.Status, res.Message = HealthFailed, err.Error()
+		}
+		return res
+	}}
+}
+
+//tokenCheck Pixlpark OAuth token, expired token is refreshed by token source
+func tokenCheck(c *Config) healthCheck {
+	return healthCheck{HealthCheck{Name: "pixlparkToken", Source: c.Source}, func(ctx context.Context, res HealthCheck) HealthCheck {
+		tk, err := c.Token.Token()
+		switch {
+		case err != nil:
+			res.Status, res.Message = HealthFailed, err.Error()
+		case !tk.Valid():
+			res.Status, res.Message = HealthFailed, "Токен не действителен"
+		default:
+			res.Message = fmt.Sprintf("Действителен до %s", tk.Expiry.Format("2006-01-02 15:04:05"))
+		}
+		return res
+	}}
+}
+
+//evropochtaCheck Evropochta JWT, token is requested if empty or expired
+//evropochta is used by shipments only, so check is degraded on error
+func evropochtaCheck(e evropochta.Evropochta) healthCheck {
+	return healthCheck{HealthCheck{Name: "evropochta"}, func(ctx context.Context, res HealthCheck) HealthCheck {
+		if e.HasToken() {
+			return res
+		}
+		if err := e.GetToken(ctx); err != nil {
+			res.Status, res.Message = HealthDegraded, err.Error()
+		}
+		return res
+	}}
+}
+
+//folderCheck free space in folder
+func folderCheck(source int, name, path string, degraded, failed uint64) healthCheck {
+	return healthCheck{HealthCheck{Name: name, Source: source, Path: path}, func(ctx context.Context, res HealthCheck) HealthCheck {
+		free, total, err := diskFree(path)
+		if err != nil {
+			res.Status, res.Message = HealthFailed, err.Error()
+			return res
+		}
+		res.Free, res.Total = free, total
+		switch {
+		case free < failed:
+			res.Status, res.Message = HealthFailed, fmt.Sprintf("Свободно %.1f GB", float64(free)/(1<<30))
+		case free < degraded:
+			res.Status, res.Message = HealthDegraded, fmt.Sprintf("Свободно %.1f GB", float64(free)/(1<<30))
+		}
+		return res
+	}}
+}
+
+//latency sets latency, check is degraded if slow
+func (h *HealthCheck) latency(d, slow time.Duration) {
+	h.Latency = float64(d.Microseconds()) / 1000
+	if slow > 0 && d > slow {
+		h.Status, h.Message = HealthDegraded, fmt.Sprintf("Медленный ответ %s", d.Round(time.Millisecond))
+	}
+}
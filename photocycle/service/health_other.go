@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package service
+
+import "syscall"
+
+//diskFree returns free space available to user and total space of disk of path
+func diskFree(path string) (free, total uint64, err error) {
+	var st syscall.Statfs_t
+	if err = syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), uint64(st.Blocks) * uint64(st.Bsize), nil
+}
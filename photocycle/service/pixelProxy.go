@@ -4,13 +4,19 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/render"
 
 	pc "github.com/egorka-gh/pixlpark/photocycle"
+	"github.com/egorka-gh/pixlpark/pixlpark/oauth"
 	pp "github.com/egorka-gh/pixlpark/pixlpark/service"
 	"github.com/egorka-gh/pixlpark/transform"
 )
@@ -22,6 +28,22 @@ type Config struct {
 	Manager     *transform.Manager
 	Source      int
 	IgnoreState bool
+	//ThumbFolder preflight thumbnails folder, preview routes are disabled if empty
+	ThumbFolder string
+	//Archive archive of order zips, archive routes are disabled if nil
+	Archive *transform.Archive
+	//WorkFolder orders work folder (archived zips are restored to)
+	WorkFolder string
+	//CycleFolder, CyclePrtFolder local cycle folders, free space is checked by /health/ready (empty - not checked)
+	CycleFolder    string
+	CyclePrtFolder string
+	//ProbeClient Pixlpark client of /health/ready, without rate limit and breaker middlewares
+	//(probe doesn't take orders quota, doesn't trip or wait open breaker), pixlpark check is skipped if nil
+	ProbeClient pp.PPService
+	//Token Pixlpark OAuth token source, token check is skipped if nil
+	Token oauth.TokenSource
+	//Health health checks settings
+	Health *HealthConfig
 }
 
 type proxy struct {
@@ -37,12 +59,24 @@ func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func New(config *Config) http.Handler {
 	return &proxy{
 		config: config,
-		mux:    createRouter(config),
+		mux:    createRouter(config, nil),
+	}
+}
+
+//NewMulti creats http.Handler for several sources
+//each source is routed as /api/{source}/..., first source is also routed as /api/... (default)
+func NewMulti(configs []*Config) http.Handler {
+	if len(configs) == 0 {
+		return New(&Config{})
+	}
+	return &proxy{
+		config: configs[0],
+		mux:    createRouter(configs[0], configs),
 	}
 }
 
 //NewRouter creates chi.Mux
-func createRouter(config *Config) *chi.Mux {
+func createRouter(config *Config, sources []*Config) *chi.Mux {
 	r := chi.NewRouter()
 	//r.Use(middleware.RequestID)
 	//r.Use(middleware.Logger)
@@ -53,55 +87,46 @@ func createRouter(config *Config) *chi.Mux {
 	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("hi"))
 	})
+	if len(sources) == 0 {
+		healthRoutes(r, []*Config{config})
+	} else {
+		healthRoutes(r, sources)
+	}
 
 	r.Route("/api", func(r chi.Router) {
-		/* 		r.With(paginate).Get("/", ListArticles)
-		   		r.Post("/", CreateArticle)       // POST /articles
-		   		r.Get("/search", SearchArticles) // GET /articles/search
-
-		   		r.Route("/{articleID}", func(r chi.Router) {
-		   			r.Use(ArticleCtx)            // Load the *Article on the request context
-		   			r.Get("/", GetArticle)       // GET /articles/123
-		   			r.Put("/", UpdateArticle)    // PUT /articles/123
-		   			r.Delete("/", DeleteArticle) // DELETE /articles/123
-		   		})
-
-		   		// GET /articles/whats-up
-		   		r.With(ArticleCtx).Get("/{articleSlug:[a-z-]+}", GetArticle)
-		*/
-		r.Route("/order/{orderID}", func(r chi.Router) {
-			//r.Use(OrderCtx)       // Load the *Order on the request context
-			r.With(config.OrderCtx).Get("/", GetOrder)  // GET /order/123 (REST standard)
-			r.With(config.OrderCtx).Post("/", GetOrder) // cycle allways uses Post, so route it as GET
-			r.Post("/status", config.SetOrderState)     //set order status, not PUT - cycle allways uses Post
-			//4 debug
-			r.Get("/status/{status}", config.SetOrderState)
-		})
-
-		r.Route("/orders/{state}", func(r chi.Router) {
-			//r.Use(OrderCtx)       // Load the *Order on the request context
-			r.Get("/", config.ListOrders)  // GET /orders/somestatus
-			r.Post("/", config.ListOrders) // Post /orders/somestatus
-		})
-
-		//get order and transform to MailPackage payload as it expect cycle
-		r.Route("/mailpackage/{orderID}", func(r chi.Router) {
-			r.Use(config.OrderCtx) // Load the *Order on the request context
-			r.Get("/", GetMailpackage)
-			r.Post("/", GetMailpackage) // cycle allways uses Post, so route it as GET
-		})
-
-		//get info
-		r.Route("/info", func(r chi.Router) {
-			//get orders num in pixel and cycle
-			r.Get("/total", config.GetOrdersCount)
-			r.Post("/total", config.GetOrdersCount)
-
-			//get current factory state (queue len, download speed)
-			r.Get("/current", config.GetFactoryInfo)
-			r.Post("/current", config.GetFactoryInfo)
-		})
-
+		config.apiRoutes(r)
+		if len(sources) == 0 {
+			return
+		}
+		//per source routes
+		handlers := make(map[string]http.Handler, len(sources))
+		for _, c := range sources {
+			sr := chi.NewRouter()
+			c.apiRoutes(sr)
+			handlers[strconv.Itoa(c.Source)] = sr
+		}
+		r.Mount("/{source:[0-9]+}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h, ok := handlers[chi.URLParam(r, "source")]
+			if !ok {
+				render.Render(w, r, ErrNotFound)
+				return
+			}
+			h.ServeHTTP(w, r)
+		}))
+		//managers info by source
+		info := func(w http.ResponseWriter, r *http.Request) {
+			res := make(SourcesInfoResponse, 0, len(sources))
+			for _, c := range sources {
+				si := SourceInfo{Source: c.Source}
+				if c.Manager != nil {
+					si.Info = c.Manager.GetInfo()
+				}
+				res = append(res, si)
+			}
+			render.Render(w, r, &BaseResponse{Result: res})
+		}
+		r.Get("/sources", info)
+		r.Post("/sources", info)
 	})
 	//mount net/http/pprof
 	//closed to check mem grow
@@ -109,6 +134,76 @@ func createRouter(config *Config) *chi.Mux {
 	return r
 }
 
+//apiRoutes adds source api routes
+func (c *Config) apiRoutes(r chi.Router) {
+	/* 		r.With(paginate).Get("/", ListArticles)
+	   		r.Post("/", CreateArticle)       // POST /articles
+	   		r.Get("/search", SearchArticles) // GET /articles/search
+
+	   		r.Route("/{articleID}", func(r chi.Router) {
+	   			r.Use(ArticleCtx)            // Load the *Article on the request context
+	   			r.Get("/", GetArticle)       // GET /articles/123
+	   			r.Put("/", UpdateArticle)    // PUT /articles/123
+	   			r.Delete("/", DeleteArticle) // DELETE /articles/123
+	   		})
+
+	   		// GET /articles/whats-up
+	   		r.With(ArticleCtx).Get("/{articleSlug:[a-z-]+}", GetArticle)
+	*/
+	r.Route("/order/{orderID}", func(r chi.Router) {
+		//r.Use(OrderCtx)       // Load the *Order on the request context
+		r.With(c.OrderCtx).Get("/", GetOrder)  // GET /order/123 (REST standard)
+		r.With(c.OrderCtx).Post("/", GetOrder) // cycle allways uses Post, so route it as GET
+		r.Post("/status", c.SetOrderState)     //set order status, not PUT - cycle allways uses Post
+		//4 debug
+		r.Get("/status/{status}", c.SetOrderState)
+	})
+
+	r.Route("/orders/{state}", func(r chi.Router) {
+		//r.Use(OrderCtx)       // Load the *Order on the request context
+		r.Get("/", c.ListOrders)  // GET /orders/somestatus
+		r.Post("/", c.ListOrders) // Post /orders/somestatus
+	})
+
+	//get order and transform to MailPackage payload as it expect cycle
+	r.Route("/mailpackage/{orderID}", func(r chi.Router) {
+		r.Use(c.OrderCtx) // Load the *Order on the request context
+		r.Get("/", GetMailpackage)
+		r.Post("/", GetMailpackage) // cycle allways uses Post, so route it as GET
+	})
+
+	//preflight report and thumbnails
+	r.Route("/preview/{orderID}", func(r chi.Router) {
+		r.Get("/", c.GetPreview)
+		r.Post("/", c.GetPreview)
+		r.Get("/*", c.GetThumb)
+	})
+
+	//archived order zips
+	r.Route("/archive/{orderID}", func(r chi.Router) {
+		r.Get("/", c.GetArchive)
+		r.Post("/", c.GetArchive)
+		r.Post("/restore", c.RestoreArchive) //unzip archived order to work folder
+	})
+
+	//reprint sheets or books of published cycle order (form values books, sheets: 1,3-5)
+	r.Post("/reprint/{orderID}", c.Reprint)
+
+	//rebuild one order item into its cycle order (cycle order id <source>_<pp order id>-<n>)
+	r.Post("/retransform/{orderID}", c.RetransformItem)
+
+	//get info
+	r.Route("/info", func(r chi.Router) {
+		//get orders num in pixel and cycle
+		r.Get("/total", c.GetOrdersCount)
+		r.Post("/total", c.GetOrdersCount)
+
+		//get current factory state (queue len, download speed)
+		r.Get("/current", c.GetFactoryInfo)
+		r.Post("/current", c.GetFactoryInfo)
+	})
+}
+
 // OrderCtx middleware is used to load an Order object from pixelpark.
 // In case pixelpark returns some error, we stop here and return a error.
 func (c *Config) OrderCtx(next http.Handler) http.Handler {
@@ -280,6 +375,193 @@ func (c *Config) GetFactoryInfo(w http.ResponseWriter, r *http.Request) {
 	render.Render(w, r, &BaseResponse{Result: &inf})
 }
 
+// GetPreview returns order preflight report (changed files and thumbnails)
+func (c *Config) GetPreview(w http.ResponseWriter, r *http.Request) {
+	if c.ThumbFolder == "" {
+		render.Render(w, r, ErrNotConfigured)
+		return
+	}
+	report, err := transform.LoadPreflightReport(c.ThumbFolder, chi.URLParam(r, "orderID"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	resp := PreviewResponse(report)
+	render.Render(w, r, &BaseResponse{Result: &resp})
+}
+
+// GetThumb serves order thumbnail file
+func (c *Config) GetThumb(w http.ResponseWriter, r *http.Request) {
+	if c.ThumbFolder == "" {
+		render.Render(w, r, ErrNotConfigured)
+		return
+	}
+	orderID := chi.URLParam(r, "orderID")
+	rel := chi.URLParam(r, "*")
+	//don't allow to leave order folder
+	fp := filepath.Join(c.ThumbFolder, orderID, filepath.FromSlash(path.Clean("/"+rel)))
+	if orderID == "" || rel == "" || strings.Contains(orderID, "..") {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if fi, err := os.Stat(fp); err != nil || fi.IsDir() {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeFile(w, r, fp)
+}
+
+// GetArchive returns archived order zip info
+func (c *Config) GetArchive(w http.ResponseWriter, r *http.Request) {
+	if c.Archive == nil {
+		render.Render(w, r, ErrNotConfigured)
+		return
+	}
+	e, ok := c.Archive.Get(chi.URLParam(r, "orderID"))
+	if !ok {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	resp := ArchiveResponse(e)
+	render.Render(w, r, &BaseResponse{Result: &resp})
+}
+
+// RestoreArchive restores order source files from archive to work folder
+func (c *Config) RestoreArchive(w http.ResponseWriter, r *http.Request) {
+	if c.Archive == nil || c.WorkFolder == "" {
+		render.Render(w, r, ErrNotConfigured)
+		return
+	}
+	orderID := chi.URLParam(r, "orderID")
+	if orderID == "" || strings.ContainsAny(orderID, `/\.`) {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	e, err := c.Archive.Restore(orderID, c.WorkFolder)
+	if err != nil {
+		if _, ok := err.(transform.ErrSourceNotFound); ok {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	resp := ArchiveResponse(e)
+	render.Render(w, r, &BaseResponse{Result: &resp})
+}
+
+// Reprint rebuilds sheets or books of cycle order and creates reprint print groups
+func (c *Config) Reprint(w http.ResponseWriter, r *http.Request) {
+	if c.Manager == nil {
+		render.Render(w, r, ErrNotConfigured)
+		return
+	}
+	req := transform.ReprintRequest{OrderID: chi.URLParam(r, "orderID")}
+	if req.OrderID == "" {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	var err error
+	if req.Books, err = parseNumbers(r.FormValue("books")); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if req.Sheets, err = parseNumbers(r.FormValue("sheets")); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	runDetached(w, r, func(ctx context.Context) (render.Renderer, error) {
+		groups, err := c.Manager.Reprint(ctx, req)
+		return ReprintResponse(groups), err
+	})
+}
+
+//detachedWait how long handler waits for detached operation, must be less than server WriteTimeout
+const detachedWait = 10 * time.Second
+
+//detachedTimeout limits detached operation
+const detachedTimeout = 30 * time.Minute
+
+//runDetached runs long operation detached from request context (response is limited by server WriteTimeout)
+//renders result if operation completes in detachedWait, else responds 202 and operation goes on in background
+func runDetached(w http.ResponseWriter, r *http.Request, fn func(ctx context.Context) (render.Renderer, error)) {
+	type result struct {
+		res render.Renderer
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), detachedTimeout)
+		defer cancel()
+		res, err := fn(ctx)
+		done <- result{res, err}
+	}()
+	timer := time.NewTimer(detachedWait)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		if res.err != nil {
+			render.Render(w, r, ErrInvalidRequest(res.err))
+			return
+		}
+		render.Render(w, r, &BaseResponse{Result: res.res})
+	case <-timer.C:
+		render.Status(r, http.StatusAccepted)
+		render.Render(w, r, &BaseResponse{Result: message("Операция выполняется, результат в журнале заказа")})
+	case <-r.Context().Done():
+	}
+}
+
+// RetransformItem rebuilds one order item into its cycle order
+func (c *Config) RetransformItem(w http.ResponseWriter, r *http.Request) {
+	if c.Manager == nil {
+		render.Render(w, r, ErrNotConfigured)
+		return
+	}
+	orderID := chi.URLParam(r, "orderID")
+	if orderID == "" {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	runDetached(w, r, func(ctx context.Context) (render.Renderer, error) {
+		order, err := c.Manager.RetransformItem(ctx, orderID)
+		res := RetransformResponse(order)
+		return &res, err
+	})
+}
+
+//parseNumbers parses numbers list like 1,3-5
+func parseNumbers(s string) ([]int, error) {
+	var res []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to := part, part
+		if i := strings.Index(part, "-"); i > 0 {
+			from, to = part[:i], part[i+1:]
+		}
+		f, err := strconv.Atoi(strings.TrimSpace(from))
+		if err != nil {
+			return nil, fmt.Errorf("Неверный номер '%s'", part)
+		}
+		t, err := strconv.Atoi(strings.TrimSpace(to))
+		if err != nil || t < f {
+			return nil, fmt.Errorf("Неверный диапазон '%s'", part)
+		}
+		for n := f; n <= t; n++ {
+			res = append(res, n)
+		}
+	}
+	return res, nil
+}
+
 // BaseResponse is the base response for cycle
 type BaseResponse struct {
 	Result render.Renderer `json:"result"`
@@ -365,6 +647,52 @@ func (i *InfoFactoryResponse) Render(w http.ResponseWriter, r *http.Request) err
 	return nil
 }
 
+//SourceInfo represents source manager info
+type SourceInfo struct {
+	Source int                   `json:"source"`
+	Info   transform.ManagerInfo `json:"info"`
+}
+
+//SourcesInfoResponse represents managers info by source for cycle web client
+type SourcesInfoResponse []SourceInfo
+
+//Render implement Renderer
+func (s SourcesInfoResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+//PreviewResponse represents the preflight report for cycle web client
+type PreviewResponse transform.PreflightReport
+
+//Render implement Renderer
+func (p *PreviewResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+//ArchiveResponse represents archived order zip for cycle web client
+type ArchiveResponse transform.ArchiveEntry
+
+//Render implement Renderer
+func (a *ArchiveResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+//ReprintResponse represents created reprint print groups for cycle web client
+type ReprintResponse []pc.PrintGroup
+
+//Render implement Renderer
+func (p ReprintResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+//RetransformResponse represents rebuilt cycle order for cycle web client
+type RetransformResponse pc.Order
+
+//Render implement Renderer
+func (o *RetransformResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
 //NewMailPackageResponse creates new MailPackageResponse
 func NewMailPackageResponse(order *pp.Order) *BaseResponse {
 	resp := &MailPackageResponse{
@@ -374,38 +702,13 @@ func NewMailPackageResponse(order *pp.Order) *BaseResponse {
 		DeliveryID:   order.Shipping.ID,
 		DeliveryName: order.Shipping.Title,
 		StateName:    order.Status,
-		Properties:   make(map[string]string),
+		Properties:   order.MailProperties(),
 	}
 
 	if order.TrackingNumber != "" {
 		resp.Barcodes = []MailPackageBarcodeResponse{{Barcode: order.TrackingNumber}}
 	}
 
-	//add properties
-	//reorder iof to fio
-	iof := strings.Fields(strings.Title(order.DeliveryAddress.FullName))
-	if len(iof) > 1 {
-		//iof[0], iof[len(iof)-1] = iof[len(iof)-1], iof[0]
-		fio := make([]string, 0, len(iof))
-		fio = append(fio, iof[len(iof)-1])
-		fio = append(fio, iof[:len(iof)-1]...)
-		resp.Properties["lastname"] = strings.Join(fio, " ")
-	} else {
-		resp.Properties["lastname"] = order.DeliveryAddress.FullName
-	}
-
-	resp.Properties["phone"] = order.DeliveryAddress.Phone
-	//resp.Properties["email"] = TODO get in from User?
-	//resp.Properties["passport"] = TODO
-	//resp.Properties["passport_date"] = TODO
-	resp.Properties["postal"] = order.DeliveryAddress.ZipCode
-	resp.Properties["region"] = order.DeliveryAddress.State
-	//resp.Properties["district"] = TODO
-	resp.Properties["city"] = order.DeliveryAddress.City
-	resp.Properties["street"] = order.DeliveryAddress.AddressLine1
-	resp.Properties["home"] = order.DeliveryAddress.AddressLine2
-	resp.Properties["debt_sum"] = fmt.Sprintf("%.2f", order.TotalPrice-order.PaidPrice)
-
 	return &BaseResponse{Result: resp}
 }
 
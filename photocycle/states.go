@@ -13,7 +13,9 @@ const (
 	//-325	Ошибка FTP
 	//-323	Ошибка перепечатки
 	//-322	Не верный статус
-	//-321	Блокирован другим процессом
+
+	//StateErrLocked represent photocycle state
+	StateErrLocked = -321 //Блокирован другим процессом
 
 	//StateErrProductionNotSet represent photocycle state
 	StateErrProductionNotSet = -320 //Не назначено производство
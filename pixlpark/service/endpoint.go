@@ -19,6 +19,7 @@ type Endpoints struct {
 	GetOrderEndpoint        endpoint.Endpoint
 	SetOrderStatusEndpoint  endpoint.Endpoint
 	AddOrderCommentEndpoint endpoint.Endpoint
+	SetTrackingEndpoint     endpoint.Endpoint
 }
 
 /* server version??
@@ -302,3 +303,28 @@ func (e Endpoints) AddOrderComment(ctx context.Context, id, email, comment strin
 	*/
 	return nil
 }
+
+//*************** SetTrackingNumber
+
+//SetTrackingRequest collects the request parameters for the SetTrackingNumber method.
+type SetTrackingRequest struct {
+	OrderID        string
+	TrackingNumber string
+	TrackingURL    string
+}
+
+// SetTrackingResponse collects the response parameters for the SetTrackingNumber method.
+type SetTrackingResponse struct {
+	basePPResponse
+}
+
+// SetTrackingNumber implements Service. Primarily useful in a client.
+func (e Endpoints) SetTrackingNumber(ctx context.Context, id, trackingNumber, trackingURL string) error {
+	request := SetTrackingRequest{OrderID: id, TrackingNumber: trackingNumber, TrackingURL: trackingURL}
+	response, err := e.SetTrackingEndpoint(ctx, request)
+	if err != nil {
+		return err
+	}
+	resp := response.(SetTrackingResponse)
+	return resp.check()
+}
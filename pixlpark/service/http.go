@@ -77,6 +77,13 @@ func New(instance string, options map[string][]http.ClientOption, mdw map[string
 			addOrderCommentEndpoint = m(addOrderCommentEndpoint)
 		}
 	}
+	var setTrackingEndpoint endpoint.Endpoint
+	{
+		setTrackingEndpoint = http.NewClient("POST", copyURL(u, "/orders"), encodeSetTrackingRequest, decodeSetTrackingResponse, options["SetTrackingNumber"]...).Endpoint()
+		for _, m := range mdw["SetTrackingNumber"] {
+			setTrackingEndpoint = m(setTrackingEndpoint)
+		}
+	}
 
 	return Endpoints{
 		CountOrdersEndpoint:     countOrdersEndpoint,
@@ -85,6 +92,7 @@ func New(instance string, options map[string][]http.ClientOption, mdw map[string
 		GetOrderItemsEndpoint:   getOrderItemsEndpoint,
 		SetOrderStatusEndpoint:  setOrderStatusEndpoint,
 		AddOrderCommentEndpoint: addOrderCommentEndpoint,
+		SetTrackingEndpoint:     setTrackingEndpoint,
 	}, nil
 }
 
@@ -249,6 +257,38 @@ func decodeAddOrderCommentResponse(ctx context.Context, r *http1.Response) (inte
 	return resp, err
 }
 
+func encodeSetTrackingRequest(_ context.Context, r *http1.Request, request interface{}) error {
+	req := request.(SetTrackingRequest)
+	// /orders/{id}/tracking
+	r.URL = copyURL(r.URL, r.URL.Path+"/"+req.OrderID+"/tracking")
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	form := url.Values{}
+	form.Add("trackingNumber", req.TrackingNumber)
+	if req.TrackingURL != "" {
+		form.Add("trackingUrl", req.TrackingURL)
+	}
+	r.Body = ioutil.NopCloser(strings.NewReader(form.Encode()))
+	return nil
+}
+
+func decodeSetTrackingResponse(ctx context.Context, r *http1.Response) (interface{}, error) {
+	if r.StatusCode != http1.StatusOK {
+		return nil, statusError(r.StatusCode)
+	}
+	var resp SetTrackingResponse
+	var err error
+	if isDebugSet(ctx) {
+		var raw bytes.Buffer
+		tee := io.TeeReader(r.Body, &raw)
+		err = json.NewDecoder(tee).Decode(&resp)
+		resp.RawResponse = raw.String()
+		fmt.Println(resp.RawResponse)
+	} else {
+		err = json.NewDecoder(r.Body).Decode(&resp)
+	}
+	return resp, err
+}
+
 func statusError(code int) error {
 	return fmt.Errorf("Wrong http status %d. %s", code, http1.StatusText(code))
 }
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/circuitbreaker"
+	"github.com/go-kit/kit/endpoint"
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/ratelimit"
+	"github.com/sony/gobreaker"
+	"golang.org/x/time/rate"
+)
+
+//ErrBreakerOpen is returned by endpoint while circuit breaker is open (PP is considered unavailable)
+var ErrBreakerOpen = gobreaker.ErrOpenState
+
+// Limits describes client side limits for one PP method.
+type Limits struct {
+	//Rate requests per second, 0 - unlimited
+	Rate float64
+	//Burst max requests at once
+	Burst int
+	//Failures consecutive transport failures to open breaker, 0 - breaker disabled
+	Failures uint32
+	//OpenTimeout how long breaker stays open before probe request
+	OpenTimeout time.Duration
+}
+
+// RateLimitMiddleware returns middleware that delays requests exceeding limits.Rate.
+// Returns nil if rate is not limited.
+func RateLimitMiddleware(limits Limits) endpoint.Middleware {
+	if limits.Rate <= 0 {
+		return nil
+	}
+	burst := limits.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	return ratelimit.NewDelayingLimiter(rate.NewLimiter(rate.Limit(limits.Rate), burst))
+}
+
+// Breakers holds circuit breakers of PP methods.
+// Manager uses it to back off while PP is not responding.
+type Breakers struct {
+	mu       sync.Mutex
+	breakers map[string]*gobreaker.CircuitBreaker // method -> breaker
+	logger   log.Logger
+}
+
+// NewBreakers creates empty breakers set
+func NewBreakers(logger log.Logger) *Breakers {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	return &Breakers{
+		breakers: make(map[string]*gobreaker.CircuitBreaker),
+		logger:   logger,
+	}
+}
+
+// Middleware creates circuit breaker for method and returns middleware that wraps endpoint.
+// Returns nil if breaker is disabled by limits.
+// Only transport errors (returned by endpoint) counts, PP response errors (ResponseCode) are not.
+func (b *Breakers) Middleware(method string, limits Limits) endpoint.Middleware {
+	if limits.Failures == 0 {
+		return nil
+	}
+	failures := limits.Failures
+	timeout := limits.OpenTimeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	cb := gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:        method,
+		MaxRequests: 1,
+		Timeout:     timeout,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= failures
+		},
+		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
+			b.logger.Log("breaker", name, "from", from.String(), "to", to.String())
+		},
+	})
+	b.mu.Lock()
+	b.breakers[method] = cb
+	b.mu.Unlock()
+	return circuitbreaker.Gobreaker(cb)
+}
+
+// IsOpen returns true if some breaker is open
+func (b *Breakers) IsOpen() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, cb := range b.breakers {
+		if cb.State() == gobreaker.StateOpen {
+			return true
+		}
+	}
+	return false
+}
+
+// State returns human-friendly state of not closed breakers (method:state),
+// empty string if all closed
+func (b *Breakers) State() string {
+	if b == nil {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	res := make([]string, 0, len(b.breakers))
+	for method, cb := range b.breakers {
+		if st := cb.State(); st != gobreaker.StateClosed {
+			res = append(res, fmt.Sprintf("%s:%s", method, st.String()))
+		}
+	}
+	sort.Strings(res)
+	return strings.Join(res, ",")
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakersMiddleware(t *testing.T) {
+	b := NewBreakers(nil)
+	calls := 0
+	ep := func(ctx context.Context, request interface{}) (interface{}, error) {
+		calls++
+		return nil, errors.New("transport error")
+	}
+	ep = b.Middleware("GetOrder", Limits{Failures: 3, OpenTimeout: time.Hour})(ep)
+
+	for i := 0; i < 5; i++ {
+		ep(context.Background(), nil)
+	}
+	if calls != 3 {
+		t.Errorf("Expected calls %d got %d", 3, calls)
+	}
+	if !b.IsOpen() {
+		t.Errorf("Breaker not open")
+	}
+	if b.State() != "GetOrder:open" {
+		t.Errorf("Expected state %q got %q", "GetOrder:open", b.State())
+	}
+	if _, err := ep(context.Background(), nil); err != ErrBreakerOpen {
+		t.Errorf("Expected error %q got %q", ErrBreakerOpen, err)
+	}
+
+	if b.Middleware("GetOrders", Limits{}) != nil {
+		t.Errorf("Expected nil middleware for disabled breaker")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	if RateLimitMiddleware(Limits{}) != nil {
+		t.Errorf("Expected nil middleware for unlimited rate")
+	}
+	ep := func(ctx context.Context, request interface{}) (interface{}, error) {
+		return nil, nil
+	}
+	ep = RateLimitMiddleware(Limits{Rate: 10, Burst: 1})(ep)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		ep(context.Background(), nil)
+	}
+	if el := time.Since(start); el < 250*time.Millisecond {
+		t.Errorf("Expected run time > %s got %s", 250*time.Millisecond, el)
+	}
+}
@@ -25,6 +25,7 @@ type PPService interface {
 	GetOrderItems(ctx context.Context, orderID string) ([]OrderItem, error)
 	SetOrderStatus(ctx context.Context, id, status string, notify bool) error
 	AddOrderComment(ctx context.Context, id, email, comment string) error
+	SetTrackingNumber(ctx context.Context, id, trackingNumber, trackingURL string) error
 }
 
 //Date is time.Time, used to Unmarshal custom date format
@@ -120,6 +121,34 @@ type DeliveryAddress struct {
 	Phone        string `json:"Phone"`
 }
 
+//MailProperties returns delivery address as postal properties (lastname, phone, postal, region, city, street, home, debt_sum)
+func (o Order) MailProperties() map[string]string {
+	props := make(map[string]string)
+	//reorder iof to fio
+	iof := strings.Fields(strings.Title(o.DeliveryAddress.FullName))
+	if len(iof) > 1 {
+		fio := make([]string, 0, len(iof))
+		fio = append(fio, iof[len(iof)-1])
+		fio = append(fio, iof[:len(iof)-1]...)
+		props["lastname"] = strings.Join(fio, " ")
+	} else {
+		props["lastname"] = o.DeliveryAddress.FullName
+	}
+
+	props["phone"] = o.DeliveryAddress.Phone
+	//props["email"] = TODO get in from User?
+	//props["passport"] = TODO
+	//props["passport_date"] = TODO
+	props["postal"] = o.DeliveryAddress.ZipCode
+	props["region"] = o.DeliveryAddress.State
+	//props["district"] = TODO
+	props["city"] = o.DeliveryAddress.City
+	props["street"] = o.DeliveryAddress.AddressLine1
+	props["home"] = o.DeliveryAddress.AddressLine2
+	props["debt_sum"] = fmt.Sprintf("%.2f", o.TotalPrice-o.PaidPrice)
+	return props
+}
+
 // Shipping represent pp Shipping
 type Shipping struct {
 	ID           int    `json:"Id"`
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//Local is local file system (local disk or mounted share)
+type Local struct{}
+
+//NewLocal creates local storage
+func NewLocal() *Local {
+	return &Local{}
+}
+
+func (l *Local) MkdirAll(dir string) error {
+	return os.MkdirAll(filepath.FromSlash(dir), 0755)
+}
+
+func (l *Local) RemoveAll(name string) error {
+	return os.RemoveAll(filepath.FromSlash(name))
+}
+
+func (l *Local) Rename(oldname, newname string) error {
+	return os.Rename(filepath.FromSlash(oldname), filepath.FromSlash(newname))
+}
+
+func (l *Local) Link(oldname, newname string) error {
+	return os.Link(filepath.FromSlash(oldname), filepath.FromSlash(newname))
+}
+
+func (l *Local) Create(name string) (io.WriteCloser, error) {
+	f, err := os.OpenFile(filepath.FromSlash(name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &syncFile{f}, nil
+}
+
+func (l *Local) ReadDir(dir string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(filepath.FromSlash(dir))
+}
+
+func (l *Local) Close() error {
+	return nil
+}
+
+//syncFile flushes file to disk on close
+type syncFile struct {
+	*os.File
+}
+
+func (f *syncFile) Close() error {
+	err := f.File.Sync()
+	cerr := f.File.Close()
+	if err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+//SFTPConfig sftp connection settings
+type SFTPConfig struct {
+	Address  string //host:port
+	User     string
+	Password string
+	//KeyFile private key file (used if set)
+	KeyFile string
+	//KnownHosts known_hosts file to check server key, required (credentials are not sent to unknown server)
+	KnownHosts string
+	Timeout    time.Duration
+}
+
+//SFTP is remote storage over sftp
+//connection is restored on next operation if lost
+type SFTP struct {
+	cfg    SFTPConfig
+	mu     sync.Mutex
+	conn   *ssh.Client
+	client *sftp.Client
+}
+
+//DialSFTP connects to sftp server
+func DialSFTP(cfg SFTPConfig) (*SFTP, error) {
+	if cfg.KnownHosts == "" {
+		return nil, errors.New("sftp: known_hosts file is not set, server key can't be checked")
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	s := &SFTP{cfg: cfg}
+	if _, err := s.sftp(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+//sftp returns current client, reconnects if connection is lost
+func (s *SFTP) sftp() (*sftp.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		return s.client, nil
+	}
+	auth := make([]ssh.AuthMethod, 0, 2)
+	if s.cfg.KeyFile != "" {
+		key, err := ioutil.ReadFile(s.cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("sftp: key file %s: %s", s.cfg.KeyFile, err.Error())
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if s.cfg.Password != "" {
+		auth = append(auth, ssh.Password(s.cfg.Password))
+	}
+	hostKey, err := knownhosts.New(s.cfg.KnownHosts)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := ssh.Dial("tcp", s.cfg.Address, &ssh.ClientConfig{
+		User:            s.cfg.User,
+		Auth:            auth,
+		HostKeyCallback: hostKey,
+		Timeout:         s.cfg.Timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	s.conn, s.client = conn, client
+	//forget connection when it is closed
+	go func() {
+		conn.Wait()
+		s.mu.Lock()
+		if s.conn == conn {
+			s.conn, s.client = nil, nil
+		}
+		s.mu.Unlock()
+	}()
+	return client, nil
+}
+
+func (s *SFTP) MkdirAll(dir string) error {
+	c, err := s.sftp()
+	if err != nil {
+		return err
+	}
+	return c.MkdirAll(dir)
+}
+
+func (s *SFTP) RemoveAll(name string) error {
+	c, err := s.sftp()
+	if err != nil {
+		return err
+	}
+	return removeAll(c, name)
+}
+
+func removeAll(c *sftp.Client, name string) error {
+	fi, err := c.Lstat(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !fi.IsDir() {
+		return c.Remove(name)
+	}
+	fis, err := c.ReadDir(name)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if err = removeAll(c, path.Join(name, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return c.RemoveDirectory(name)
+}
+
+func (s *SFTP) Rename(oldname, newname string) error {
+	c, err := s.sftp()
+	if err != nil {
+		return err
+	}
+	if _, ok := c.HasExtension("posix-rename@openssh.com"); ok {
+		return c.PosixRename(oldname, newname)
+	}
+	return c.Rename(oldname, newname)
+}
+
+func (s *SFTP) Link(oldname, newname string) error {
+	c, err := s.sftp()
+	if err != nil {
+		return err
+	}
+	if _, ok := c.HasExtension("hardlink@openssh.com"); !ok {
+		return ErrNotSupported
+	}
+	return c.Link(oldname, newname)
+}
+
+func (s *SFTP) Create(name string) (io.WriteCloser, error) {
+	c, err := s.sftp()
+	if err != nil {
+		return nil, err
+	}
+	return c.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+}
+
+func (s *SFTP) ReadDir(dir string) ([]os.FileInfo, error) {
+	c, err := s.sftp()
+	if err != nil {
+		return nil, err
+	}
+	return c.ReadDir(dir)
+}
+
+func (s *SFTP) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		return nil
+	}
+	err := s.client.Close()
+	if cerr := s.conn.Close(); err == nil {
+		err = cerr
+	}
+	s.conn, s.client = nil, nil
+	return err
+}
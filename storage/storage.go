@@ -0,0 +1,158 @@
+//Package storage implements output storage of cycle folders (local disk, mounted share or SFTP)
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+)
+
+//Storage is file system where transform writes cycle folders
+//paths are slash separated, absolute for storage (os path for Local, remote path for SFTP)
+type Storage interface {
+	//MkdirAll creates folder with parents, no error if folder exists
+	MkdirAll(dir string) error
+	//RemoveAll removes file or folder with content, no error if not exists
+	RemoveAll(name string) error
+	//Rename renames (moves) file or folder, existing file newname is replaced (folder must not exist)
+	Rename(oldname, newname string) error
+	//Link creates hard link newname to oldname, returns error if storage or volume doesn't support links
+	Link(oldname, newname string) error
+	//Create creates or truncates file for writing
+	Create(name string) (io.WriteCloser, error)
+	//ReadDir lists folder
+	ReadDir(dir string) ([]os.FileInfo, error)
+	//Close releases storage connection
+	Close() error
+}
+
+//ErrNotSupported storage doesn't support operation
+var ErrNotSupported = errors.New("storage: operation not supported")
+
+//IsLocal returns true if storage is local file system (os paths can be used to link files)
+func IsLocal(s Storage) bool {
+	_, ok := s.(*Local)
+	return ok
+}
+
+//Recreate removes folder and creates empty one
+func Recreate(s Storage, dir string) error {
+	if err := s.RemoveAll(dir); err != nil {
+		return err
+	}
+	return s.MkdirAll(dir)
+}
+
+//Publication is atomic publish of folder
+//content is written to temp folder next to target and replaces target on Commit,
+//so cycle never sees partly written folder
+type Publication struct {
+	s    Storage
+	name string
+	//Dir temp folder to write content to
+	Dir  string
+	done bool
+}
+
+//Publish starts publication of folder name, creates empty temp folder
+func Publish(s Storage, name string) (*Publication, error) {
+	p := &Publication{s: s, name: name, Dir: path.Join(path.Dir(name), "."+path.Base(name)+".tmp")}
+	if err := Recreate(s, p.Dir); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+//Commit replaces target folder by temp folder (see CommitAll)
+func (p *Publication) Commit() error {
+	return CommitAll(p)
+}
+
+//CommitAll replaces target folders by temp folders of publications, all or none
+//existing targets are renamed to backups, then temp folders are renamed to targets and backups are removed,
+//if some rename fails, published targets are moved back to temp folders and backups are restored
+func CommitAll(pubs ...*Publication) error {
+	type step struct {
+		p         *Publication
+		backup    string
+		backed    bool
+		published bool
+	}
+	steps := make([]step, 0, len(pubs))
+	for _, p := range pubs {
+		if !p.done {
+			steps = append(steps, step{p: p, backup: path.Join(path.Dir(p.name), "."+path.Base(p.name)+".bak")})
+		}
+	}
+	rollback := func(err error) error {
+		for i := len(steps) - 1; i >= 0; i-- {
+			st := steps[i]
+			if st.published {
+				st.p.s.Rename(st.p.name, st.p.Dir)
+			}
+			if st.backed {
+				st.p.s.Rename(st.backup, st.p.name)
+			}
+		}
+		return err
+	}
+	//backup targets
+	for i := range steps {
+		st := &steps[i]
+		//backup of broken commit
+		if err := st.p.s.RemoveAll(st.backup); err != nil {
+			return rollback(err)
+		}
+		ok, err := exists(st.p.s, st.p.name)
+		if err != nil {
+			return rollback(err)
+		}
+		if !ok {
+			continue
+		}
+		if err = st.p.s.Rename(st.p.name, st.backup); err != nil {
+			return rollback(err)
+		}
+		st.backed = true
+	}
+	//publish
+	for i := range steps {
+		st := &steps[i]
+		if err := st.p.s.Rename(st.p.Dir, st.p.name); err != nil {
+			return rollback(err)
+		}
+		st.published = true
+	}
+	for _, st := range steps {
+		st.p.done = true
+		if st.backed {
+			//not removed backup is removed by next commit
+			st.p.s.RemoveAll(st.backup)
+		}
+	}
+	return nil
+}
+
+//exists checks if file or folder exists
+func exists(s Storage, name string) (bool, error) {
+	fis, err := s.ReadDir(path.Dir(name))
+	if err != nil {
+		return false, err
+	}
+	for _, fi := range fis {
+		if fi.Name() == path.Base(name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//Abort removes temp folder if publication is not commited
+func (p *Publication) Abort() error {
+	if p.done {
+		return nil
+	}
+	p.done = true
+	return p.s.RemoveAll(p.Dir)
+}
@@ -0,0 +1,299 @@
+package storage
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+//startSFTP runs in-process sftp server, returns address and known_hosts file of server key
+func startSFTP(t *testing.T) (string, string) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "lab" && string(pass) == "secret" {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	cfg.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(nc, cfg)
+		}
+	}()
+	addr := l.Addr().String()
+	known := filepath.Join(t.TempDir(), "known_hosts")
+	if err = ioutil.WriteFile(known, []byte(knownhosts.Line([]string{knownhosts.Normalize(addr)}, signer.PublicKey())+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return addr, known
+}
+
+func serveSFTP(nc net.Conn, cfg *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(nc, cfg)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nch := range chans {
+		if nch.ChannelType() != "session" {
+			nch.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, requests, err := nch.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					srv, err := sftp.NewServer(ch)
+					if err != nil {
+						return
+					}
+					srv.Serve()
+					srv.Close()
+				}
+			}
+		}()
+	}
+}
+
+func writeFile(t *testing.T, s Storage, name, content string) {
+	w, err := s.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func list(t *testing.T, s Storage, dir string) []string {
+	fis, err := s.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make([]string, 0, len(fis))
+	for _, fi := range fis {
+		res = append(res, fi.Name())
+	}
+	sort.Strings(res)
+	return res
+}
+
+func testStorage(t *testing.T, s Storage, root string) {
+	order := path.Join(root, "in", "123")
+	if err := Recreate(s, order); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, s, path.Join(order, "old.jpg"), "old")
+
+	//publish new content
+	p, err := Publish(s, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.MkdirAll(path.Join(p.Dir, "book")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, s, path.Join(p.Dir, "book", "001-01.jpg"), "page")
+	if err = s.Link(path.Join(p.Dir, "book", "001-01.jpg"), path.Join(p.Dir, "book", "002-01.jpg")); err != nil && err != ErrNotSupported {
+		t.Fatal(err)
+	}
+	//target is not changed till commit
+	if got := list(t, s, order); len(got) != 1 || got[0] != "old.jpg" {
+		t.Errorf("before commit %v", got)
+	}
+	if err = p.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got := list(t, s, path.Join(order, "book")); len(got) != 2 || got[0] != "001-01.jpg" {
+		t.Errorf("after commit %v", got)
+	}
+	if got := list(t, s, path.Join(root, "in")); len(got) != 1 || got[0] != "123" {
+		t.Errorf("temp folder not removed %v", got)
+	}
+
+	//aborted publication keeps target
+	p, err = Publish(s, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, s, path.Join(p.Dir, "broken.jpg"), "x")
+	if err = p.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if got := list(t, s, path.Join(root, "in")); len(got) != 1 || got[0] != "123" {
+		t.Errorf("after abort %v", got)
+	}
+	if got := list(t, s, order); len(got) != 1 || got[0] != "book" {
+		t.Errorf("after abort %v", got)
+	}
+
+	//remove not existing
+	if err = s.RemoveAll(path.Join(root, "none")); err != nil {
+		t.Errorf("RemoveAll() = %v", err)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	root := filepath.ToSlash(t.TempDir())
+	s := NewLocal()
+	testStorage(t, s, root)
+	if !IsLocal(s) {
+		t.Error("IsLocal() = false")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(filepath.FromSlash(root), "in", "123", "book", "002-01.jpg"))
+	if err != nil || string(data) != "page" {
+		t.Errorf("linked file = %q, %v", data, err)
+	}
+}
+
+//failRename fails rename of folder
+type failRename struct {
+	Storage
+	name string
+}
+
+func (s *failRename) Rename(oldname, newname string) error {
+	if oldname == s.name {
+		return os.ErrPermission
+	}
+	return s.Storage.Rename(oldname, newname)
+}
+
+func TestCommitAll(t *testing.T) {
+	root := filepath.ToSlash(t.TempDir())
+	out, wrk := path.Join(root, "out", "123"), path.Join(root, "in", "123")
+	s := NewLocal()
+	for _, dir := range []string{out, wrk} {
+		if err := Recreate(s, dir); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, s, path.Join(dir, "old.jpg"), "old")
+	}
+	pOut, err := Publish(s, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, s, path.Join(pOut.Dir, "new.jpg"), "new")
+	fs := &failRename{Storage: s}
+	pWrk, err := Publish(fs, wrk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, s, path.Join(pWrk.Dir, "new.jpg"), "new")
+
+	//second publication fails, first one is restored
+	fs.name = pWrk.Dir
+	if err = CommitAll(pOut, pWrk); err == nil {
+		t.Fatal("CommitAll() expected error")
+	}
+	for _, dir := range []string{out, wrk} {
+		if got := list(t, s, dir); len(got) != 1 || got[0] != "old.jpg" {
+			t.Errorf("%s after failed commit %v", dir, got)
+		}
+	}
+	pOut.Abort()
+	pWrk.Abort()
+	if got := list(t, s, path.Join(root, "out")); len(got) != 1 || got[0] != "123" {
+		t.Errorf("temp or backup folders are not removed %v", got)
+	}
+
+	//all published
+	pOut, _ = Publish(s, out)
+	writeFile(t, s, path.Join(pOut.Dir, "new.jpg"), "new")
+	pWrk, _ = Publish(s, wrk)
+	writeFile(t, s, path.Join(pWrk.Dir, "new.jpg"), "new")
+	if err = CommitAll(pOut, pWrk); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{out, wrk} {
+		if got := list(t, s, dir); len(got) != 1 || got[0] != "new.jpg" {
+			t.Errorf("%s after commit %v", dir, got)
+		}
+		if got := list(t, s, path.Dir(dir)); len(got) != 1 {
+			t.Errorf("backup is not removed %v", got)
+		}
+	}
+}
+
+func TestSFTP(t *testing.T) {
+	addr, known := startSFTP(t)
+	if _, err := DialSFTP(SFTPConfig{Address: addr, User: "lab", Password: "secret"}); err == nil {
+		t.Fatal("DialSFTP() expected known_hosts error")
+	}
+	//server is not in known_hosts
+	_, otherKnown := startSFTP(t)
+	if _, err := DialSFTP(SFTPConfig{Address: addr, User: "lab", Password: "secret", KnownHosts: otherKnown}); err == nil {
+		t.Fatal("DialSFTP() expected host key error")
+	}
+	if _, err := DialSFTP(SFTPConfig{Address: addr, User: "lab", Password: "wrong", KnownHosts: known}); err == nil {
+		t.Fatal("DialSFTP() expected auth error")
+	}
+	s, err := DialSFTP(SFTPConfig{Address: addr, User: "lab", Password: "secret", KnownHosts: known})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	root := filepath.ToSlash(t.TempDir())
+	testStorage(t, s, root)
+	if IsLocal(s) {
+		t.Error("IsLocal() = true")
+	}
+	//written on server side
+	data, err := ioutil.ReadFile(filepath.Join(filepath.FromSlash(root), "in", "123", "book", "001-01.jpg"))
+	if err != nil || string(data) != "page" {
+		t.Errorf("remote file = %q, %v", data, err)
+	}
+
+	//connection lost, restored on next operation
+	s.mu.Lock()
+	s.conn.Close()
+	s.mu.Unlock()
+	for i := 0; i < 100; i++ {
+		if err = s.MkdirAll(path.Join(root, "restored")); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Errorf("MkdirAll() after reconnect = %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+package transform
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+//ArchiveConfig archive of original order zips (for reprints)
+type ArchiveConfig struct {
+	//Folder archive folder, archive is disabled if empty
+	Folder string
+	//Retention how long zip is kept, 0 - unlimited
+	Retention time.Duration
+	//MaxSize archive size cap (bytes), oldest zips are removed first, 0 - unlimited
+	MaxSize int64
+}
+
+//ArchiveEntry is archived order zip
+type ArchiveEntry struct {
+	OrderID string `json:"order_id"`
+	//File path relative to archive folder
+	File     string    `json:"file"`
+	Size     int64     `json:"size"`
+	Archived time.Time `json:"archived"`
+}
+
+const archiveIndexName = "index.json"
+
+//Archive stores finished order zips, indexed by pp order ID
+type Archive struct {
+	cfg   ArchiveConfig
+	mu    sync.Mutex
+	index map[string]ArchiveEntry
+}
+
+//NewArchive opens archive folder and loads index, expired zips are removed
+func NewArchive(cfg ArchiveConfig) (*Archive, error) {
+	if cfg.Folder == "" {
+		return nil, fmt.Errorf("Archive folder not set")
+	}
+	if err := os.MkdirAll(cfg.Folder, 0755); err != nil {
+		return nil, err
+	}
+	a := &Archive{cfg: cfg, index: make(map[string]ArchiveEntry)}
+	data, err := ioutil.ReadFile(filepath.Join(cfg.Folder, archiveIndexName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		entries := []ArchiveEntry{}
+		if err = json.Unmarshal(data, &entries); err != nil {
+			return nil, fmt.Errorf("Archive index %s: %s", archiveIndexName, err.Error())
+		}
+		for _, e := range entries {
+			a.index[e.OrderID] = e
+		}
+	}
+	if _, err = a.Cleanup(time.Now()); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+//Put moves order zip to archive, replaces previous zip of order
+func (a *Archive) Put(orderID, zipPath string) error {
+	fi, err := os.Stat(zipPath)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	e := ArchiveEntry{
+		OrderID:  orderID,
+		File:     filepath.ToSlash(filepath.Join(now.Format("2006-01"), orderID+".zip")),
+		Size:     fi.Size(),
+		Archived: now,
+	}
+	dst := filepath.Join(a.cfg.Folder, filepath.FromSlash(e.File))
+	if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	old, ok := a.index[orderID]
+	a.mu.Unlock()
+	if ok && old.File != e.File {
+		os.Remove(filepath.Join(a.cfg.Folder, filepath.FromSlash(old.File)))
+	}
+	if err = moveFile(zipPath, dst); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.index[orderID] = e
+	err = a.save()
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	_, err = a.Cleanup(now)
+	return err
+}
+
+//Get returns archived zip of order
+func (a *Archive) Get(orderID string) (ArchiveEntry, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e, ok := a.index[orderID]
+	return e, ok
+}
+
+//List returns archived zips, oldest first
+func (a *Archive) List() []ArchiveEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.sorted()
+}
+
+//Size returns archive size (bytes)
+func (a *Archive) Size() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var size int64
+	for _, e := range a.index {
+		size += e.Size
+	}
+	return size
+}
+
+//Cleanup removes zips older than retention and oldest zips over size cap, returns removed count
+func (a *Archive) Cleanup(now time.Time) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entries := a.sorted()
+	var size int64
+	for _, e := range entries {
+		size += e.Size
+	}
+	removed := 0
+	for _, e := range entries {
+		expired := a.cfg.Retention > 0 && now.Sub(e.Archived) > a.cfg.Retention
+		oversize := a.cfg.MaxSize > 0 && size > a.cfg.MaxSize
+		if !expired && !oversize {
+			//sorted by date, rest are newer
+			break
+		}
+		if err := os.Remove(filepath.Join(a.cfg.Folder, filepath.FromSlash(e.File))); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		delete(a.index, e.OrderID)
+		size -= e.Size
+		removed++
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, a.save()
+}
+
+//Restore copies archived order zip to work folder and unzips it (as loadZIP and unzip stages do)
+func (a *Archive) Restore(orderID, wrkFolder string) (ArchiveEntry, error) {
+	e, ok := a.Get(orderID)
+	if !ok {
+		return e, ErrSourceNotFound{fmt.Errorf("Заказ %s не найден в архиве", orderID)}
+	}
+	zipPath := filepath.Join(wrkFolder, orderID+".zip")
+	if err := copyFile(filepath.Join(a.cfg.Folder, filepath.FromSlash(e.File)), zipPath); err != nil {
+		if os.IsNotExist(err) {
+			return e, ErrSourceNotFound{err}
+		}
+		return e, ErrFileSystem{err}
+	}
+	basePath := filepath.Join(wrkFolder, orderID)
+	if err := recreateFolder(basePath); err != nil {
+		return e, ErrFileSystem{err}
+	}
+	if err := extractZip(zipPath, basePath); err != nil {
+		return e, ErrFileSystem{err}
+	}
+	return e, nil
+}
+
+//sorted returns index entries by archive date, caller holds mu
+func (a *Archive) sorted() []ArchiveEntry {
+	res := make([]ArchiveEntry, 0, len(a.index))
+	for _, e := range a.index {
+		res = append(res, e)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Archived.Before(res[j].Archived) })
+	return res
+}
+
+//save writes index, caller holds mu
+func (a *Archive) save() error {
+	data, err := json.MarshalIndent(a.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	fn := filepath.Join(a.cfg.Folder, archiveIndexName)
+	if err = ioutil.WriteFile(fn+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(fn+".tmp", fn)
+}
+
+//moveFile renames file, copies if rename fails (other volume)
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+//extractZip unzips file to basePath, zip root folder is replaced by basePath
+func extractZip(fileName, basePath string) error {
+	reader, err := zip.OpenReader(fileName)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	for _, file := range reader.File {
+		fp := replaceRootPath(file.Name, basePath)
+		if file.FileInfo().IsDir() {
+			if err = os.MkdirAll(fp, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+			return err
+		}
+		if err = extractFile(file, fp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractFile(file *zip.File, fp string) (err error) {
+	in, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(fp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		cerr := out.Close()
+		if err == nil {
+			err = cerr
+		}
+	}()
+	_, err = io.Copy(out, in)
+	return
+}
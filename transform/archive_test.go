@@ -0,0 +1,73 @@
+package transform
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestArchive(t *testing.T) {
+	wrk := t.TempDir()
+	dir := filepath.Join(t.TempDir(), "archive")
+	a, err := NewArchive(ArchiveConfig{Folder: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		fn := writeOrderZip(t, wrk, id, 2, 1024)
+		if err = a.Put(id, fn); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = os.Stat(fn); !os.IsNotExist(err) {
+			t.Errorf("%s: zip is not moved", id)
+		}
+	}
+	size := a.Size()
+
+	//index is persisted
+	a, err = NewArchive(ArchiveConfig{Folder: dir, MaxSize: size})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a.List(); len(got) != 3 || got[0].OrderID != "1" {
+		t.Fatalf("List() = %v", got)
+	}
+
+	//restore
+	if _, err = a.Restore("2", wrk); err != nil {
+		t.Fatal(err)
+	}
+	if !folderExists(filepath.Join(wrk, "2", "1")) {
+		t.Error("Restore() item folder not found")
+	}
+	if _, err = os.Stat(filepath.Join(wrk, "2.zip")); err != nil {
+		t.Errorf("Restore() zip: %v", err)
+	}
+	if _, err = a.Restore("404", wrk); err == nil {
+		t.Error("Restore() expected error")
+	} else if _, ok := err.(ErrSourceNotFound); !ok {
+		t.Errorf("Restore() error %T, want ErrSourceNotFound", err)
+	}
+
+	//size cap removes oldest
+	if err = a.Put("4", writeOrderZip(t, wrk, "4", 2, 1024)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.Get("1"); ok {
+		t.Error("oldest zip is not removed by size cap")
+	}
+	if _, ok := a.Get("4"); !ok {
+		t.Error("new zip not found")
+	}
+
+	//retention
+	a.cfg.Retention = time.Hour
+	n, err := a.Cleanup(time.Now().Add(2 * time.Hour))
+	if err != nil || n != 3 {
+		t.Errorf("Cleanup() = %d, %v; want 3", n, err)
+	}
+	if got := a.List(); len(got) != 0 {
+		t.Errorf("List() after cleanup = %v", got)
+	}
+}
@@ -0,0 +1,207 @@
+package transform
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+//RateLimiter download speed limiter (same as grab.RateLimiter)
+type RateLimiter interface {
+	WaitN(ctx context.Context, n int) (err error)
+}
+
+//BandwidthWindow download speed limit in day time window
+type BandwidthWindow struct {
+	//From, To window start and end (hh:mm), window can pass midnight (20:00 - 8:00), From = To - all day
+	From string
+	To   string
+	//Mbps speed limit (Mbit/s), 0 - unlimited
+	Mbps float64
+}
+
+//BandwidthInfo current limit and effective throughput (Mbit/s)
+type BandwidthInfo struct {
+	//Limit 0 - unlimited
+	Limit      float64 `json:"limit"`
+	Throughput float64 `json:"throughput"`
+}
+
+//Bandwidth download speed limiter shared by concurrent downloads
+//limit is taken from the first window matched by current time, unlimited if there is no matched window
+type Bandwidth struct {
+	windows []window
+	parent  *Bandwidth
+	now     func() time.Time
+
+	mu      sync.Mutex
+	limiter *rate.Limiter //nil if unlimited
+	limit   float64       //current limit bytes/s, 0 - unlimited
+	meter   meter
+}
+
+//window parsed BandwidthWindow, minutes of day
+type window struct {
+	from, to int
+	bps      float64
+}
+
+func (w window) match(m int) bool {
+	if w.from == w.to {
+		return true
+	}
+	if w.from < w.to {
+		return m >= w.from && m < w.to
+	}
+	//passes midnight
+	return m >= w.from || m < w.to
+}
+
+//NewBandwidth creates download speed limiter
+func NewBandwidth(windows []BandwidthWindow) (*Bandwidth, error) {
+	b := &Bandwidth{now: time.Now}
+	for _, w := range windows {
+		from, err := parseDayTime(w.From)
+		if err != nil {
+			return nil, err
+		}
+		to, err := parseDayTime(w.To)
+		if err != nil {
+			return nil, err
+		}
+		if w.Mbps < 0 {
+			return nil, fmt.Errorf("Не верное ограничение скорости %.2f", w.Mbps)
+		}
+		b.windows = append(b.windows, window{from: from, to: to, bps: w.Mbps * 1000 * 1000 / 8})
+	}
+	return b, nil
+}
+
+//Sub creates source limiter, downloads are limited by source windows and by b
+func (b *Bandwidth) Sub(windows []BandwidthWindow) (*Bandwidth, error) {
+	s, err := NewBandwidth(windows)
+	if err != nil {
+		return nil, err
+	}
+	s.parent = b
+	return s, nil
+}
+
+//parseDayTime parses hh:mm to minutes of day
+func parseDayTime(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("Не верное время %s, ожидается чч:мм", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+//update sets limiter limit by current time window, must be called under lock
+func (b *Bandwidth) update(now time.Time) {
+	m := now.Hour()*60 + now.Minute()
+	var limit float64
+	for _, w := range b.windows {
+		if w.match(m) {
+			limit = w.bps
+			break
+		}
+	}
+	if limit == b.limit {
+		return
+	}
+	b.limit = limit
+	if limit == 0 {
+		return
+	}
+	//burst 1 second of traffic (at least 64KB, grab reads by 32KB)
+	burst := int(limit)
+	if burst < 64*1024 {
+		burst = 64 * 1024
+	}
+	b.limiter = rate.NewLimiter(rate.Limit(limit), burst)
+}
+
+//WaitN blocks till n bytes can be loaded, implements grab.RateLimiter
+func (b *Bandwidth) WaitN(ctx context.Context, n int) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	now := b.now()
+	b.update(now)
+	b.meter.add(now, n)
+	limiter, limit := b.limiter, b.limit
+	b.mu.Unlock()
+
+	if limit > 0 {
+		//limiter rejects n > burst
+		for rest := n; rest > 0; {
+			c := rest
+			if c > limiter.Burst() {
+				c = limiter.Burst()
+			}
+			if err := limiter.WaitN(ctx, c); err != nil {
+				return err
+			}
+			rest -= c
+		}
+	}
+	if b.parent != nil {
+		return b.parent.WaitN(ctx, n)
+	}
+	return nil
+}
+
+//Info returns current limit and throughput
+func (b *Bandwidth) Info() BandwidthInfo {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	b.update(now)
+	return BandwidthInfo{
+		Limit:      b.limit * 8 / (1000 * 1000),
+		Throughput: b.meter.rate(now) * 8 / (1000 * 1000),
+	}
+}
+
+//meterSeconds throughput is averaged over last meterSeconds seconds
+const meterSeconds = 5
+
+//meter counts loaded bytes by seconds
+type meter struct {
+	sec     int64
+	buckets [meterSeconds + 1]int64
+}
+
+//advance moves meter to second s, clears skipped buckets
+func (m *meter) advance(s int64) {
+	if s-m.sec > meterSeconds {
+		m.sec = s - meterSeconds - 1
+	}
+	for m.sec < s {
+		m.sec++
+		m.buckets[m.sec%(meterSeconds+1)] = 0
+	}
+}
+
+func (m *meter) add(now time.Time, n int) {
+	s := now.Unix()
+	m.advance(s)
+	m.buckets[s%(meterSeconds+1)] += int64(n)
+}
+
+//rate returns bytes/s of completed seconds
+func (m *meter) rate(now time.Time) float64 {
+	s := now.Unix()
+	m.advance(s)
+	var sum int64
+	for i := range m.buckets {
+		if int64(i) != s%(meterSeconds+1) {
+			sum += m.buckets[i]
+		}
+	}
+	return float64(sum) / meterSeconds
+}
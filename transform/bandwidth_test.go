@@ -0,0 +1,81 @@
+package transform
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBandwidth_Info(t *testing.T) {
+	b, err := NewBandwidth([]BandwidthWindow{
+		{From: "08:00", To: "20:00", Mbps: 20},
+		{From: "22:00", To: "02:00", Mbps: 100},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		clock string
+		want  float64
+	}{
+		{"07:59", 0},
+		{"08:00", 20},
+		{"19:59", 20},
+		{"20:00", 0},
+		{"23:30", 100},
+		{"01:00", 100},
+		{"02:00", 0},
+	}
+	for _, tt := range tests {
+		now, _ := time.Parse("15:04", tt.clock)
+		b.now = func() time.Time { return now }
+		if got := b.Info().Limit; got != tt.want {
+			t.Errorf("Info() at %s limit = %v, want %v", tt.clock, got, tt.want)
+		}
+	}
+
+	if _, err := NewBandwidth([]BandwidthWindow{{From: "8", To: "20:00", Mbps: 20}}); err == nil {
+		t.Error("NewBandwidth() expected time format error")
+	}
+	if _, err := NewBandwidth([]BandwidthWindow{{From: "08:00", To: "20:00", Mbps: -1}}); err == nil {
+		t.Error("NewBandwidth() expected limit error")
+	}
+}
+
+func TestBandwidth_WaitN(t *testing.T) {
+	var nb *Bandwidth
+	if err := nb.WaitN(context.Background(), 1<<20); err != nil {
+		t.Errorf("nil limiter must be unlimited, got %v", err)
+	}
+
+	//8 Mbit/s - 1MB/s, burst 1MB
+	parent, _ := NewBandwidth([]BandwidthWindow{{From: "00:00", To: "00:00", Mbps: 8}})
+	sub, _ := parent.Sub(nil)
+	if err := sub.WaitN(context.Background(), 1000*1000); err != nil {
+		t.Fatalf("WaitN() within burst error %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := sub.WaitN(ctx, 1000*1000); err == nil {
+		t.Error("WaitN() over parent limit must wait")
+	}
+	if l := sub.Info().Limit; l != 0 {
+		t.Errorf("sub limiter without windows limit = %v, want 0", l)
+	}
+}
+
+func TestMeter(t *testing.T) {
+	var m meter
+	start := time.Unix(1000, 0)
+	for i := 0; i < meterSeconds; i++ {
+		m.add(start.Add(time.Duration(i)*time.Second), 1000)
+	}
+	//current second is not counted
+	m.add(start.Add(meterSeconds*time.Second), 5000)
+	if got := m.rate(start.Add(meterSeconds * time.Second)); got != 1000 {
+		t.Errorf("rate() = %v, want 1000", got)
+	}
+	if got := m.rate(start.Add(time.Minute)); got != 0 {
+		t.Errorf("rate() after idle = %v, want 0", got)
+	}
+}
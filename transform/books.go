@@ -0,0 +1,152 @@
+package transform
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+)
+
+//personalised books (every copy has own pages)
+//copy pages are placed in subfolder copy_N (book_N) of item folder or named copy_N_<page name> (book_N_<page name>)
+//files of item folder are common for all copies, copy file replaces common file of the same sheet
+var (
+	reCopyFolder = regexp.MustCompile(`^(?:copy|book)_(\d+)$`)
+	reCopyPrefix = regexp.MustCompile(`^(?:copy|book)_(\d+)[_-]`)
+)
+
+//sheetName returns file name without copy prefix
+func sheetName(name string) string {
+	if loc := reCopyPrefix.FindStringIndex(name); loc != nil {
+		return name[loc[1]:]
+	}
+	return name
+}
+
+//BookLayout is layout of one book copy
+type BookLayout struct {
+	//Copy copy number (1..quantity), 0 - all copies are identical
+	Copy   int          `json:"copy"`
+	Report LayoutReport `json:"report"`
+
+	files []fileCopy
+}
+
+//ValidateBookFolder validates book item folder, returns layout of each copy
+//if folder has no personalised copies returns one layout vs Copy 0
+func ValidateBookFolder(folder string, quantity int, p LayoutParams) ([]BookLayout, error) {
+	return layoutBooks(os.DirFS(folder), ".", quantity, p)
+}
+
+//layoutBooks reads item folder (basePath in fsys) and validates layout of each copy
+func layoutBooks(fsys fs.FS, basePath string, quantity int, p LayoutParams) ([]BookLayout, error) {
+	common, err := fillList(fsys, basePath, 1)
+	if err != nil {
+		return nil, ErrSourceNotFound{err}
+	}
+	copies, err := fillCopies(fsys, basePath, &common)
+	if err != nil {
+		return nil, ErrSourceNotFound{err}
+	}
+	if len(common) == 0 && len(copies) == 0 {
+		return nil, ErrSourceNotFound{fmt.Errorf("Empty folder '%s'", basePath)}
+	}
+
+	if len(copies) == 0 {
+		r, err := validateLayout(common, p)
+		if err != nil {
+			return nil, ErrParce{err}
+		}
+		return []BookLayout{{Copy: 0, Report: r, files: common}}, nil
+	}
+
+	for c := range copies {
+		if c < 1 || c > quantity {
+			return nil, ErrParce{fmt.Errorf("Экземпляр %d вне диапазона 1-%d", c, quantity)}
+		}
+	}
+
+	//index common sheets once
+	if err = listIndexSheets(common, p.HasCover, p.Maket, p.FakeCover); err != nil {
+		return nil, ErrParce{err}
+	}
+	res := make([]BookLayout, 0, quantity)
+	for c := 1; c <= quantity; c++ {
+		own := copies[c]
+		if err = listIndexSheets(own, p.HasCover, p.Maket, p.FakeCover); err != nil {
+			return nil, ErrParce{err}
+		}
+		//copy sheets replace common
+		replaced := make(map[int]bool)
+		for _, fi := range own {
+			if fi.Process {
+				replaced[fi.SheetIdx] = true
+			}
+		}
+		merged := make([]fileCopy, 0, len(common)+len(own))
+		for _, fi := range own {
+			fi.Process = allowedExt[path.Ext(fi.OldName)]
+			merged = append(merged, fi)
+		}
+		for _, fi := range common {
+			if fi.Process && replaced[fi.SheetIdx] {
+				continue
+			}
+			fi.Process = allowedExt[path.Ext(fi.OldName)]
+			merged = append(merged, fi)
+		}
+		r, err := validateLayout(merged, p)
+		if err != nil {
+			return nil, ErrParce{err}
+		}
+		//report subfolder files vs folder name
+		for i, fi := range merged {
+			if fi.OldPath != basePath {
+				r.Files[i].Name = path.Join(path.Base(fi.OldPath), fi.OldName)
+			}
+		}
+		res = append(res, BookLayout{Copy: c, Report: r, files: merged})
+	}
+	return res, nil
+}
+
+//fillCopies collects files of personalised copies (subfolders and prefixed names),
+//prefixed files are removed from common list
+func fillCopies(fsys fs.FS, basePath string, common *[]fileCopy) (map[int][]fileCopy, error) {
+	copies := make(map[int][]fileCopy)
+
+	rest := (*common)[:0]
+	for _, fi := range *common {
+		sm := reCopyPrefix.FindStringSubmatch(fi.OldName)
+		if len(sm) != 2 {
+			rest = append(rest, fi)
+			continue
+		}
+		c, _ := strconv.Atoi(sm[1])
+		copies[c] = append(copies[c], fi)
+	}
+	*common = rest
+
+	fis, err := fs.ReadDir(fsys, basePath)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+		sm := reCopyFolder.FindStringSubmatch(fi.Name())
+		if len(sm) != 2 {
+			continue
+		}
+		c, _ := strconv.Atoi(sm[1])
+		lst, err := fillList(fsys, path.Join(basePath, fi.Name()), 1)
+		if err != nil && !isNotExist(err) {
+			return nil, err
+		}
+		copies[c] = append(copies[c], lst...)
+	}
+	return copies, nil
+}
@@ -0,0 +1,110 @@
+package transform
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_layoutBooks(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		qty   int
+		//want copies, 0 - identical copies
+		copies []int
+		ok     []bool
+		//want book files count per copy
+		used []int
+		err  bool
+	}{
+		{
+			name:   "identical copies",
+			files:  []string{"surface_[0].jpg", "surface_[1].jpg", "surface_[2].jpg"},
+			qty:    3,
+			copies: []int{0},
+			ok:     []bool{true},
+			used:   []int{3},
+		},
+		{
+			name:   "personal cover in subfolders",
+			files:  []string{"surface_[1].jpg", "surface_[2].jpg", "copy_1/surface_[0].jpg", "copy_2/surface_[0].jpg"},
+			qty:    2,
+			copies: []int{1, 2},
+			ok:     []bool{true, true},
+			used:   []int{3, 3},
+		},
+		{
+			name:   "personal page replaces common by prefix",
+			files:  []string{"surface_[0].jpg", "surface_[1].jpg", "surface_[2].jpg", "book_2_surface_[2].jpg"},
+			qty:    2,
+			copies: []int{1, 2},
+			ok:     []bool{true, true},
+			used:   []int{3, 3},
+		},
+		{
+			name:   "copy misses cover",
+			files:  []string{"surface_[1].jpg", "surface_[2].jpg", "copy_1/surface_[0].jpg"},
+			qty:    2,
+			copies: []int{1, 2},
+			ok:     []bool{true, false},
+			used:   []int{3, 2},
+		},
+		{
+			name:   "duplicate sheet in copy",
+			files:  []string{"surface_[0].jpg", "surface_[1].jpg", "copy_1/surface_[1].jpg", "copy_1/surface_[1](2).jpg"},
+			qty:    1,
+			copies: []int{1},
+			ok:     []bool{false},
+			used:   []int{3},
+		},
+		{
+			name:  "copy out of quantity",
+			files: []string{"surface_[0].jpg", "surface_[1].jpg", "copy_3/surface_[0].jpg"},
+			qty:   2,
+			err:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, f := range tt.files {
+				fp := filepath.Join(dir, filepath.FromSlash(f))
+				if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(fp, []byte("x"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			books, err := layoutBooks(os.DirFS(dir), ".", tt.qty, LayoutParams{HasCover: true, PageCount: 3})
+			if (err != nil) != tt.err {
+				t.Fatalf("layoutBooks() error = %v, want error %v", err, tt.err)
+			}
+			if tt.err {
+				return
+			}
+			if len(books) != len(tt.copies) {
+				t.Fatalf("layoutBooks() copies = %d, want %d", len(books), len(tt.copies))
+			}
+			for i, b := range books {
+				if b.Copy != tt.copies[i] {
+					t.Errorf("copy %d: Copy = %d, want %d", i, b.Copy, tt.copies[i])
+				}
+				if b.Report.Ok() != tt.ok[i] {
+					t.Errorf("copy %d: Ok() = %v, issues %+v", b.Copy, b.Report.Ok(), b.Report.Issues)
+				}
+				n := 0
+				for _, fi := range b.files {
+					if fi.Process {
+						n++
+					}
+				}
+				if n != tt.used[i] {
+					t.Errorf("copy %d: files = %d, want %d", b.Copy, n, tt.used[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package transform
+
+import "context"
+
+//Budget limits running transforms of several managers (sources in one process)
+type Budget struct {
+	ch chan struct{}
+}
+
+//NewBudget creates budget of n running transforms, n <= 0 - unlimited (nil)
+func NewBudget(n int) *Budget {
+	if n <= 0 {
+		return nil
+	}
+	return &Budget{ch: make(chan struct{}, n)}
+}
+
+//acquire blocks till budget slot is free or ctx is done, nil budget is unlimited
+func (b *Budget) acquire(ctx context.Context) bool {
+	if b == nil {
+		return true
+	}
+	select {
+	case b.ch <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+//tryAcquire takes budget slot if it is free, nil budget is unlimited
+func (b *Budget) tryAcquire() bool {
+	if b == nil {
+		return true
+	}
+	select {
+	case b.ch <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+//release frees budget slot
+func (b *Budget) release() {
+	if b == nil {
+		return
+	}
+	<-b.ch
+}
+
+//InUse returns running transforms count
+func (b *Budget) InUse() int {
+	if b == nil {
+		return 0
+	}
+	return len(b.ch)
+}
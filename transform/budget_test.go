@@ -0,0 +1,29 @@
+package transform
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBudget(t *testing.T) {
+	var nb *Budget
+	if !nb.acquire(context.Background()) || nb.InUse() != 0 {
+		t.Error("nil budget must be unlimited")
+	}
+	nb.release()
+
+	b := NewBudget(1)
+	if !b.acquire(context.Background()) || b.InUse() != 1 {
+		t.Fatal("acquire failed")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if b.acquire(ctx) {
+		t.Error("acquire over budget must block till ctx is done")
+	}
+	b.release()
+	if !b.acquire(context.Background()) {
+		t.Error("acquire after release failed")
+	}
+}
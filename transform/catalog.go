@@ -0,0 +1,155 @@
+package transform
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"io/fs"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	//image decoders
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+
+	pc "github.com/egorka-gh/pixlpark/photocycle"
+	"github.com/spf13/viper"
+)
+
+//catalogTTL photo catalogue reload interval
+const catalogTTL = 10 * time.Minute
+
+//aspectTolerance allowed deviation of image aspect ratio from print size
+const aspectTolerance = 0.03
+
+//legacyFormat print parameters used if catalogue is empty
+var legacyFormat = pc.PhotoFormat{Cutting: 20, Frame: 15, NBCutting: 19, NBFrame: 0}
+
+//photoCatalog photo print formats,
+//loaded from cycle DB (pp_photo_format) or from config (photo.formats) if DB has no formats
+type photoCatalog struct {
+	mu      sync.Mutex
+	formats []pc.PhotoFormat
+	loaded  time.Time
+}
+
+//format returns print parameters for sku size and paper,
+//returns ErrTransform if catalogue is not empty and has no such format
+func (c *photoCatalog) format(ctx context.Context, rep pc.Repository, width, height, paper int) (pc.PhotoFormat, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.formats == nil || time.Since(c.loaded) > catalogTTL {
+		formats, err := rep.LoadPhotoFormats(ctx)
+		if err != nil || len(formats) == 0 {
+			formats = []pc.PhotoFormat{}
+			if err = viper.UnmarshalKey("photo.formats", &formats); err != nil {
+				return pc.PhotoFormat{}, ErrTransform{fmt.Errorf("Ошибка чтения каталога фотопечати: %s", err.Error())}
+			}
+		}
+		c.formats = formats
+		c.loaded = time.Now()
+	}
+	if len(c.formats) == 0 {
+		f := legacyFormat
+		f.Width, f.Height, f.Paper = width, height, paper
+		return fillFormat(f), nil
+	}
+
+	var anyPaper *pc.PhotoFormat
+	for i, f := range c.formats {
+		if !(f.Width == width && f.Height == height) && !(f.Width == height && f.Height == width) {
+			continue
+		}
+		if f.Paper == paper {
+			return fillFormat(f), nil
+		}
+		if f.Paper == 0 && anyPaper == nil {
+			anyPaper = &c.formats[i]
+		}
+	}
+	if anyPaper != nil {
+		f := *anyPaper
+		f.Paper = paper
+		return fillFormat(f), nil
+	}
+	return pc.PhotoFormat{}, ErrTransform{fmt.Errorf("Формат фотопечати %dx%d бумага %d не найден в каталоге", width, height, paper)}
+}
+
+//fillFormat sets print parameters that are not defined by sku values
+func fillFormat(f pc.PhotoFormat) pc.PhotoFormat {
+	if f.PrintWidth == 0 {
+		f.PrintWidth = f.Width
+	}
+	if f.PrintHeight == 0 {
+		f.PrintHeight = f.Height
+	}
+	if f.PrintPaper == 0 {
+		f.PrintPaper = f.Paper
+	}
+	return f
+}
+
+//checkPhoto validates image file against print size (mm),
+//returns warning if image is not suitable for print and error if file can't be decoded
+func checkPhoto(fsys fs.FS, name string, f pc.PhotoFormat) (warning string, err error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	cfg, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return "", err
+	}
+	if cfg.Width == 0 || cfg.Height == 0 {
+		return "", fmt.Errorf("пустое изображение")
+	}
+
+	warnings := make([]string, 0, 2)
+	if cfg.ColorModel == color.CMYKModel {
+		warnings = append(warnings, "цветовая модель CMYK")
+	}
+	if _, ok := cfg.ColorModel.(color.Palette); ok {
+		warnings = append(warnings, "индексированные цвета")
+	}
+
+	if f.Width > 0 && f.Height > 0 {
+		pxLong, pxShort := float64(cfg.Width), float64(cfg.Height)
+		if pxShort > pxLong {
+			pxLong, pxShort = pxShort, pxLong
+		}
+		mmLong, mmShort := float64(f.Width), float64(f.Height)
+		if mmShort > mmLong {
+			mmLong, mmShort = mmShort, mmLong
+		}
+		if f.MinDPI > 0 {
+			dpi := math.Min(pxLong/mmLong, pxShort/mmShort) * 25.4
+			if dpi < float64(f.MinDPI) {
+				warnings = append(warnings, fmt.Sprintf("низкое разрешение %dx%d (%.0f dpi, минимум %d)", cfg.Width, cfg.Height, dpi, f.MinDPI))
+			}
+		}
+		ratio := (pxLong / pxShort) / (mmLong / mmShort)
+		if math.Abs(ratio-1) > aspectTolerance {
+			warnings = append(warnings, fmt.Sprintf("пропорции %dx%d не соответствуют формату %dx%d", cfg.Width, cfg.Height, f.Width, f.Height))
+		}
+	}
+	return strings.Join(warnings, ", "), nil
+}
+
+//maxWarnings max warnings in one pp comment
+const maxWarnings = 20
+
+//joinWarnings joins warnings to pp comment, cuts long list
+func joinWarnings(warnings []string) string {
+	if len(warnings) <= maxWarnings {
+		return strings.Join(warnings, "; ")
+	}
+	return fmt.Sprintf("%s; и еще %d", strings.Join(warnings[:maxWarnings], "; "), len(warnings)-maxWarnings)
+}
@@ -0,0 +1,129 @@
+package transform
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pc "github.com/egorka-gh/pixlpark/photocycle"
+)
+
+type formatsRepository struct {
+	pc.Repository
+	formats []pc.PhotoFormat
+	err     error
+}
+
+func (r *formatsRepository) LoadPhotoFormats(ctx context.Context) ([]pc.PhotoFormat, error) {
+	return r.formats, r.err
+}
+
+func Test_photoCatalog_format(t *testing.T) {
+	rep := &formatsRepository{formats: []pc.PhotoFormat{
+		{Width: 102, Height: 152, Paper: 0, Cutting: 20, Frame: 15, NBCutting: 19},
+		{Width: 102, Height: 152, Paper: 2, PrintPaper: 12, Cutting: 21, Frame: 16, NBCutting: 22, MinDPI: 200},
+	}}
+	tests := []struct {
+		name                 string
+		width, height, paper int
+		wantPaper            int
+		wantCutting          int
+		wantErr              bool
+	}{
+		{"exact paper", 102, 152, 2, 12, 21, false},
+		{"any paper", 102, 152, 5, 5, 20, false},
+		{"rotated", 152, 102, 5, 5, 20, false},
+		{"unknown", 100, 100, 2, 0, 0, true},
+	}
+	c := &photoCatalog{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.format(context.Background(), rep, tt.width, tt.height, tt.paper)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("format() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if _, ok := err.(ErrTransform); !ok {
+					t.Errorf("format() error type = %T, want ErrTransform", err)
+				}
+				return
+			}
+			if got.PrintPaper != tt.wantPaper || got.Cutting != tt.wantCutting || got.PrintWidth == 0 || got.PrintHeight == 0 {
+				t.Errorf("format() = %+v", got)
+			}
+		})
+	}
+
+	//empty catalogue keeps legacy params
+	c = &photoCatalog{}
+	got, err := c.format(context.Background(), &formatsRepository{err: errors.New("no table")}, 100, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Cutting != 20 || got.Frame != 15 || got.NBCutting != 19 || got.PrintPaper != 2 || got.PrintWidth != 100 {
+		t.Errorf("legacy format() = %+v", got)
+	}
+}
+
+func Test_checkPhoto(t *testing.T) {
+	dir := t.TempDir()
+	writeJPEG := func(name string, w, h int) string {
+		fn := filepath.Join(dir, name)
+		f, err := os.Create(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err = jpeg.Encode(f, image.NewRGBA(image.Rect(0, 0, w, h)), nil); err != nil {
+			t.Fatal(err)
+		}
+		return fn
+	}
+	paletted := filepath.Join(dir, "paletted.png")
+	f, err := os.Create(paletted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = png.Encode(f, image.NewPaletted(image.Rect(0, 0, 1200, 1800), color.Palette{color.White, color.Black}))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := filepath.Join(dir, "broken.jpg")
+	if err = os.WriteFile(broken, []byte("not an image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	format := pc.PhotoFormat{Width: 102, Height: 152, MinDPI: 200}
+	tests := []struct {
+		name    string
+		file    string
+		warning string
+		wantErr bool
+	}{
+		{"ok", writeJPEG("ok.jpg", 1200, 1800), "", false},
+		{"ok rotated", writeJPEG("rotated.jpg", 1800, 1200), "", false},
+		{"low dpi", writeJPEG("low.jpg", 400, 600), "низкое разрешение", false},
+		{"aspect", writeJPEG("aspect.jpg", 1800, 1800), "пропорции", false},
+		{"paletted", paletted, "индексированные цвета", false},
+		{"broken", broken, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := checkPhoto(os.DirFS(dir), filepath.Base(tt.file), format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkPhoto() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.warning == "" && got != "" || !strings.Contains(got, tt.warning) {
+				t.Errorf("checkPhoto() = %q, want %q", got, tt.warning)
+			}
+		})
+	}
+}
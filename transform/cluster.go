@@ -0,0 +1,118 @@
+package transform
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pc "github.com/egorka-gh/pixlpark/photocycle"
+)
+
+//Cluster order claims settings, several pixel services may share one source and cycle database (failover)
+//order is claimed in cycle database before processing, claim lease is renewed by heartbeat while transform runs
+//claim of crashed service expires and is taken over by other service
+type Cluster struct {
+	//Owner service instance id, has to be unique in cluster
+	Owner string
+	//Lease claim lease, heartbeat renews claim every Lease/3, 0 - claims disabled (single service)
+	Lease time.Duration
+}
+
+func (c Cluster) enabled() bool {
+	return c.Lease > 0 && c.Owner != ""
+}
+
+//SetCluster sets order claims settings
+func (fc *baseFactory) SetCluster(c Cluster) {
+	fc.cluster = c
+}
+
+//claim takes cluster claim of cycle base order id and starts claim heartbeat
+//returns false if order is claimed by other service
+func (fc *baseFactory) claim(t *Transform, id string) (bool, error) {
+	if !fc.cluster.enabled() {
+		return true, nil
+	}
+	ok, err := fc.pcClient.ClaimOrder(t.ctx, id, fc.cluster.Owner, fc.cluster.Lease)
+	if err != nil {
+		return false, ErrRepository{err}
+	}
+	if !ok {
+		//skip is repeated on every queue run, so it goes to service log only
+		fc.logger.Log("order", id, "claim", "skip", "owner", fc.cluster.Owner)
+		return false, nil
+	}
+	t.claim = id
+	t.claimStop = make(chan struct{})
+	go fc.heartbeat(t, id, t.claimStop)
+	return true, nil
+}
+
+//logClaim logs claim to cycle order log, order has to be created in cycle
+func (fc *baseFactory) logClaim(t *Transform) {
+	if t.claim == "" {
+		return
+	}
+	_ = fc.pcClient.LogState(t.ctx, t.claim, pc.StateLoadLock, fmt.Sprintf("Заказ заблокирован %s", fc.cluster.Owner))
+}
+
+//claimFetched claims order fetched from source queue and rechecks its state in source
+//(queue may be stale, order could be processed by other service)
+//returns false if order is claimed by other service or its state is changed
+func (fc *baseFactory) claimFetched(t *Transform, id, ppID string) (bool, error) {
+	ok, err := fc.claim(t, id)
+	if err != nil || !ok || !fc.cluster.enabled() {
+		return ok, err
+	}
+	po, err := fc.src.GetOrder(t.ctx, ppID)
+	if err != nil {
+		fc.unclaim(t)
+		return false, ErrService{err}
+	}
+	if OrderStatus(po.Status) != t.fetchState {
+		fc.unclaim(t)
+		return false, nil
+	}
+	return true, nil
+}
+
+//heartbeat renews claim till stop or transform done
+//if claim is lost (expired and taken over by other service) transform is canceled
+func (fc *baseFactory) heartbeat(t *Transform, id string, stop <-chan struct{}) {
+	tk := time.NewTicker(fc.cluster.Lease / 3)
+	defer tk.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.Done:
+			return
+		case <-tk.C:
+		}
+		ok, err := fc.pcClient.RenewClaim(t.ctx, id, fc.cluster.Owner, fc.cluster.Lease)
+		if err != nil {
+			//keep working, claim is valid till lease expires
+			fc.logger.Log("order", id, "claim", "renew", "error", err.Error())
+			continue
+		}
+		if !ok {
+			fc.logger.Log("order", id, "claim", "lost")
+			_ = fc.pcClient.LogState(context.Background(), id, pc.StateErrLocked, "Блокировка заказа перехвачена другим процессом, подготовка прервана")
+			t.cancel()
+			return
+		}
+	}
+}
+
+//unclaim stops claim heartbeat and releases claim
+func (fc *baseFactory) unclaim(t *Transform) {
+	if t.claim == "" {
+		return
+	}
+	close(t.claimStop)
+	//transform context may be canceled
+	if err := fc.pcClient.ReleaseClaim(context.Background(), t.claim, fc.cluster.Owner); err != nil {
+		t.logger.Log("claim", "release", "error", err.Error())
+	}
+	t.claim, t.claimStop = "", nil
+}
@@ -0,0 +1,255 @@
+package transform
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+
+	pc "github.com/egorka-gh/pixlpark/photocycle"
+	pp "github.com/egorka-gh/pixlpark/pixlpark/service"
+)
+
+//claimRepository cycle database claims shared by several factories
+type claimRepository struct {
+	pc.Repository
+	mu     sync.Mutex
+	claims map[string]testClaim
+	states []int
+}
+
+type testClaim struct {
+	owner   string
+	expires time.Time
+}
+
+func (r *claimRepository) ClaimOrder(ctx context.Context, id, owner string, lease time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.claims[id]; ok && c.owner != owner && c.expires.After(time.Now()) {
+		return false, nil
+	}
+	r.claims[id] = testClaim{owner: owner, expires: time.Now().Add(lease)}
+	return true, nil
+}
+
+func (r *claimRepository) RenewClaim(ctx context.Context, id, owner string, lease time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.claims[id]; !ok || c.owner != owner {
+		return false, nil
+	}
+	r.claims[id] = testClaim{owner: owner, expires: time.Now().Add(lease)}
+	return true, nil
+}
+
+func (r *claimRepository) ReleaseClaim(ctx context.Context, id, owner string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.claims[id]; ok && c.owner == owner {
+		delete(r.claims, id)
+	}
+	return nil
+}
+
+func (r *claimRepository) LogState(ctx context.Context, id string, state int, message string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.states = append(r.states, state)
+	return nil
+}
+
+func (r *claimRepository) hasState(state int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+func newClaimTransform() *Transform {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Transform{fetchState: statePixelStartLoad, Done: make(chan struct{}), ctx: ctx, cancel: cancel, logger: log.NewNopLogger()}
+}
+
+func newClusterFactory(src OrderSource, rep pc.Repository, owner string, lease time.Duration) *baseFactory {
+	fc := NewSourceFactory(src, rep, 23, 0, "", "", "", "", nil).(*baseFactory)
+	fc.SetCluster(Cluster{Owner: owner, Lease: lease})
+	return fc
+}
+
+func Test_claimParallel(t *testing.T) {
+	src := &testSource{}
+	for i := 1; i <= 20; i++ {
+		src.orders = append(src.orders, pp.Order{ID: fmt.Sprintf("%d", i), Status: string(statePixelStartLoad)})
+	}
+	//order 20 is processed by other service
+	src.orders[19].Status = string(StatusPrinting)
+	rep := &claimRepository{claims: make(map[string]testClaim)}
+
+	var mu sync.Mutex
+	winners := make(map[string][]*Transform)
+	var wg sync.WaitGroup
+	for f := 0; f < 4; f++ {
+		fc := newClusterFactory(src, rep, fmt.Sprintf("pixel-%d", f), time.Minute)
+		wg.Add(1)
+		go func(fc *baseFactory) {
+			defer wg.Done()
+			for _, o := range src.orders {
+				tr := newClaimTransform()
+				ok, err := fc.claimFetched(tr, "23_"+o.ID+"@", o.ID)
+				if err != nil {
+					t.Errorf("claimFetched(%s) error %v", o.ID, err)
+				}
+				if ok {
+					mu.Lock()
+					winners[o.ID] = append(winners[o.ID], tr)
+					mu.Unlock()
+				}
+			}
+		}(fc)
+	}
+	wg.Wait()
+
+	for _, o := range src.orders[:19] {
+		if len(winners[o.ID]) != 1 {
+			t.Errorf("order %s claimed %d times, want 1", o.ID, len(winners[o.ID]))
+		}
+	}
+	if len(winners["20"]) != 0 || len(rep.claims) != 19 {
+		t.Errorf("order in wrong state is claimed, claims %d", len(rep.claims))
+	}
+	//skips go to service log only, claim is logged when order is created in cycle
+	if rep.hasState(pc.StateErrLocked) || rep.hasState(pc.StateLoadLock) {
+		t.Errorf("claim states are logged to cycle %v", rep.states)
+	}
+	fc0 := newClusterFactory(src, rep, "", time.Minute)
+	fc0.logClaim(winners["1"][0])
+	if !rep.hasState(pc.StateLoadLock) {
+		t.Errorf("claim state is not logged")
+	}
+
+	//closed transforms release claims
+	fc := newClusterFactory(src, rep, "", time.Minute)
+	for _, trs := range winners {
+		for _, tr := range trs {
+			fc.cluster.Owner = rep.claims[tr.claim].owner
+			fc.closeTransform(tr)
+		}
+	}
+	if len(rep.claims) != 0 {
+		t.Errorf("claims are not released %d", len(rep.claims))
+	}
+}
+
+func Test_claimLease(t *testing.T) {
+	src := &testSource{orders: []pp.Order{{ID: "1", Status: string(statePixelStartLoad)}}}
+	rep := &claimRepository{claims: make(map[string]testClaim)}
+	id := "23_1@"
+
+	//claim of crashed service expires
+	rep.ClaimOrder(context.Background(), id, "crashed", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	fc := newClusterFactory(src, rep, "pixel-1", 60*time.Millisecond)
+	tr := newClaimTransform()
+	if ok, err := fc.claimFetched(tr, id, "1"); !ok || err != nil {
+		t.Fatalf("expired claim is not taken over %v", err)
+	}
+
+	//heartbeat keeps claim
+	time.Sleep(100 * time.Millisecond)
+	other := newClusterFactory(src, rep, "pixel-2", 60*time.Millisecond)
+	if ok, _ := other.claimFetched(newClaimTransform(), id, "1"); ok {
+		t.Error("renewed claim is taken over")
+	}
+
+	//stolen claim cancels transform
+	rep.mu.Lock()
+	rep.claims[id] = testClaim{owner: "thief", expires: time.Now().Add(time.Minute)}
+	rep.mu.Unlock()
+	select {
+	case <-tr.ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("transform is not canceled on lost claim")
+	}
+	fc.closeTransform(tr)
+	if rep.claims[id].owner != "thief" {
+		t.Error("lost claim is released")
+	}
+}
+
+//transformRepository cycle orders vs claims
+type transformRepository struct {
+	*claimRepository
+	orders map[string]pc.Order
+}
+
+func (r *transformRepository) LoadBaseOrderByState(ctx context.Context, source, state int) (pc.Order, error) {
+	ids := make([]string, 0, len(r.orders))
+	for id, o := range r.orders {
+		if o.State == state {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return pc.Order{}, sql.ErrNoRows
+	}
+	sort.Strings(ids)
+	return r.orders[ids[0]], nil
+}
+
+func (r *transformRepository) LoadOrder(ctx context.Context, id string) (pc.Order, error) {
+	if o, ok := r.orders[id]; ok {
+		return o, nil
+	}
+	return pc.Order{}, sql.ErrNoRows
+}
+
+func (r *transformRepository) SetOrderState(ctx context.Context, id string, state int) error {
+	o := r.orders[id]
+	o.State = state
+	r.orders[id] = o
+	return nil
+}
+
+func Test_claimFetchToTransform(t *testing.T) {
+	src := &testSource{orders: []pp.Order{
+		//wrong state in PP, skipped
+		{ID: "1", Status: pp.StatePrinting},
+		{ID: "2", Status: string(statePixelLoadStarted)},
+	}}
+	rep := &transformRepository{
+		claimRepository: &claimRepository{claims: make(map[string]testClaim)},
+		orders: map[string]pc.Order{
+			"23_1@": {ID: "23_1@", SourceID: "1", State: pc.StateUnzip},
+			"23_2@": {ID: "23_2@", SourceID: "2", State: pc.StateUnzip},
+		},
+	}
+	fc := newClusterFactory(src, rep, "pixel-1", time.Minute)
+	tr := newClaimTransform()
+	tr.Start = time.Now()
+
+	fc.run(tr, fc.fetchToTransform)
+	if tr.IsComplete() {
+		t.Fatalf("fetchToTransform() error %v", tr.Err())
+	}
+	if tr.pcBaseOrder.ID != "23_2@" || tr.claim != "23_2@" {
+		t.Errorf("fetched %s (claim %s), want 23_2@", tr.pcBaseOrder.ID, tr.claim)
+	}
+	//skipped order claim is released
+	if _, ok := rep.claims["23_1@"]; ok || len(rep.claims) != 1 {
+		t.Errorf("skipped order claim is not released, claims %v", rep.claims)
+	}
+	fc.closeTransform(tr)
+	if len(rep.claims) != 0 {
+		t.Errorf("claims are not released %v", rep.claims)
+	}
+}
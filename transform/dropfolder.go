@@ -0,0 +1,318 @@
+package transform
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pp "github.com/egorka-gh/pixlpark/pixlpark/service"
+	log "github.com/go-kit/kit/log"
+)
+
+//DropFolder OrderSource over local folder, operators drop order zip <id>.zip plus json manifest <id>.json
+//(manifest has to be written after zip, it's a signal that order is ready)
+//order id has to be numeric, zip layout is the same as pixlpark zip (root folder vs subfolder per item)
+//order state and notes are kept in <id>.state.json
+//finished orders (printing, cancelled, defect) are moved to done subfolder
+type DropFolder struct {
+	folder     string
+	production int
+	logger     log.Logger
+	mu         sync.Mutex
+}
+
+//DropManifest drop folder order description
+type DropManifest struct {
+	Order pp.Order       `json:"order"`
+	Items []pp.OrderItem `json:"items"`
+}
+
+//dropState drop folder order state
+type dropState struct {
+	Status OrderStatus `json:"status"`
+	Notes  []dropNote  `json:"notes,omitempty"`
+}
+
+type dropNote struct {
+	Time time.Time `json:"time"`
+	User string    `json:"user,omitempty"`
+	Note string    `json:"note"`
+}
+
+//NewDropFolder creates drop folder OrderSource, production - production of orders vs not set production
+func NewDropFolder(folder string, production int, logger log.Logger) *DropFolder {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	return &DropFolder{folder: folder, production: production, logger: logger}
+}
+
+//dropDone subfolder of finished orders
+const dropDone = "done"
+
+func (d *DropFolder) name(id, ext string) string {
+	return filepath.Join(d.folder, id+ext)
+}
+
+//find returns order file path in drop folder or done subfolder, drop folder path if file not exists
+func (d *DropFolder) find(id, ext string) string {
+	done := filepath.Join(d.folder, dropDone, id+ext)
+	if _, err := os.Stat(d.name(id, ext)); err != nil {
+		if _, err = os.Stat(done); err == nil {
+			return done
+		}
+	}
+	return d.name(id, ext)
+}
+
+//Pending returns orders in state, sorted by date DESC
+//unreadable manifest (broken or not completely written) is logged and skipped, it doesn't block other orders
+//finished orders (done subfolder) are not listed
+func (d *DropFolder) Pending(ctx context.Context, state OrderStatus) ([]pp.Order, error) {
+	fis, err := ioutil.ReadDir(d.folder)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]pp.Order, 0, len(fis))
+	for _, fi := range fis {
+		name := fi.Name()
+		if fi.IsDir() || filepath.Ext(name) != ".json" || strings.HasSuffix(name, ".state.json") {
+			continue
+		}
+		id := strings.TrimSuffix(name, ".json")
+		if _, err := strconv.Atoi(id); err != nil {
+			continue
+		}
+		o, err := d.GetOrder(ctx, id)
+		if err != nil {
+			d.logger.Log("order", id, "manifest", name, "error", err.Error())
+			continue
+		}
+		if OrderStatus(o.Status) == state {
+			res = append(res, o)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return time.Time(res[i].DateCreated).After(time.Time(res[j].DateCreated))
+	})
+	return res, nil
+}
+
+//GetOrder returns order by id
+func (d *DropFolder) GetOrder(ctx context.Context, id string) (pp.Order, error) {
+	m, err := d.manifest(id)
+	if err != nil {
+		return pp.Order{}, err
+	}
+	return m.Order, nil
+}
+
+//GetOrderItems returns order items
+func (d *DropFolder) GetOrderItems(ctx context.Context, id string) ([]pp.OrderItem, error) {
+	m, err := d.manifest(id)
+	if err != nil {
+		return nil, err
+	}
+	return m.Items, nil
+}
+
+//manifest reads order manifest and fills order fields by drop folder (id, state, zip, dates)
+func (d *DropFolder) manifest(id string) (DropManifest, error) {
+	var m DropManifest
+	b, err := ioutil.ReadFile(d.find(id, ".json"))
+	if err != nil {
+		return m, err
+	}
+	if err = json.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("Ошибка чтения манифеста заказа %s: %s", id, err.Error())
+	}
+	o := &m.Order
+	o.ID = id
+	if o.ProductionID == 0 {
+		o.ProductionID = d.production
+	}
+	zip := d.find(id, ".zip")
+	if fi, err := os.Stat(zip); err == nil {
+		o.DownloadLink = zip
+		if time.Time(o.DateCreated).IsZero() {
+			o.DateCreated = pp.Date(fi.ModTime())
+		}
+	}
+	st, err := d.state(id)
+	if err != nil {
+		return m, err
+	}
+	o.Status = string(st.Status)
+	o.CommentsCount = len(st.Notes)
+	if len(st.Notes) > 0 {
+		o.DateModified = pp.Date(st.Notes[len(st.Notes)-1].Time)
+	}
+	return m, nil
+}
+
+//state reads order state, new order (state not saved) is ready to processing
+func (d *DropFolder) state(id string) (dropState, error) {
+	st := dropState{Status: StatusReady}
+	b, err := ioutil.ReadFile(d.find(id, ".state.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return st, nil
+		}
+		return st, err
+	}
+	err = json.Unmarshal(b, &st)
+	return st, err
+}
+
+//update changes order state under lock and saves it
+func (d *DropFolder) update(id string, fn func(st *dropState)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	manifest := d.find(id, ".json")
+	if _, err := os.Stat(manifest); err != nil {
+		return err
+	}
+	st, err := d.state(id)
+	if err != nil {
+		return err
+	}
+	fn(&st)
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(manifest)
+	tmp := filepath.Join(dir, id+".state.json.tmp")
+	if err = ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, filepath.Join(dir, id+".state.json")); err != nil {
+		return err
+	}
+	switch st.Status {
+	case StatusPrinting, StatusCancelled, StatusDefect:
+		if dir == filepath.Clean(d.folder) {
+			d.done(id)
+		}
+	}
+	return nil
+}
+
+//done moves finished order files to done subfolder, manifest is moved last
+//errors are logged, order is moved on next state change
+func (d *DropFolder) done(id string) {
+	doneDir := filepath.Join(d.folder, dropDone)
+	if err := os.MkdirAll(doneDir, 0755); err != nil {
+		d.logger.Log("order", id, "done", doneDir, "error", err.Error())
+		return
+	}
+	for _, ext := range []string{".state.json", ".zip", ".json"} {
+		if err := os.Rename(d.name(id, ext), filepath.Join(doneDir, id+ext)); err != nil && !os.IsNotExist(err) {
+			d.logger.Log("order", id, "done", doneDir, "error", err.Error())
+			return
+		}
+	}
+}
+
+//SetStatus sets order state (notify is ignored)
+func (d *DropFolder) SetStatus(ctx context.Context, id string, state OrderStatus, notify bool) error {
+	return d.update(id, func(st *dropState) {
+		st.Status = state
+	})
+}
+
+//AddNote adds order note
+func (d *DropFolder) AddNote(ctx context.Context, id, user, note string) error {
+	return d.update(id, func(st *dropState) {
+		st.Notes = append(st.Notes, dropNote{Time: time.Now(), User: user, Note: note})
+	})
+}
+
+//Download copies order zip to fileName, blocks till copy is complete
+func (d *DropFolder) Download(ctx context.Context, order pp.Order, fileName string, limit RateLimiter) (Download, error) {
+	res := &localDownload{start: time.Now()}
+	res.err = copyLocal(ctx, order.DownloadLink, fileName, limit, &res.size)
+	res.end = time.Now()
+	return res, nil
+}
+
+//copyLocal copies file, size - copied bytes
+func copyLocal(ctx context.Context, from, to string, limit RateLimiter, size *int64) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	*size, err = io.Copy(dst, ctxReader{ctx: ctx, r: src, limit: limit})
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(to)
+	}
+	return err
+}
+
+//ctxReader reader that stops on context done, optionally limits read speed
+type ctxReader struct {
+	ctx   context.Context
+	r     io.Reader
+	limit RateLimiter
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	if n > 0 && r.limit != nil {
+		if lerr := r.limit.WaitN(r.ctx, n); lerr != nil {
+			return n, lerr
+		}
+	}
+	return n, err
+}
+
+//localDownload completed local copy
+type localDownload struct {
+	start time.Time
+	end   time.Time
+	size  int64
+	err   error
+}
+
+func (l *localDownload) Err() error {
+	return l.err
+}
+
+func (l *localDownload) IsComplete() bool {
+	return true
+}
+
+func (l *localDownload) Cancel() error {
+	return l.err
+}
+
+func (l *localDownload) BytesPerSecond() float64 {
+	if d := l.Duration().Seconds(); d > 0 {
+		return float64(l.size) / d
+	}
+	return 0
+}
+
+func (l *localDownload) Duration() time.Duration {
+	return l.end.Sub(l.start)
+}
@@ -0,0 +1,108 @@
+package transform
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDropFolder(t *testing.T) {
+	dir := t.TempDir()
+	day := 24 * time.Hour
+	touch(t, filepath.Join(dir, "100.zip"), false, 100, 2*day)
+	touch(t, filepath.Join(dir, "101.zip"), false, 50, day)
+	manifest := `{"order":{"Title":"walk-in"},"items":[{"Id":1,"Name":"photo","Quantity":2}]}`
+	for _, name := range []string{"100.json", "101.json", "bad.json"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(manifest), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	d := NewDropFolder(dir, 7, nil)
+
+	orders, err := d.Pending(ctx, statePixelStartLoad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 2 || orders[0].ID != "101" || orders[1].ID != "100" {
+		t.Fatalf("Pending() = %+v, want 101, 100", orders)
+	}
+	o := orders[1]
+	if o.ProductionID != 7 || o.Title != "walk-in" || o.DownloadLink != filepath.Join(dir, "100.zip") {
+		t.Errorf("order = %+v", o)
+	}
+	items, err := d.GetOrderItems(ctx, "100")
+	if err != nil || len(items) != 1 || items[0].Quantity != 2 {
+		t.Errorf("GetOrderItems() = %+v, %v", items, err)
+	}
+
+	if err = d.SetStatus(ctx, "100", statePixelLoadStarted, false); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.AddNote(ctx, "100", "operator", "started"); err != nil {
+		t.Fatal(err)
+	}
+	if orders, _ = d.Pending(ctx, statePixelStartLoad); len(orders) != 1 || orders[0].ID != "101" {
+		t.Errorf("Pending(start) = %+v, want 101", orders)
+	}
+	if o, err = d.GetOrder(ctx, "100"); err != nil || OrderStatus(o.Status) != statePixelLoadStarted || o.CommentsCount != 1 {
+		t.Errorf("GetOrder() = %+v, %v", o, err)
+	}
+	if err = d.SetStatus(ctx, "102", StatusPrinting, false); err == nil {
+		t.Error("SetStatus of missing order: expected error")
+	}
+
+	fl := filepath.Join(t.TempDir(), "100.zip")
+	dl, err := d.Download(ctx, o, fl, nil)
+	if err != nil || dl.Err() != nil || !dl.IsComplete() {
+		t.Fatalf("Download() error %v", err)
+	}
+	if !exists(fl) {
+		t.Error("zip is not copied")
+	}
+
+	//finished order is moved to done
+	if err = d.SetStatus(ctx, "100", StatusPrinting, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"100.zip", "100.json", "100.state.json"} {
+		if exists(filepath.Join(dir, name)) || !exists(filepath.Join(dir, dropDone, name)) {
+			t.Errorf("%s is not moved to done", name)
+		}
+	}
+	if orders, _ = d.Pending(ctx, StatusPrinting); len(orders) != 0 {
+		t.Errorf("Pending(printing) = %+v, want empty", orders)
+	}
+	if o, err = d.GetOrder(ctx, "100"); err != nil || OrderStatus(o.Status) != StatusPrinting || o.DownloadLink != filepath.Join(dir, dropDone, "100.zip") {
+		t.Errorf("GetOrder() of done order = %+v, %v", o, err)
+	}
+	if err = d.AddNote(ctx, "100", "operator", "printed"); err != nil {
+		t.Errorf("AddNote() of done order error %v", err)
+	}
+}
+
+func TestDropFolder_badManifest(t *testing.T) {
+	dir := t.TempDir()
+	touch(t, filepath.Join(dir, "100.zip"), false, 100, time.Hour)
+	touch(t, filepath.Join(dir, "101.zip"), false, 100, time.Hour)
+	manifests := map[string]string{
+		"100.json": `{"order":{"Title":"walk-in"},"items":[{"Id":1,"Name":"photo","Quantity":2}]}`,
+		//half-written
+		"101.json": `{"order":{"Title":"walk`,
+	}
+	for name, m := range manifests {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(m), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := NewDropFolder(dir, 7, nil)
+	orders, err := d.Pending(context.Background(), statePixelStartLoad)
+	if err != nil {
+		t.Fatalf("Pending() error %v, broken manifest must be skipped", err)
+	}
+	if len(orders) != 1 || orders[0].ID != "100" {
+		t.Errorf("Pending() = %+v, want 100", orders)
+	}
+}
@@ -11,14 +11,15 @@ import (
 	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	log "github.com/go-kit/kit/log"
 
-	"github.com/cavaliercoder/grab"
 	pc "github.com/egorka-gh/pixlpark/photocycle"
 	pp "github.com/egorka-gh/pixlpark/pixlpark/service"
+	"github.com/egorka-gh/pixlpark/storage"
 )
 
 // Factory is factory of transform item (Transform)
@@ -28,7 +29,7 @@ type Factory interface {
 	// fetch new order and perfom full trunsform
 	LoadNew(ctx context.Context) *Transform
 	//SoftErrorRestart restarts orders after confirmed soft error
-	//SoftErrorRestart(ctx context.Context) *Transform
+	SoftErrorRestart(ctx context.Context) *Transform
 	//LoadRestart reload orders that allready started (statePixelLoadStarted)
 	LoadRestart(ctx context.Context) *Transform
 	//TransformRestart restart incompleted transforms
@@ -40,18 +41,40 @@ type Factory interface {
 
 	// DoOrder loads order and perfom full trunsform (4 tests only)
 	DoOrder(ctx context.Context, id string) *Transform
+	//Reprint rebuilds sheets or books of published order and creates reprint print groups in cycle
+	Reprint(ctx context.Context, r ReprintRequest) ([]pc.PrintGroup, error)
+	//RetransformItem rebuilds one order item into its cycle order, sibling orders are not touched
+	RetransformItem(ctx context.Context, orderID string) (pc.Order, error)
 
 	//QueueLen returns current queues lenth
 	QueueLen() int
 
 	SetDebug(debug bool)
+	//SetPreflight sets optional image preflight stage (between unzip and transform)
+	SetPreflight(p Preflight)
+	//SetCopyOptions sets files delivery to cycle folders (zip streaming, hard links, parallel copy)
+	SetCopyOptions(o CopyOptions)
+	//SetStorage sets output storage of cycle folders (local by default)
+	SetStorage(s storage.Storage)
+	//SetArchive sets archive of finished order zips (zips are removed if not set)
+	SetArchive(a *Archive)
+	//SetRouting sets production routing rules (pixlpark order/item to cycle production)
+	SetRouting(r Routing)
+	//SetBudget sets shared transforms budget, parallel item workers take free budget slots
+	SetBudget(b *Budget)
+	//SetBandwidth sets zip download speed limiter
+	SetBandwidth(b *Bandwidth)
+	//SetPipeline sets transform stage pools
+	SetPipeline(p *Pipeline)
+	//SetCluster sets order claims settings (several services vs one cycle database)
+	SetCluster(c Cluster)
 }
 
 // Factory is factory of transform item (Transform)
 // creates transform item and defines transform process
 type baseFactory struct {
 	production     int
-	ppClient       pp.PPService
+	src            OrderSource
 	pcClient       pc.Repository
 	source         int
 	wrkFolder      string
@@ -60,10 +83,21 @@ type baseFactory struct {
 	ppUser         string
 	logger         log.Logger
 	Debug          bool
+	catalog        *photoCatalog
+	thickness      *thicknessTable
+	preflightCfg   Preflight
+	copyOpt        CopyOptions
+	out            storage.Storage
+	archive        *Archive
+	routing        Routing
+	budget         *Budget
+	bandwidth      *Bandwidth
+	pipeline       *Pipeline
+	cluster        Cluster
 
 	//current queues
-	mu     sync.Mutex            // guards queues map
-	queues map[string][]pp.Order // PP state -> orders slice
+	mu     sync.Mutex                 // guards queues map
+	queues map[OrderStatus][]pp.Order // source state -> orders slice
 
 }
 
@@ -74,6 +108,11 @@ type ErrEmptyQueue struct {
 	error
 }
 
+//ErrStopped transform is stopped at stage boundary (service shutdown)
+type ErrStopped struct {
+	error
+}
+
 //ErrCantTransform inapplicable transform method
 type ErrCantTransform struct {
 	error
@@ -111,25 +150,36 @@ type ErrService struct {
 
 var (
 	errCantTransform = ErrCantTransform{errors.New("Для продукта не настроены параметры подготовки")}
+	//errProductionNotSet item is not routed to any cycle production
+	errProductionNotSet = ErrTransform{errors.New("Не назначено производство")}
 )
 
-// NewFactory returns a new transform Factory, using provided configuration.
+// NewFactory returns a new transform Factory of pixlpark orders, using provided configuration.
 //TODO refactor to config
 func NewFactory(pixlparkClient pp.PPService, photocycleClient pc.Repository, sourse, production int, workFolder, cycleFolder, cyclePrtFolder, pixlparkUserEmail string, logger log.Logger) Factory {
+	return NewSourceFactory(NewPixlparkSource(pixlparkClient), photocycleClient, sourse, production, workFolder, cycleFolder, cyclePrtFolder, pixlparkUserEmail, logger)
+}
+
+// NewSourceFactory returns a new transform Factory of orders from orderSource, user - user name for order notes
+func NewSourceFactory(orderSource OrderSource, photocycleClient pc.Repository, sourse, production int, workFolder, cycleFolder, cyclePrtFolder, user string, logger log.Logger) Factory {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
 	return &baseFactory{
 		production:     production,
-		ppClient:       pixlparkClient,
+		src:            orderSource,
 		pcClient:       photocycleClient,
 		source:         sourse,
 		wrkFolder:      workFolder,
 		cycleFolder:    cycleFolder,
 		cyclePrtFolder: cyclePrtFolder,
-		ppUser:         pixlparkUserEmail,
+		ppUser:         user,
 		logger:         logger,
-		queues:         make(map[string][]pp.Order),
+		catalog:        &photoCatalog{},
+		thickness:      &thicknessTable{},
+		copyOpt:        CopyOptions{Workers: 1, ItemWorkers: 1},
+		out:            storage.NewLocal(),
+		queues:         make(map[OrderStatus][]pp.Order),
 	}
 }
 
@@ -137,6 +187,66 @@ func (fc *baseFactory) SetDebug(debug bool) {
 	fc.Debug = debug
 }
 
+func (fc *baseFactory) SetStorage(s storage.Storage) {
+	fc.out = s
+}
+
+func (fc *baseFactory) SetBudget(b *Budget) {
+	fc.budget = b
+}
+
+func (fc *baseFactory) SetBandwidth(b *Bandwidth) {
+	fc.bandwidth = b
+}
+
+func (fc *baseFactory) SetPipeline(p *Pipeline) {
+	fc.pipeline = p
+}
+
+//staged runs f in pipeline stage s, blocks till stage slot is free
+//if pipeline is stopped (service shutdown) transform is stopped before stage, restarters resume it later
+func (fc *baseFactory) staged(s Stage, f stateFunc) stateFunc {
+	return func(t *Transform) stateFunc {
+		if !fc.pipeline.enter(t, s) {
+			if t.err = t.ctx.Err(); t.err == nil {
+				fc.stopped(t, s)
+			}
+			return fc.closeTransform
+		}
+		return f(t)
+	}
+}
+
+//stopped records stage where transform is stopped
+func (fc *baseFactory) stopped(t *Transform, s Stage) {
+	t.err = ErrStopped{fmt.Errorf("Подготовка остановлена перед этапом %s (остановка сервиса)", s)}
+	t.logger.Log("stopped", s.String())
+	if t.pcBaseOrder.ID == "" {
+		//not fetched
+		return
+	}
+	state := pc.StateLoad
+	switch s {
+	case StageExtract:
+		state = pc.StateUnzip
+	case StageTransform:
+		state = pc.StateTransform
+	}
+	_ = fc.setCycleState(t, 0, state, t.err.Error())
+}
+
+//rateLimiter returns download limiter, nil if not set
+func (fc *baseFactory) rateLimiter() RateLimiter {
+	if fc.bandwidth == nil {
+		return nil
+	}
+	return fc.bandwidth
+}
+
+func (fc *baseFactory) SetArchive(a *Archive) {
+	fc.archive = a
+}
+
 // LoadNew main sequence
 // fetch new order and perfom full trunsform
 //
@@ -165,7 +275,7 @@ func (fc *baseFactory) LoadNew(ctx context.Context) *Transform {
 	t.logger.Log("event", "start")
 
 	// Run state-machine while caller is blocked to fetch pixelpark order and to initialize transform.
-	fc.run(t, fc.fetchToLoad)
+	fc.run(t, fc.staged(StageDownload, fc.fetchToLoad))
 	if t.IsComplete() {
 		if t.Err() != nil {
 			t.logger.Log("event", "end", "error", t.Err().Error())
@@ -182,7 +292,7 @@ func (fc *baseFactory) LoadNew(ctx context.Context) *Transform {
 
 //LoadRestart reload orders that allready started (statePixelLoadStarted)
 // but not complete for some reason (service stop, some error while load, unzip)
-// also restarts orders after soft error statePixelWaiteConfirm, incomplited transform confirmed by user
+// orders after confirmed soft error are restarted by SoftErrorRestart
 // behavior same as LoadNew exept fetch orders in state statePixelLoadStarted
 // get ordrers vs statePixelLoadStarted in PP && StateLoadWaite in Cycle
 func (fc *baseFactory) LoadRestart(ctx context.Context) *Transform {
@@ -201,7 +311,7 @@ func (fc *baseFactory) LoadRestart(ctx context.Context) *Transform {
 
 	// Run state-machine while caller is blocked to fetch pixelpark order and to initialize transform.
 	t.logger.Log("event", "start")
-	fc.run(t, fc.fetchToLoad)
+	fc.run(t, fc.staged(StageDownload, fc.fetchToLoad))
 	if t.IsComplete() {
 		if t.Err() != nil {
 			t.logger.Log("event", "end", "error", t.Err().Error())
@@ -216,10 +326,49 @@ func (fc *baseFactory) LoadRestart(ctx context.Context) *Transform {
 	return t
 }
 
+//SoftErrorRestart restarts orders after confirmed soft error
+// transform is incomplete (some items failed) and manager confirmed it in PP (statePixelConfirmed)
+// all items are transformed again (cycle orders of incomplete transform are not created, successful items are rebuilt too),
+// items that fail again are dropped and order is published vs successful items only
+// order is kept in statePixelConfirmed in PP till transform is complete, so broken confirmed transform is restarted by SoftErrorRestart
+// (or by TransformRestart if it's broken after unzip)
+// behavior same as LoadNew exept fetch orders method, zip loading is skipped if zip is in work folder
+// get ordrers vs statePixelConfirmed in PP && StatePreprocessIncomplite or StateLoadWaite (broken restart) in Cycle
+func (fc *baseFactory) SoftErrorRestart(ctx context.Context) *Transform {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	t := &Transform{
+		fetchState: statePixelConfirmed,
+		confirmed:  true,
+		Start:      time.Now(),
+		Done:       make(chan struct{}, 0),
+		ctx:        ctx,
+		cancel:     cancel,
+		logger:     log.With(fc.logger, "sequence", "SoftErrorRestart"),
+	}
+
+	t.logger.Log("event", "start")
+	// Run state-machine while caller is blocked to fetch pixelpark order and to initialize transform.
+	fc.run(t, fc.staged(StageDownload, fc.fetchToConfirm))
+	if t.IsComplete() {
+		t.logger.Log("event", "end", "error", t.Err().Error())
+		return t
+	}
+	//Run load or unzip in a new goroutine
+	next := fc.loadZIP
+	if _, err := os.Stat(filepath.Join(fc.wrkFolder, t.ppOrder.ID+".zip")); err == nil {
+		next = fc.staged(StageExtract, fc.unzip)
+	}
+	go fc.run(t, next)
+	return t
+}
+
 //TransformRestart restart incompleted transforms
 // orders that loaded and unziped but not complete for some reason (service stop or some error while transform)
 // behavior same as LoadNew exepct fetch orders method
-// get ordrers vs statePixelLoadStarted in PP && StateUnzip in Cycle
+// get ordrers vs statePixelLoadStarted (or statePixelConfirmed, see SoftErrorRestart) in PP && StateUnzip in Cycle
 func (fc *baseFactory) TransformRestart(ctx context.Context) *Transform {
 	if ctx == nil {
 		ctx = context.Background()
@@ -236,13 +385,13 @@ func (fc *baseFactory) TransformRestart(ctx context.Context) *Transform {
 
 	t.logger.Log("event", "start")
 	// Run state-machine while caller is blocked to fetch pixelpark order and to initialize transform.
-	fc.run(t, fc.fetchToTransform)
+	fc.run(t, fc.staged(StageExtract, fc.fetchToTransform))
 	if t.IsComplete() {
 		t.logger.Log("event", "end", "error", t.Err().Error())
 		return t
 	}
-	//Run transform in a new goroutine
-	go fc.run(t, fc.transformItems)
+	//Run transform in a new goroutine, preflight is idempotent so restart it too
+	go fc.run(t, fc.preflight)
 	return t
 }
 
@@ -292,7 +441,7 @@ func (fc *baseFactory) SyncCycle(ctx context.Context) *Transform {
 	}
 	ctx, cancel := context.WithCancel(ctx)
 	t := &Transform{
-		fetchState: pp.StateCancelled,
+		fetchState: StatusCancelled,
 		Start:      time.Now(),
 		Done:       make(chan struct{}, 0),
 		ctx:        ctx,
@@ -380,13 +529,8 @@ func (fc *baseFactory) fetchToLoad(t *Transform) stateFunc {
 		co := fromPPOrder(&po, fc.source, "@")
 		co.State = pc.StateLoadWaite
 
-		//check production
-		if fc.production > 0 && po.ProductionID != fc.production {
-			logger.Log("skip", "production")
-			//log if it created ??
-			//fc.pcClient.LogState(t.ctx, co.ID, pc.StateErrProductionNotSet, "")
-			continue
-		}
+		//production is checked by queue (see routeQueue)
+		co.Production = fc.route(&po, nil)
 
 		//check download link
 		if po.DownloadLink == "" {
@@ -394,18 +538,31 @@ func (fc *baseFactory) fetchToLoad(t *Transform) stateFunc {
 			continue
 		}
 
+		//claim order (other service may process it)
+		var ok bool
+		if ok, err = fc.claimFetched(t, co.ID, po.ID); err != nil {
+			break
+		}
+		if !ok {
+			logger.Log("skip", "claimed by other process")
+			continue
+		}
+
 		//check states in cycle
 
 		if inerErr := fc.checkCreateInCycle(t, co); inerErr != nil {
 			logger.Log("skip", inerErr.Error())
+			fc.unclaim(t)
 			continue
 		}
+		fc.logClaim(t)
 		//move state in PP to statePixelLoadStarted
 		if t.fetchState != statePixelLoadStarted {
 			if inerErr := fc.setPixelState(t, statePixelLoadStarted, ""); inerErr != nil {
 				//keep fetching
 				logger.Log("skip", inerErr.Error())
 				//inerErr = ErrService{inerErr}
+				fc.unclaim(t)
 				continue
 			}
 		}
@@ -421,7 +578,79 @@ func (fc *baseFactory) fetchToLoad(t *Transform) stateFunc {
 	return fc.closeTransform
 }
 
-func (fc *baseFactory) queuePop(ctx context.Context, state string) (pp.Order, error) {
+//fetchToConfirm looks for in PP the next order confirmed after soft error
+//on success t is not closed (valid for processing)
+//if there is no orders returns ErrEmptyQueue
+//fetch in PP state statePixelConfirmed, cycle base order must be in StatePreprocessIncomplite or StateLoadWaite (broken confirmed transform)
+//fetched order is kept in statePixelConfirmed in PP (confirmation survives broken transform) and moves to StateLoadWaite in cycle
+func (fc *baseFactory) fetchToConfirm(t *Transform) stateFunc {
+	var err error
+	for err == nil {
+		var po pp.Order
+		if po, err = fc.queuePop(t.ctx, t.fetchState); err != nil {
+			break
+		}
+		logger := log.With(t.logger, "order", po.ID)
+		logger.Log("fetch", "start")
+		if t.ppOrder.ID == po.ID {
+			//cycled pop???
+			err = fmt.Errorf("cycled fetch %s", po.ID)
+			break
+		}
+		t.ppOrder = po
+
+		//claim order (other service may process it)
+		co := fromPPOrder(&po, fc.source, "@")
+		var ok bool
+		if ok, err = fc.claimFetched(t, co.ID, po.ID); err != nil {
+			break
+		}
+		if !ok {
+			logger.Log("skip", "claimed by other process")
+			continue
+		}
+
+		//check state in cycle
+		base, inerErr := fc.pcClient.LoadOrder(t.ctx, co.ID)
+		if inerErr != nil {
+			fc.unclaim(t)
+			if inerErr != sql.ErrNoRows {
+				err = ErrRepository{inerErr}
+				break
+			}
+			logger.Log("skip", "not found in cycle")
+			continue
+		}
+		if base.State != pc.StatePreprocessIncomplite && base.State != pc.StateLoadWaite {
+			logger.Log("skip", fmt.Sprintf("wrong state in cycle %d, expected %d", base.State, pc.StatePreprocessIncomplite))
+			fc.unclaim(t)
+			continue
+		}
+		t.pcBaseOrder = base
+		fc.logClaim(t)
+
+		//PP state is not changed (statePixelConfirmed)
+		_ = fc.setCycleState(t, pc.StateLoadWaite, pc.StateLoadWaite, "Подтверждено продолжение подготовки")
+		//next t logs will go vs order id
+		t.logger = logger
+		logger.Log("fetch", "complite")
+		return nil
+	}
+
+	t.err = err
+	return fc.closeTransform
+}
+
+//reloadState returns PP state to reload order from scratch
+//confirmed soft error keeps statePixelConfirmed (reloaded by SoftErrorRestart)
+func reloadState(t *Transform) OrderStatus {
+	if t.confirmed {
+		return statePixelConfirmed
+	}
+	return statePixelStartLoad
+}
+
+func (fc *baseFactory) queuePop(ctx context.Context, state OrderStatus) (pp.Order, error) {
 	var order pp.Order
 	var err error
 	fc.mu.Lock()
@@ -429,27 +658,23 @@ func (fc *baseFactory) queuePop(ctx context.Context, state string) (pp.Order, er
 	//get queue slice
 	queue := fc.queues[state]
 	if queue == nil {
-		//get orders count by state
-		cnt := 0
-		cnt, err = fc.ppClient.CountOrders(ctx, []string{state})
+		//get all orders
+		queue, err = fc.src.Pending(ctx, state)
 		if err != nil {
 			return order, ErrService{err}
 		}
-		if cnt == 0 {
+		//exclude orders of other productions (not filtered by source, see routeQueue)
+		queue = fc.routeQueue(queue)
+		if len(queue) == 0 {
 			return order, ErrEmptyQueue{fmt.Errorf("No orders in state %s", state)}
 		}
-		//get all orders
-		queue, err = fc.ppClient.GetOrders(ctx, state, 0, 0, cnt, 0)
-		if err != nil {
-			return order, ErrService{err}
-		}
 	}
 	//check if empty
 	if len(queue) == 0 {
 		fc.queues[state] = nil
 		return order, ErrEmptyQueue{fmt.Errorf("No orders in state %s", state)}
 	}
-	//pop last (first by date, GetOrders returns orders sorted by date DESC)
+	//pop last (first by date, Pending returns orders sorted by date DESC)
 	if len(queue) == 1 {
 		//next pop will be empty
 		order = queue[0]
@@ -482,6 +707,33 @@ func (fc *baseFactory) fetchToTransform(t *Transform) stateFunc {
 	logger := log.With(t.logger, "order", t.pcBaseOrder.GroupID)
 	logger.Log("event", "start")
 
+	//claim order (other service may process it)
+	ok, err := fc.claim(t, t.pcBaseOrder.ID)
+	if err != nil {
+		t.err = err
+		logger.Log("error", err.Error())
+		return fc.closeTransform
+	}
+	if !ok {
+		//other service restarts orders
+		t.err = ErrEmptyQueue{fmt.Errorf("Order %s is claimed by other process", t.pcBaseOrder.ID)}
+		return fc.closeTransform
+	}
+	if fc.cluster.enabled() {
+		//recheck state, order could be fetched by other service
+		base, err := fc.pcClient.LoadOrder(t.ctx, t.pcBaseOrder.ID)
+		if err != nil {
+			t.err = ErrRepository{err}
+			logger.Log("error", err.Error())
+			return fc.closeTransform
+		}
+		if base.State != pc.StateUnzip {
+			fc.unclaim(t)
+			return fc.fetchToTransform
+		}
+	}
+	fc.logClaim(t)
+
 	//change state in cycle, to prevent cycled fetch
 	//if some err - should be reloaded vs LoadRestart
 	err = fc.setCycleState(t, pc.StateLoadWaite, 0, "")
@@ -492,14 +744,15 @@ func (fc *baseFactory) fetchToTransform(t *Transform) stateFunc {
 	}
 
 	//load from PP
-	t.ppOrder, err = fc.ppClient.GetOrder(t.ctx, t.pcBaseOrder.SourceID)
+	t.ppOrder, err = fc.src.GetOrder(t.ctx, t.pcBaseOrder.SourceID)
 	if err != nil {
 		t.err = ErrService{err}
 		logger.Log("error", err.Error())
 		return fc.closeTransform
 	}
-	//check PP state
-	if t.ppOrder.Status != statePixelLoadStarted {
+	//check PP state, confirmed soft error is kept in statePixelConfirmed (see SoftErrorRestart)
+	t.confirmed = OrderStatus(t.ppOrder.Status) == statePixelConfirmed
+	if OrderStatus(t.ppOrder.Status) != statePixelLoadStarted && !t.confirmed {
 		//wrong state in PP
 		//TODO reset in cycle ??
 		msg := fmt.Sprintf("Wrong state '%s' in source site, expected '%s'", t.ppOrder.Status, statePixelLoadStarted)
@@ -510,7 +763,8 @@ func (fc *baseFactory) fetchToTransform(t *Transform) stateFunc {
 			logger.Log("error", err.Error())
 			return fc.closeTransform
 		}
-		// try next one
+		// try next one, release claim (next claim replaces transform claim)
+		fc.unclaim(t)
 		return fc.fetchToTransform
 	}
 
@@ -546,17 +800,17 @@ func (fc *baseFactory) doFinalize(t *Transform) stateFunc {
 		logger.Log("event", "start")
 
 		//load from PP
-		t.ppOrder, err = fc.ppClient.GetOrder(t.ctx, t.pcBaseOrder.SourceID)
+		t.ppOrder, err = fc.src.GetOrder(t.ctx, t.pcBaseOrder.SourceID)
 		if err != nil {
 			t.err = ErrService{err}
 			logger.Log("error", err.Error())
 			return fc.closeTransform
 		}
 		//check PP state
-		if t.ppOrder.Status != pp.StatePrinting {
+		if OrderStatus(t.ppOrder.Status) != StatusPrinting {
 			//wrong state in PP
 			//TODO reset in cycle ??
-			msg := fmt.Sprintf("Wrong state '%s' in source site, expected '%s'", t.ppOrder.Status, pp.StatePrinting)
+			msg := fmt.Sprintf("Wrong state '%s' in source site, expected '%s'", t.ppOrder.Status, StatusPrinting)
 			err = fc.setCycleState(t, pc.StateLoadWaite, pc.StateErrPreprocess, msg)
 			if err != nil {
 				t.err = ErrRepository{err}
@@ -608,7 +862,7 @@ func (fc *baseFactory) doSyncCycle(t *Transform) stateFunc {
 		//logger.Log("event", "start")
 
 		//load from PP
-		ppOrder, err := fc.ppClient.GetOrder(t.ctx, id)
+		ppOrder, err := fc.src.GetOrder(t.ctx, id)
 		if err != nil {
 			t.err = ErrService{err}
 			logger.Log("error", err.Error())
@@ -617,15 +871,15 @@ func (fc *baseFactory) doSyncCycle(t *Transform) stateFunc {
 		//check if canceled
 		pcOrder := fromPPOrder(&ppOrder, fc.source, "@")
 		newState := 0
-		if ppOrder.Status == pp.StateCancelled {
+		if OrderStatus(ppOrder.Status) == StatusCancelled {
 			//cancel in cycle
 			logger.Log("event", "Canceled")
 			fc.pcClient.LogState(t.ctx, pcOrder.ID, pc.StateCanceled, "Canceled by site")
 			newState = pc.StateCanceled
-		} else if ppOrder.Status != pp.StatePrinting {
-			if ppOrder.Status != pp.StatePrepressCoordinationAwaitingReply {
+		} else if OrderStatus(ppOrder.Status) != StatusPrinting {
+			if OrderStatus(ppOrder.Status) != statePixelWaiteConfirm {
 				//don't log in soft error state
-				msg := fmt.Sprintf("Wrong state '%s' in source site, expected '%s'", ppOrder.Status, pp.StatePrinting)
+				msg := fmt.Sprintf("Wrong state '%s' in source site, expected '%s'", ppOrder.Status, StatusPrinting)
 				logger.Log("warning", msg)
 				fc.pcClient.LogState(t.ctx, pcOrder.ID, pc.StateSkiped, msg)
 			}
@@ -656,13 +910,12 @@ func (fc *baseFactory) doSyncCycle(t *Transform) stateFunc {
 	return fc.closeTransform
 }
 
-//start grab client to load zip
+//start order source download to load zip
 //in/out states statePixelLoadStarted in PP and StateLoadWaite in cycle
 func (fc *baseFactory) loadZIP(t *Transform) stateFunc {
 	logger := log.With(t.logger, "stage", "loadZIP")
 	logger.Log("event", "start")
 
-	loader := grab.NewClient()
 	fl := filepath.Join(fc.wrkFolder, t.ppOrder.ID+".zip")
 	//check delete old zip & folder
 	if err := os.Remove(fl); err != nil && !os.IsNotExist(err) {
@@ -680,9 +933,8 @@ func (fc *baseFactory) loadZIP(t *Transform) stateFunc {
 		return fc.closeTransform
 	}
 
-	req, err := grab.NewRequest(fl, t.ppOrder.DownloadLink)
-	//TODO prevent reusing connection
-	req.HTTPRequest.Close = true
+	_ = fc.setCycleState(t, 0, pc.StateLoad, "start")
+	loader, err := fc.src.Download(t.ctx, t.ppOrder, fl, fc.rateLimiter())
 	if err != nil {
 		logger.Log("error", err.Error())
 		t.err = ErrService{err}
@@ -690,11 +942,7 @@ func (fc *baseFactory) loadZIP(t *Transform) stateFunc {
 		_ = fc.setCycleState(t, pc.StateLoadWaite, pc.StateErrWeb, t.err.Error())
 		return fc.closeTransform
 	}
-	req = req.WithContext(t.ctx)
-	req.SkipExisting = true
-	req.NoResume = true
-	_ = fc.setCycleState(t, 0, pc.StateLoad, "start")
-	t.loader = loader.Do(req)
+	t.loader = loader
 	//waite till complete
 	err = t.loader.Err()
 	if err != nil {
@@ -710,7 +958,7 @@ func (fc *baseFactory) loadZIP(t *Transform) stateFunc {
 	_ = fc.setCycleState(t, 0, pc.StateLoadComplite, fmt.Sprintf("zip loaded elapsed=%s; speed=%.2f mb/s", t.loader.Duration().String(), t.loader.BytesPerSecond()/(1024*1024)))
 	//still in LoadWaie in cycle
 	//forvard to unzip
-	return fc.unzip
+	return fc.staged(StageExtract, fc.unzip)
 }
 
 //unpack zip
@@ -733,6 +981,13 @@ func (fc *baseFactory) unzip(t *Transform) stateFunc {
 		return fc.closeTransform
 	}
 	defer reader.Close()
+	if fc.streamZip() {
+		//zip central directory is readable, files will be streamed from zip while transform
+		msg := fmt.Sprintf("streaming zip files=%d; time=%s", len(reader.File), time.Since(started).String())
+		_ = fc.setCycleState(t, pc.StateUnzip, pc.StateUnzip, msg)
+		logger.Log("event", "end", "mode", "stream", "files", len(reader.File), "time", time.Since(started).String())
+		return fc.preflight
+	}
 	basePath := path.Join(fc.wrkFolder, t.ppOrder.ID)
 	if err = os.MkdirAll(basePath, 0755); err != nil {
 		logger.Log("error", err.Error())
@@ -809,8 +1064,8 @@ func (fc *baseFactory) unzip(t *Transform) stateFunc {
 	//move to StateUnzip in cycle (to resume from transform)
 	_ = fc.setCycleState(t, pc.StateUnzip, pc.StateUnzip, fmt.Sprintf("complete time=%s", time.Since(started).String()))
 	logger.Log("event", "end", "time", time.Since(started).String())
-	//forvard to transform
-	return fc.transformItems
+	//forvard to preflight (transform if preflight disabled)
+	return fc.preflight
 }
 
 //transformItems transforms orderitems to cycle orders
@@ -820,8 +1075,9 @@ func (fc *baseFactory) unzip(t *Transform) stateFunc {
 //		pp - statePixelStartLoad; cycle - StateLoadWaite
 // if empty order (no items) hard error lock for processing
 //		pp - statePixelAbort; cycle - StateSkiped
-// if not all items processed move to soft stop, to proceed set statePixelConfirmed in pp (not implemented)
+// if not all items processed move to soft stop, to proceed set statePixelConfirmed in pp (see SoftErrorRestart)
 //		pp - statePixelWaiteConfirm; cycle - StatePreprocessIncomplite
+// if not all items processed in confirmed transform, failed items are dropped and order is published vs successful items
 // if error while creating orders in cycle (ErrRepository) or
 // if error while set in PP StatePrinting
 //	left as is, transform will restart late (not implemented)
@@ -835,7 +1091,7 @@ func (fc *baseFactory) transformItems(t *Transform) stateFunc {
 	logger.Log("event", "start")
 	_ = fc.setCycleState(t, 0, pc.StateTransform, "start")
 
-	items, err := fc.ppClient.GetOrderItems(t.ctx, t.ppOrder.ID)
+	items, err := fc.src.GetOrderItems(t.ctx, t.ppOrder.ID)
 	if err != nil {
 		//TODO restart?
 		t.err = ErrService{err}
@@ -855,102 +1111,219 @@ func (fc *baseFactory) transformItems(t *Transform) stateFunc {
 		return fc.closeTransform
 	}
 
+	//open unziped folder or zip (streaming)
+	src, err := fc.openSource(t.ppOrder.ID)
+	if err != nil {
+		//reset to reload & close transform
+		t.err = err
+		msg := fmt.Sprintf("Перезапуск загрузки. Ошибка %s", err.Error())
+		logger.Log("error", msg)
+		fc.setPixelState(t, reloadState(t), msg)
+		fc.setCycleState(t, pc.StateLoadWaite, pc.StateErrPreprocess, msg)
+		return fc.closeTransform
+	}
+	defer src.Close()
+
 	//TODO check if cycle allready print suborder??
 	//process items
 	orders := make([]pc.Order, 0, len(items))
 	incomlete := false
+	var dropped []string
+	//transform items concurrently, results are handled in items order
+	results := fc.transformAll(t.ctx, src, &t.ppOrder, items, logger)
 	for i, item := range items {
-		//process item
-		l := log.With(logger, "item", item.ID)
-		l.Log("transform", "start")
-		//create cycle order
-		co := fromPPOrder(&t.ppOrder, fc.source, fmt.Sprintf("-%d", i))
-		co.SourceID = fmt.Sprintf("%s-%d", t.ppOrder.ID, item.ID)
-
-		isPhoto := false
-		//try build by alias
-		//intermediate state for buld by alias (then forward to StatePreprocessWaite)
-		co.State = pc.StateLoadComplite
-		err = fc.transformAlias(t.ctx, &item, &co)
-		if _, ok := err.(ErrCantTransform); ok == true {
-			//try build photo print
-			//intermediate state for buld photo (then forward to StatePrintWaite)
-			isPhoto = true
-			//state can be forwarded
-			if co.State < pc.StatePreprocessComplite {
-				co.State = pc.StatePreprocessComplite
+		if _, ok := results[i].err.(ErrSourceNotFound); ok == true {
+			//unziped folder deleted??
+			//reset to reload & close transform
+			co, err := results[i].order, results[i].err
+			t.err = err
+			msg := fmt.Sprintf("Перезапуск загрузки. Элемент заказа %s '%s'. Ошибка %s", co.SourceID, item.Name, err.Error())
+			if fc.Debug {
+				fmt.Printf("Элемент заказа %s '%s'. Ошибка %s\n", co.SourceID, item.Name, err.Error())
 			}
-			err = fc.transformPhoto(t.ctx, &item, &co)
+			fc.setPixelState(t, reloadState(t), msg)
+			fc.setCycleState(t, pc.StateLoadWaite, pc.StateErrPreprocess, msg)
+			return fc.closeTransform
 		}
+	}
+	if t.ctx.Err() != nil {
+		//canceled, items are not complete
+		t.err = t.ctx.Err()
+		return fc.closeTransform
+	}
+	for i, item := range items {
+		l := log.With(logger, "item", item.ID)
+		co, warnings, err := results[i].order, results[i].warnings, results[i].err
 		if err != nil {
 			incomlete = true
-			msg := ""
-			if _, ok := err.(ErrSourceNotFound); ok == true {
-				//unziped folder deleted??
-				//reset to reload & close transform
-				msg = fmt.Sprintf("Перезапуск загрузки. Элемент заказа %s '%s'. Ошибка %s", co.SourceID, item.Name, err.Error())
-				if fc.Debug {
-					fmt.Printf("Элемент заказа %s '%s'. Ошибка %s\n", co.SourceID, item.Name, err.Error())
-				}
-				fc.setPixelState(t, statePixelStartLoad, msg)
-				fc.setCycleState(t, pc.StateLoadWaite, pc.StateErrPreprocess, msg)
-				return fc.closeTransform
-			}
-			msg = fmt.Sprintf("Элемент заказа %s '%s' не обработан. Ошибка %s", co.SourceID, item.Name, err.Error())
+			msg := fmt.Sprintf("Элемент заказа %s '%s' не обработан. Ошибка %s", co.SourceID, item.Name, err.Error())
+			dropped = append(dropped, fmt.Sprintf("%s '%s'", co.SourceID, item.Name))
 			if fc.Debug {
 				fmt.Printf(msg)
 			}
 			//just log error
 			l.Log("error", msg)
 			fc.setPixelState(t, "", msg)
-			fc.setCycleState(t, 0, pc.StateErrPreprocess, msg)
+			if err == errProductionNotSet {
+				fc.setCycleState(t, 0, pc.StateErrProductionNotSet, msg)
+			} else {
+				fc.setCycleState(t, 0, pc.StateErrPreprocess, msg)
+			}
 		} else {
 			//item processed, add order
-			exi := buildExtraInfo(co, item)
-			if isPhoto {
-				exi.Sheets = item.Quantity
-				exi.Books = 1
-			}
-			co.ExtraInfo = exi
 			orders = append(orders, co)
-			l.Log("transform", "complite")
+			if len(warnings) > 0 {
+				msg := fmt.Sprintf("Элемент заказа %s '%s'. Предупреждения: %s", co.SourceID, item.Name, joinWarnings(warnings))
+				l.Log("warning", msg)
+				fc.setPixelState(t, "", msg)
+				fc.setCycleState(t, 0, pc.StateTransform, msg)
+			}
 		}
 	}
 
+	if incomlete && t.confirmed && len(orders) > 0 {
+		//soft error confirmed, publish successful items
+		msg := fmt.Sprintf("Заказ размещен в Photocycle без элементов: %s", strings.Join(dropped, ", "))
+		logger.Log("warning", msg)
+		fc.setPixelState(t, "", msg)
+		fc.setCycleState(t, 0, pc.StateErrPreprocess, msg)
+		incomlete = false
+	}
 	if incomlete {
-		//TODO restarter not implemented
 		msg := "Часть элементов заказа не обработано. Заказ не размещен в Photocycle"
 		logger.Log("error", msg)
 		fc.setPixelState(t, statePixelWaiteConfirm, msg)
 		fc.setCycleState(t, pc.StatePreprocessIncomplite, pc.StateErrPreprocess, msg)
 		t.err = ErrTransform{errors.New(msg)}
-	} else {
-		//clear sub orders
-		err = fc.pcClient.ClearGroup(t.ctx, fc.source, t.pcBaseOrder.GroupID, t.pcBaseOrder.ID)
-		if err != nil {
-			t.err = ErrRepository{err}
-			_ = fc.setCycleState(t, pc.StateUnzip, pc.StateErrWrite, err.Error())
-			logger.Log("error", err.Error())
-			return fc.closeTransform
-		}
-		//create sub orders
-		err = fc.pcClient.FillOrders(t.ctx, orders)
-		if err != nil {
-			t.err = ErrRepository{err}
-			_ = fc.setCycleState(t, pc.StateUnzip, pc.StateErrWrite, err.Error())
-			logger.Log("error", err.Error())
-			return fc.closeTransform
-		}
-		logger.Log("event", "end")
-		//finalase
-		t.logger = logger
-		fc.finish(t, false)
+		//stop
+		return fc.closeTransform
 	}
+	logger.Log("event", "end")
+	//forvard to publish
+	t.pcOrders = orders
+	return fc.staged(StagePublish, fc.publish)
+}
+
+//publish creates transformed orders in cycle and finalizes transform
+//in states: statePixelLoadStarted in PP and StateUnzip in cycle
+//out states: see transformItems
+func (fc *baseFactory) publish(t *Transform) stateFunc {
+	logger := log.With(t.logger, "stage", "publish")
+	//clear sub orders
+	err := fc.pcClient.ClearGroup(t.ctx, fc.source, t.pcBaseOrder.GroupID, t.pcBaseOrder.ID)
+	if err != nil {
+		t.err = ErrRepository{err}
+		_ = fc.setCycleState(t, pc.StateUnzip, pc.StateErrWrite, err.Error())
+		logger.Log("error", err.Error())
+		return fc.closeTransform
+	}
+	//create sub orders
+	err = fc.pcClient.FillOrders(t.ctx, t.pcOrders)
+	if err != nil {
+		t.err = ErrRepository{err}
+		_ = fc.setCycleState(t, pc.StateUnzip, pc.StateErrWrite, err.Error())
+		logger.Log("error", err.Error())
+		return fc.closeTransform
+	}
+	//finalase
+	fc.finish(t, false)
 
 	//stop
 	return fc.closeTransform
 }
 
+//itemResult order item transform result
+type itemResult struct {
+	order    pc.Order
+	warnings []string
+	err      error
+}
+
+//transformAll transforms order items concurrently (up to ItemWorkers, extra workers take free slots of shared budget)
+//results are in items order, ErrSourceNotFound (fatal for order) cancels the rest of items
+func (fc *baseFactory) transformAll(ctx context.Context, src *orderSource, po *pp.Order, items []pp.OrderItem, logger log.Logger) []itemResult {
+	res := make([]itemResult, len(items))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	//transform itself holds one budget slot
+	workers := 1
+	for workers < fc.copyOpt.ItemWorkers && workers < len(items) && fc.budget.tryAcquire() {
+		workers++
+	}
+	defer func() {
+		for i := 1; i < workers; i++ {
+			fc.budget.release()
+		}
+	}()
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if ctx.Err() != nil {
+					res[i].err = ctx.Err()
+					continue
+				}
+				l := log.With(logger, "item", items[i].ID)
+				l.Log("transform", "start")
+				co, warnings, err := fc.transformItem(ctx, src, po, &items[i], i)
+				res[i] = itemResult{order: co, warnings: warnings, err: err}
+				if err == nil {
+					l.Log("transform", "complite")
+				} else if _, ok := err.(ErrSourceNotFound); ok {
+					cancel()
+				}
+			}
+		}()
+	}
+	for i := range items {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return res
+}
+
+//transformItem transforms order item (i - item index) to cycle order <source>_<pp order id>-<i>
+func (fc *baseFactory) transformItem(ctx context.Context, src *orderSource, po *pp.Order, item *pp.OrderItem, i int) (pc.Order, []string, error) {
+	co := fromPPOrder(po, fc.source, fmt.Sprintf("-%d", i))
+	co.SourceID = fmt.Sprintf("%s-%d", po.ID, item.ID)
+	co.Production = fc.route(po, item)
+	if fc.routing.hasRouting() && co.Production == 0 {
+		return co, nil, errProductionNotSet
+	}
+
+	isPhoto := false
+	//try build by alias
+	//intermediate state for buld by alias (then forward to StatePreprocessWaite)
+	co.State = pc.StateLoadComplite
+	warnings, err := fc.transformAlias(ctx, src, item, &co)
+	if _, ok := err.(ErrCantTransform); ok == true {
+		//try build photo print
+		//intermediate state for buld photo (then forward to StatePrintWaite)
+		isPhoto = true
+		//state can be forwarded
+		if co.State < pc.StatePreprocessComplite {
+			co.State = pc.StatePreprocessComplite
+		}
+		warnings, err = fc.transformPhoto(ctx, src, item, &co)
+	}
+	if err != nil {
+		return co, nil, err
+	}
+	exi := buildExtraInfo(co, *item)
+	if isPhoto {
+		exi.Sheets = item.Quantity
+		exi.Books = 1
+	}
+	co.ExtraInfo = exi
+	return co, warnings, nil
+}
+
 // closeTransform finalizes the Transform
 func (fc *baseFactory) closeTransform(t *Transform) stateFunc {
 	if t.IsComplete() {
@@ -971,6 +1344,10 @@ func (fc *baseFactory) closeTransform(t *Transform) stateFunc {
 		t.loader = nil
 	}
 
+	//release pipeline stage and order claim
+	fc.pipeline.leave(t)
+	fc.unclaim(t)
+
 	t.End = time.Now()
 	close(t.Done)
 	if t.cancel != nil {
@@ -1034,10 +1411,10 @@ func (fc *baseFactory) finish(t *Transform, restarted bool) (err error) {
 
 	if restarted == false && fc.Debug == false {
 		//set state in pp
-		err = fc.ppClient.SetOrderStatus(t.ctx, t.ppOrder.ID, pp.StatePrinting, true)
+		err = fc.src.SetStatus(t.ctx, t.ppOrder.ID, StatusPrinting, true)
 		if err != nil {
 			t.err = ErrService{err}
-			fc.setCycleState(t, pc.StateUnzip, pc.StateErrWeb, fmt.Sprintf("Ошибка смены статуса на сайте. Статус:%s; Ошибка:%s ", pp.StatePrinting, err.Error()))
+			fc.setCycleState(t, pc.StateUnzip, pc.StateErrWeb, fmt.Sprintf("Ошибка смены статуса на сайте. Статус:%s; Ошибка:%s ", StatusPrinting, err.Error()))
 			return err
 		}
 	}
@@ -1053,9 +1430,14 @@ func (fc *baseFactory) finish(t *Transform, restarted bool) (err error) {
 	//move main to complite state
 	fc.setCycleState(t, pc.StateSkiped, pc.StateTransform, fmt.Sprintf("complete, elapsed:%s", time.Since(t.Start).String()))
 
-	//kill zip and unziped folder
+	//archive (or kill) zip, kill unziped folder
 	if fc.Debug == false {
-		if err = os.Remove(filepath.Join(fc.wrkFolder, t.ppOrder.ID+".zip")); err != nil {
+		zipPath := filepath.Join(fc.wrkFolder, t.ppOrder.ID+".zip")
+		if fc.archive != nil {
+			if err = fc.archive.Put(t.ppOrder.ID, zipPath); err != nil {
+				t.logger.Log("warning", fmt.Sprintf("Archive error:%s", err.Error()))
+			}
+		} else if err = os.Remove(zipPath); err != nil {
 			t.logger.Log("warning", fmt.Sprintf("Cleanup error:%s", err.Error()))
 		}
 		if err = os.RemoveAll(path.Join(fc.wrkFolder, t.ppOrder.ID)); err != nil {
@@ -1065,17 +1447,17 @@ func (fc *baseFactory) finish(t *Transform, restarted bool) (err error) {
 	return nil
 }
 
-func (fc *baseFactory) setPixelState(t *Transform, state, message string) error {
+func (fc *baseFactory) setPixelState(t *Transform, state OrderStatus, message string) error {
 	var err error
 	if fc.Debug {
 		//do nothing
 		return nil
 	}
 	if state != "" {
-		err = fc.ppClient.SetOrderStatus(t.ctx, t.ppOrder.ID, state, false)
+		err = fc.src.SetStatus(t.ctx, t.ppOrder.ID, state, false)
 	}
 	if message != "" {
-		_ = fc.ppClient.AddOrderComment(t.ctx, t.ppOrder.ID, fc.ppUser, message)
+		_ = fc.src.AddNote(t.ctx, t.ppOrder.ID, fc.ppUser, message)
 	}
 	return err
 }
@@ -81,7 +81,7 @@ func (fc *baseFactory) getOrder(t *Transform) stateFunc {
 		return fc.closeTransform
 	}
 
-	po, err := fc.ppClient.GetOrder(t.ctx, t.ppOrder.ID)
+	po, err := fc.src.GetOrder(t.ctx, t.ppOrder.ID)
 	if err != nil {
 		t.err = ErrService{err}
 		return fc.closeTransform
@@ -96,7 +96,7 @@ func (fc *baseFactory) getOrder(t *Transform) stateFunc {
 	fc.pcClient.SetOrderState(t.ctx, co.ID, co.State)
 
 	/*do not change state in PP
-	if err = fc.ppClient.SetOrderStatus(t.ctx, po.ID, statePixelLoadStarted, false); err != nil {
+	if err = fc.src.SetStatus(t.ctx, po.ID, statePixelLoadStarted, false); err != nil {
 		t.err = ErrService{err}
 		return fc.closeTransform
 	}
@@ -110,37 +110,26 @@ func (fc *baseFactory) getOrder(t *Transform) stateFunc {
 
 //resetFetched - reset orders in statePixelLoadStarted (4 dubug only)
 func (fc *baseFactory) resetFetched(t *Transform) stateFunc {
-	doFetch := true
-	skip := 0
-	//while has some orders in statePixelLoadStarted
-	//can be Infinite loop if some SetOrderStatus incompleted ??
-	for doFetch {
-		orders, err := fc.ppClient.GetOrders(t.ctx, statePixelLoadStarted, 0, 0, 10, skip)
-
-		if err != nil {
-			t.err = err
-			return fc.closeTransform
+	orders, err := fc.src.Pending(t.ctx, statePixelLoadStarted)
+	if err != nil {
+		t.err = err
+		return fc.closeTransform
+	}
+	for _, po := range orders {
+		fc.logger.Log("resetFetched", po.ID)
+		co := fromPPOrder(&po, fc.source, "@")
+
+		//TODO load/check state from all orders by group?
+		if co.State == pc.StateCanceledPHCycle {
+			//cancel in pp
+			_ = fc.setPixelState(t, statePixelAbort, "Заказ отменен в PhotoCycle")
+			continue
 		}
-		doFetch = len(orders) > 0
-		for _, po := range orders {
-			fc.logger.Log("resetFetched", po.ID)
-			co := fromPPOrder(&po, fc.source, "@")
-
-			//TODO load/check state from all orders by group?
-			if co.State == pc.StateCanceledPHCycle {
-				//cancel in pp
-				if err := fc.setPixelState(t, statePixelAbort, "Заказ отменен в PhotoCycle"); err != nil {
-					skip++
-				}
-				continue
-			}
-			//reset
-			if err := fc.ppClient.SetOrderStatus(t.ctx, po.ID, statePixelStartLoad, false); err != nil {
-				skip++
-				continue
-			}
-			_ = fc.setCycleState(t, pc.StateLoadWaite, 0, "")
+		//reset
+		if err := fc.src.SetStatus(t.ctx, po.ID, statePixelStartLoad, false); err != nil {
+			continue
 		}
+		_ = fc.setCycleState(t, pc.StateLoadWaite, 0, "")
 	}
 
 	t.err = ErrEmptyQueue{fmt.Errorf("No orders in state %s", statePixelLoadStarted)}
@@ -0,0 +1,186 @@
+package transform
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+
+	pc "github.com/egorka-gh/pixlpark/photocycle"
+	pp "github.com/egorka-gh/pixlpark/pixlpark/service"
+)
+
+type testSource struct {
+	OrderSource
+	orders []pp.Order
+	status map[string]OrderStatus
+}
+
+func (s *testSource) Pending(ctx context.Context, state OrderStatus) ([]pp.Order, error) {
+	var res []pp.Order
+	for _, o := range s.orders {
+		if OrderStatus(o.Status) == state {
+			res = append(res, o)
+		}
+	}
+	return res, nil
+}
+
+func (s *testSource) GetOrder(ctx context.Context, id string) (pp.Order, error) {
+	for _, o := range s.orders {
+		if o.ID == id {
+			return o, nil
+		}
+	}
+	return pp.Order{}, sql.ErrNoRows
+}
+
+func (s *testSource) SetStatus(ctx context.Context, id string, state OrderStatus, notify bool) error {
+	s.status[id] = state
+	return nil
+}
+
+func (s *testSource) AddNote(ctx context.Context, id, user, note string) error {
+	return nil
+}
+
+type testRepository struct {
+	pc.Repository
+	orders map[string]pc.Order
+}
+
+func (r *testRepository) LoadOrder(ctx context.Context, id string) (pc.Order, error) {
+	if o, ok := r.orders[id]; ok {
+		return o, nil
+	}
+	return pc.Order{}, sql.ErrNoRows
+}
+
+func (r *testRepository) LoadBaseOrderByState(ctx context.Context, source, state int) (pc.Order, error) {
+	for _, o := range r.orders {
+		if o.State == state {
+			return o, nil
+		}
+	}
+	return pc.Order{}, sql.ErrNoRows
+}
+
+func (r *testRepository) SetOrderState(ctx context.Context, id string, state int) error {
+	o := r.orders[id]
+	o.State = state
+	r.orders[id] = o
+	return nil
+}
+
+func (r *testRepository) LogState(ctx context.Context, id string, state int, message string) error {
+	return nil
+}
+
+func Test_fetchToConfirm(t *testing.T) {
+	src := &testSource{
+		//Pending is sorted by date DESC, queue pops from the end
+		orders: []pp.Order{
+			{ID: "3", Status: string(statePixelConfirmed)},
+			{ID: "2", Status: string(statePixelConfirmed)},
+			{ID: "1", Status: string(statePixelConfirmed)},
+			{ID: "4", Status: string(statePixelWaiteConfirm)},
+		},
+		status: make(map[string]OrderStatus),
+	}
+	rep := &testRepository{orders: map[string]pc.Order{
+		//1 not in cycle, 2 in wrong state
+		"23_2@": {ID: "23_2@", State: pc.StatePrintWaite},
+		"23_3@": {ID: "23_3@", SourceID: "3", State: pc.StatePreprocessIncomplite},
+	}}
+	fc := NewSourceFactory(src, rep, 23, 0, t.TempDir(), "", "", "", nil).(*baseFactory)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tr := &Transform{fetchState: statePixelConfirmed, confirmed: true, Start: time.Now(), Done: make(chan struct{}), ctx: ctx, cancel: cancel, logger: log.NewNopLogger()}
+
+	fc.run(tr, fc.fetchToConfirm)
+	if tr.IsComplete() {
+		t.Fatalf("fetchToConfirm() error %v", tr.Err())
+	}
+	if tr.ID() != "3" || tr.pcBaseOrder.ID != "23_3@" {
+		t.Errorf("fetched %s (%s), want 3", tr.ID(), tr.pcBaseOrder.ID)
+	}
+	//confirmation is kept in PP till transform is complete
+	if len(src.status) != 0 {
+		t.Errorf("pp states = %v, want not changed", src.status)
+	}
+	if rep.orders["23_3@"].State != pc.StateLoadWaite {
+		t.Errorf("cycle state = %d, want %d", rep.orders["23_3@"].State, pc.StateLoadWaite)
+	}
+
+	//queue is empty
+	fc.run(tr, fc.fetchToConfirm)
+	if _, ok := tr.Err().(ErrEmptyQueue); !ok {
+		t.Errorf("fetchToConfirm() error %v, want ErrEmptyQueue", tr.Err())
+	}
+
+	//broken confirmed transform (cycle StateLoadWaite) is fetched again
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	tr = &Transform{fetchState: statePixelConfirmed, confirmed: true, Start: time.Now(), Done: make(chan struct{}), ctx: ctx, cancel: cancel, logger: log.NewNopLogger()}
+	fc.run(tr, fc.fetchToConfirm)
+	if tr.IsComplete() || tr.ID() != "3" {
+		t.Errorf("fetchToConfirm() restart = %s, %v, want 3", tr.ID(), tr.Err())
+	}
+}
+
+func Test_fetchToTransform_confirmed(t *testing.T) {
+	src := &testSource{
+		orders: []pp.Order{{ID: "5", Status: string(statePixelConfirmed)}},
+		status: make(map[string]OrderStatus),
+	}
+	rep := &testRepository{orders: map[string]pc.Order{
+		"23_5@": {ID: "23_5@", SourceID: "5", State: pc.StateUnzip},
+	}}
+	fc := NewSourceFactory(src, rep, 23, 0, t.TempDir(), "", "", "", nil).(*baseFactory)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tr := &Transform{fetchState: statePixelLoadStarted, Start: time.Now(), Done: make(chan struct{}), ctx: ctx, cancel: cancel, logger: log.NewNopLogger()}
+
+	fc.run(tr, fc.fetchToTransform)
+	if tr.IsComplete() {
+		t.Fatalf("fetchToTransform() error %v", tr.Err())
+	}
+	if !tr.confirmed {
+		t.Error("confirmation is not restored on restart")
+	}
+	if reloadState(tr) != statePixelConfirmed {
+		t.Errorf("reloadState() = %s, want %s", reloadState(tr), statePixelConfirmed)
+	}
+}
+
+func Test_transformAll(t *testing.T) {
+	fc := NewSourceFactory(&testSource{}, &testRepository{}, 23, 0, t.TempDir(), "", "", "", nil).(*baseFactory)
+	fc.SetCopyOptions(CopyOptions{ItemWorkers: 4})
+	b := NewBudget(3)
+	fc.SetBudget(b)
+	//transform own slot
+	b.acquire(context.Background())
+
+	po := &pp.Order{ID: "100"}
+	items := make([]pp.OrderItem, 10)
+	for i := range items {
+		//wrong paper, item fails on sku check
+		items[i] = pp.OrderItem{ID: i + 1, SkuItems: []pp.OrderItemSku{{Name: "paper", Value: fmt.Sprintf("p%d", i)}}}
+	}
+	res := fc.transformAll(context.Background(), nil, po, items, log.NewNopLogger())
+	if len(res) != len(items) {
+		t.Fatalf("transformAll() = %d results, want %d", len(res), len(items))
+	}
+	for i, r := range res {
+		if r.order.ID != fmt.Sprintf("23_100-%d", i) || r.err == nil || !strings.Contains(r.err.Error(), fmt.Sprintf("p%d", i)) {
+			t.Errorf("result %d = %s, %v", i, r.order.ID, r.err)
+		}
+	}
+	if b.InUse() != 1 {
+		t.Errorf("budget in use %d, want 1", b.InUse())
+	}
+}
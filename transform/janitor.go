@@ -0,0 +1,307 @@
+package transform
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pc "github.com/egorka-gh/pixlpark/photocycle"
+	"github.com/egorka-gh/pixlpark/storage"
+)
+
+//JanitorConfig work folder cleanup settings
+type JanitorConfig struct {
+	//Interval between runs
+	Interval time.Duration
+	//Retention orphan zips and folders are kept at least Retention (by modification time)
+	Retention time.Duration
+	//Quarantine move orphans to .quarantine subfolder (removed after Retention) instead of delete
+	Quarantine bool
+	//HighWater work folder size cap (bytes), orphans are removed oldest first regardless of retention, 0 - not checked
+	HighWater int64
+}
+
+//JanitorReport janitor run results
+type JanitorReport struct {
+	Time        time.Time `json:"time"`
+	Removed     int       `json:"removed"`
+	Quarantined int       `json:"quarantined"`
+	//Reclaimed bytes freed by last run
+	Reclaimed int64 `json:"reclaimed"`
+	//Total bytes freed since start
+	Total int64 `json:"total"`
+	//WorkSize work folder size after run
+	WorkSize int64  `json:"work_size"`
+	Error    string `json:"error,omitempty"`
+}
+
+const quarantineFolder = ".quarantine"
+
+//Janitor removes orphan zips and folders from work folder (not tied to active transform or live cycle order)
+//and orphan order folders from cycle folder (cycle order not exists)
+type Janitor struct {
+	cfg         JanitorConfig
+	rep         pc.Repository
+	source      int
+	wrkFolder   string
+	cycleFolder string
+	wrk         storage.Storage
+	out         storage.Storage
+
+	mu   sync.Mutex
+	last JanitorReport
+}
+
+//NewJanitor creates janitor, out - cycle folder storage
+func NewJanitor(cfg JanitorConfig, rep pc.Repository, source int, workFolder, cycleFolder string, out storage.Storage) *Janitor {
+	if out == nil {
+		out = storage.NewLocal()
+	}
+	return &Janitor{
+		cfg:         cfg,
+		rep:         rep,
+		source:      source,
+		wrkFolder:   workFolder,
+		cycleFolder: cycleFolder,
+		wrk:         storage.NewLocal(),
+		out:         out,
+	}
+}
+
+//Interval returns run interval
+func (j *Janitor) Interval() time.Duration {
+	return j.cfg.Interval
+}
+
+//Report returns last run report
+func (j *Janitor) Report() JanitorReport {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.last
+}
+
+//janitorEntry work or cycle folder item
+type janitorEntry struct {
+	name    string
+	orderID string //pp order id, empty if not order item
+	size    int64
+	modTime time.Time
+}
+
+//Run cleans work and cycle folders, active - pp order IDs of running transforms
+func (j *Janitor) Run(ctx context.Context, active map[string]bool) (JanitorReport, error) {
+	now := time.Now()
+	rep := JanitorReport{Time: now}
+	err := j.run(ctx, now, active, &rep)
+	if err != nil {
+		rep.Error = err.Error()
+	}
+	j.mu.Lock()
+	rep.Total = j.last.Total + rep.Reclaimed
+	j.last = rep
+	j.mu.Unlock()
+	return rep, err
+}
+
+func (j *Janitor) run(ctx context.Context, now time.Time, active map[string]bool, rep *JanitorReport) error {
+	//live cycle orders by pp order id
+	live := make(map[string]bool)
+	groups, err := j.rep.GetCurrentOrders(ctx, j.source)
+	if err != nil {
+		return ErrRepository{err}
+	}
+	for _, g := range groups {
+		live[strconv.Itoa(g.GroupID)] = true
+	}
+	for id := range active {
+		live[id] = true
+	}
+
+	//work folder
+	entries, err := j.scan(j.wrk, j.wrkFolder, func(name string) string {
+		return strings.TrimSuffix(name, ".zip")
+	})
+	if err != nil {
+		return ErrFileSystem{err}
+	}
+	var size int64
+	orphans := make([]janitorEntry, 0, len(entries))
+	for _, e := range entries {
+		size += e.size
+		if e.orderID != "" && live[e.orderID] {
+			continue
+		}
+		orphans = append(orphans, e)
+	}
+	//oldest first
+	sort.Slice(orphans, func(a, b int) bool { return orphans[a].modTime.Before(orphans[b].modTime) })
+	for _, e := range orphans {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		expired := now.Sub(e.modTime) > j.cfg.Retention
+		overflow := j.cfg.HighWater > 0 && size > j.cfg.HighWater
+		if !expired && !overflow {
+			continue
+		}
+		if err = j.dispose(j.wrk, j.wrkFolder, e, now, !expired, rep); err != nil {
+			return ErrFileSystem{err}
+		}
+		size -= e.size
+	}
+	if err = j.purgeQuarantine(j.wrk, j.wrkFolder, now, rep); err != nil {
+		return ErrFileSystem{err}
+	}
+	rep.WorkSize = size
+
+	//cycle folder, order folder is FtpFolder (pp order id + item sufix)
+	if j.cycleFolder == "" {
+		return nil
+	}
+	entries, err = j.scan(j.out, j.cycleFolder, func(name string) string {
+		if i := strings.Index(name, "-"); i > 0 {
+			return name[:i]
+		}
+		return name
+	})
+	if err != nil {
+		return ErrFileSystem{err}
+	}
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if now.Sub(e.modTime) <= j.cfg.Retention || (e.orderID != "" && live[e.orderID]) {
+			continue
+		}
+		if !strings.HasPrefix(e.name, ".") {
+			//order is in cycle db?
+			_, err = j.rep.LoadOrder(ctx, fmt.Sprintf("%d_%s", j.source, e.name))
+			if err == nil {
+				continue
+			}
+			if err != sql.ErrNoRows {
+				return ErrRepository{err}
+			}
+		}
+		if err = j.dispose(j.out, j.cycleFolder, e, now, false, rep); err != nil {
+			return ErrFileSystem{err}
+		}
+	}
+	if err = j.purgeQuarantine(j.out, j.cycleFolder, now, rep); err != nil {
+		return ErrFileSystem{err}
+	}
+	return nil
+}
+
+//scan lists folder items, orderID maps item name to pp order id
+//not order items (not numeric id) are skipped, except stale publication temp folders (.name.tmp)
+func (j *Janitor) scan(s storage.Storage, dir string, orderID func(name string) string) ([]janitorEntry, error) {
+	fis, err := s.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	res := make([]janitorEntry, 0, len(fis))
+	for _, fi := range fis {
+		e := janitorEntry{name: fi.Name(), modTime: fi.ModTime(), size: fi.Size()}
+		if strings.HasPrefix(e.name, ".") {
+			if !strings.HasSuffix(e.name, ".tmp") {
+				//quarantine or other service folder
+				continue
+			}
+		} else {
+			e.orderID = orderID(e.name)
+			if _, err := strconv.Atoi(e.orderID); err != nil {
+				//not order
+				continue
+			}
+		}
+		if fi.IsDir() {
+			if e.size, err = dirSize(s, path.Join(dir, e.name)); err != nil {
+				return nil, err
+			}
+		}
+		res = append(res, e)
+	}
+	return res, nil
+}
+
+//dispose removes or quarantines folder item, items removed by high-water mark are never quarantined
+func (j *Janitor) dispose(s storage.Storage, dir string, e janitorEntry, now time.Time, force bool, rep *JanitorReport) error {
+	name := path.Join(dir, e.name)
+	if j.cfg.Quarantine && !force {
+		q := path.Join(dir, quarantineFolder, now.Format("2006-01-02"))
+		if err := s.MkdirAll(q); err != nil {
+			return err
+		}
+		if err := s.Rename(name, path.Join(q, e.name)); err != nil {
+			return err
+		}
+		rep.Quarantined++
+		return nil
+	}
+	if err := s.RemoveAll(name); err != nil {
+		return err
+	}
+	rep.Removed++
+	rep.Reclaimed += e.size
+	return nil
+}
+
+//purgeQuarantine removes quarantine day folders older than retention
+func (j *Janitor) purgeQuarantine(s storage.Storage, dir string, now time.Time, rep *JanitorReport) error {
+	q := path.Join(dir, quarantineFolder)
+	fis, err := s.ReadDir(q)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, fi := range fis {
+		day, err := time.ParseInLocation("2006-01-02", fi.Name(), now.Location())
+		if err != nil || now.Sub(day) <= j.cfg.Retention {
+			continue
+		}
+		size, err := dirSize(s, path.Join(q, fi.Name()))
+		if err != nil {
+			return err
+		}
+		if err = s.RemoveAll(path.Join(q, fi.Name())); err != nil {
+			return err
+		}
+		rep.Reclaimed += size
+	}
+	return nil
+}
+
+//dirSize returns folder size (bytes)
+func dirSize(s storage.Storage, dir string) (int64, error) {
+	fis, err := s.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, fi := range fis {
+		if fi.IsDir() {
+			sz, err := dirSize(s, path.Join(dir, fi.Name()))
+			if err != nil {
+				return 0, err
+			}
+			size += sz
+		} else {
+			size += fi.Size()
+		}
+	}
+	return size, nil
+}
@@ -0,0 +1,128 @@
+package transform
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pc "github.com/egorka-gh/pixlpark/photocycle"
+	"github.com/egorka-gh/pixlpark/storage"
+)
+
+type janitorRepository struct {
+	pc.Repository
+	groups []pc.GroupState
+	orders map[string]bool
+}
+
+func (r *janitorRepository) GetCurrentOrders(ctx context.Context, source int) ([]pc.GroupState, error) {
+	return r.groups, nil
+}
+
+func (r *janitorRepository) LoadOrder(ctx context.Context, id string) (pc.Order, error) {
+	if r.orders[id] {
+		return pc.Order{ID: id}, nil
+	}
+	return pc.Order{}, sql.ErrNoRows
+}
+
+//touch creates file (or folder vs file if dir) vs size bytes and modification time age ago
+func touch(t *testing.T, name string, dir bool, size int, age time.Duration) {
+	fn := name
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if dir {
+		if err := os.MkdirAll(name, 0755); err != nil {
+			t.Fatal(err)
+		}
+		fn = filepath.Join(name, "file.jpg")
+	}
+	if err := ioutil.WriteFile(fn, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mt := time.Now().Add(-age)
+	os.Chtimes(fn, mt, mt)
+	os.Chtimes(name, mt, mt)
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+func TestJanitor(t *testing.T) {
+	wrk := t.TempDir()
+	cycle := t.TempDir()
+	day := 24 * time.Hour
+	touch(t, filepath.Join(wrk, "1.zip"), false, 100, 5*day) //orphan, expired
+	touch(t, filepath.Join(wrk, "1"), true, 100, 5*day)      //orphan, expired
+	touch(t, filepath.Join(wrk, "2.zip"), false, 100, 5*day) //live in cycle
+	touch(t, filepath.Join(wrk, "3.zip"), false, 100, 5*day) //active transform
+	touch(t, filepath.Join(wrk, "4.zip"), false, 100, day)   //orphan, not expired
+	touch(t, filepath.Join(wrk, "5.zip"), false, 300, 2*day) //orphan, not expired, older
+	touch(t, filepath.Join(wrk, "notes.txt"), false, 10, 5*day)
+	touch(t, filepath.Join(cycle, "7-0"), true, 50, 5*day) //in cycle db
+	touch(t, filepath.Join(cycle, "8-0"), true, 50, 5*day) //orphan
+	touch(t, filepath.Join(cycle, "9-0"), true, 50, day)   //orphan, not expired
+
+	rep := &janitorRepository{
+		groups: []pc.GroupState{{GroupID: 2}},
+		orders: map[string]bool{"23_7-0": true},
+	}
+	j := NewJanitor(JanitorConfig{Retention: 3 * day, HighWater: 400}, rep, 23, wrk, cycle, storage.NewLocal())
+	r, err := j.Run(context.Background(), map[string]bool{"3": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{
+		"1.zip": false, "1": false, "2.zip": true, "3.zip": true,
+		//high-water: 4.zip + 5.zip + live = 600, oldest orphan 5.zip removed
+		"4.zip": true, "5.zip": false,
+		"notes.txt": true,
+	} {
+		if got := exists(filepath.Join(wrk, name)); got != want {
+			t.Errorf("wrk %s exists = %v, want %v", name, got, want)
+		}
+	}
+	for name, want := range map[string]bool{"7-0": true, "8-0": false, "9-0": true} {
+		if got := exists(filepath.Join(cycle, name)); got != want {
+			t.Errorf("cycle %s exists = %v, want %v", name, got, want)
+		}
+	}
+	if r.Removed != 4 || r.Reclaimed != 550 || r.WorkSize != 300 {
+		t.Errorf("report = %+v", r)
+	}
+	if got := j.Report(); got.Total != 550 {
+		t.Errorf("Report().Total = %d", got.Total)
+	}
+}
+
+func TestJanitor_quarantine(t *testing.T) {
+	wrk := t.TempDir()
+	day := 24 * time.Hour
+	touch(t, filepath.Join(wrk, "1.zip"), false, 100, 5*day)
+	touch(t, filepath.Join(wrk, ".1.tmp"), true, 100, 5*day)
+	old := filepath.Join(wrk, quarantineFolder, time.Now().Add(-5*day).Format("2006-01-02"))
+	touch(t, filepath.Join(old, "0.zip"), false, 70, 5*day)
+
+	j := NewJanitor(JanitorConfig{Retention: 3 * day, Quarantine: true}, &janitorRepository{}, 23, wrk, "", nil)
+	r, err := j.Run(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := filepath.Join(wrk, quarantineFolder, time.Now().Format("2006-01-02"))
+	if !exists(filepath.Join(q, "1.zip")) || !exists(filepath.Join(q, ".1.tmp")) || exists(filepath.Join(wrk, "1.zip")) {
+		t.Error("orphans are not quarantined")
+	}
+	if exists(old) {
+		t.Error("expired quarantine is not removed")
+	}
+	if r.Quarantined != 2 || r.Reclaimed != 70 {
+		t.Errorf("report = %+v", r)
+	}
+}
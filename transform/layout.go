@@ -0,0 +1,206 @@
+package transform
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//LayoutParams expected book layout, built from alias and item sku
+type LayoutParams struct {
+	//HasCover alias has cover
+	HasCover bool `json:"has_cover"`
+	//FakeCover book without cover but zip has placeholder for cover (sku fake_cover)
+	FakeCover bool `json:"fake_cover"`
+	//Maket files named page_n/cover_n (sku maket), else surface_[n]
+	Maket bool `json:"maket"`
+	//Spreads spreads count by sku maket
+	Spreads int `json:"spreads"`
+	//PageCount pp order item page count
+	PageCount int `json:"page_count"`
+}
+
+//layout issue kinds
+const (
+	LayoutMissing    = "missing"
+	LayoutDuplicate  = "duplicate"
+	LayoutUnexpected = "unexpected"
+	LayoutCover      = "cover"
+	LayoutMaket      = "maket"
+	LayoutCount      = "count"
+)
+
+//LayoutIssue is one layout problem
+type LayoutIssue struct {
+	Kind    string `json:"kind"`
+	Sheet   int    `json:"sheet"`
+	Files   string `json:"files,omitempty"`
+	Message string `json:"message"`
+	//Fatal book can't be printed, otherwise just warning
+	Fatal bool `json:"fatal"`
+}
+
+//LayoutFile is file to sheet mapping
+type LayoutFile struct {
+	Name  string `json:"name"`
+	Sheet int    `json:"sheet"`
+	//Skipped reason why file is not used
+	Skipped string `json:"skipped,omitempty"`
+}
+
+//LayoutReport is book layout validation result
+type LayoutReport struct {
+	Params LayoutParams `json:"params"`
+	//Sheets expected sheets count (including cover)
+	Sheets int `json:"sheets"`
+	//First expected first sheet index (0 - cover)
+	First  int           `json:"first"`
+	Files  []LayoutFile  `json:"files"`
+	Issues []LayoutIssue `json:"issues"`
+}
+
+//Ok returns true if there is no fatal issues
+func (r LayoutReport) Ok() bool {
+	for _, is := range r.Issues {
+		if is.Fatal {
+			return false
+		}
+	}
+	return true
+}
+
+//Error returns fatal issues as text
+func (r LayoutReport) Error() string {
+	return r.join(true)
+}
+
+//Warnings returns not fatal issues
+func (r LayoutReport) Warnings() []string {
+	res := make([]string, 0)
+	for _, is := range r.Issues {
+		if !is.Fatal {
+			res = append(res, is.Message)
+		}
+	}
+	return res
+}
+
+func (r LayoutReport) join(fatal bool) string {
+	res := make([]string, 0, len(r.Issues))
+	for _, is := range r.Issues {
+		if is.Fatal == fatal {
+			res = append(res, is.Message)
+		}
+	}
+	return strings.Join(res, "; ")
+}
+
+func (r *LayoutReport) add(kind string, sheet int, files []string, fatal bool, format string, a ...interface{}) {
+	r.Issues = append(r.Issues, LayoutIssue{
+		Kind:    kind,
+		Sheet:   sheet,
+		Files:   strings.Join(files, ", "),
+		Message: fmt.Sprintf(format, a...),
+		Fatal:   fatal,
+	})
+}
+
+//reFakeCover placeholder of cover in surface naming
+var reFakeCover = regexp.MustCompile(`^surface_\[0\]`)
+
+//ValidateLayout validates book files names (files of item folder)
+func ValidateLayout(names []string, p LayoutParams) (LayoutReport, error) {
+	list := make([]fileCopy, 0, len(names))
+	for _, n := range names {
+		list = append(list, fileCopy{OldName: n, NewName: n, Process: allowedExt[filepath.Ext(n)], Qtty: 1})
+	}
+	return validateLayout(list, p)
+}
+
+//validateLayout indexes list sheets (see listIndexSheets) and checks layout
+//list is modified, files that are not used are marked Process=false
+func validateLayout(list []fileCopy, p LayoutParams) (LayoutReport, error) {
+	r := LayoutReport{Params: p, Files: make([]LayoutFile, 0, len(list)), Issues: make([]LayoutIssue, 0)}
+
+	//expected sheets
+	r.Sheets = p.PageCount
+	if p.FakeCover {
+		r.Sheets--
+	}
+	if p.Maket {
+		if p.Spreads != r.Sheets {
+			r.add(LayoutMaket, 0, nil, true, "Количество разворотов заказа %d не соответствует значению SKU maket %d", r.Sheets, p.Spreads)
+		}
+		//add cover
+		r.Sheets++
+	}
+	r.First = 1
+	if p.HasCover && !p.FakeCover {
+		r.First = 0
+	}
+
+	ext := make([]bool, len(list))
+	for i := range list {
+		ext[i] = list[i].Process
+	}
+	if err := listIndexSheets(list, p.HasCover, p.Maket, p.FakeCover); err != nil {
+		return r, err
+	}
+
+	bySheet := make(map[int][]string)
+	for i, fi := range list {
+		lf := LayoutFile{Name: fi.OldName, Sheet: -1}
+		name := sheetName(fi.OldName)
+		isCover := p.Maket && strings.HasPrefix(name, "cover_")
+		switch {
+		case !ext[i]:
+			lf.Skipped = "not image"
+			r.add(LayoutUnexpected, -1, []string{fi.OldName}, false, "Лишний файл %s", fi.OldName)
+		case strings.Contains(name, "_preview."):
+			lf.Skipped = "preview"
+		case !fi.Process && p.FakeCover && (isCover || reFakeCover.MatchString(name)):
+			lf.Skipped = "fake cover"
+		case !fi.Process:
+			lf.Skipped = "wrong name"
+			r.add(LayoutUnexpected, -1, []string{fi.OldName}, false, "Файл %s не соответствует шаблону имени", fi.OldName)
+		default:
+			lf.Sheet = fi.SheetIdx
+			bySheet[fi.SheetIdx] = append(bySheet[fi.SheetIdx], fi.OldName)
+			if isCover && !p.HasCover {
+				r.add(LayoutCover, fi.SheetIdx, []string{fi.OldName}, true, "Файл обложки %s, но книга без обложки", fi.OldName)
+			}
+		}
+		r.Files = append(r.Files, lf)
+	}
+	sheets := make([]int, 0, len(bySheet))
+	for idx := range bySheet {
+		sheets = append(sheets, idx)
+	}
+	sort.Ints(sheets)
+	last := r.First + r.Sheets - 1
+	for _, idx := range sheets {
+		files := bySheet[idx]
+		if idx < r.First || idx > last {
+			r.add(LayoutUnexpected, idx, files, true, "Разворот %d вне диапазона %d-%d (%s)", idx, r.First, last, strings.Join(files, ", "))
+			continue
+		}
+		if len(files) > 1 {
+			r.add(LayoutDuplicate, idx, files, true, "Дубликат разворота %d (%s)", idx, strings.Join(files, ", "))
+		}
+	}
+	for idx := r.First; idx <= last; idx++ {
+		if _, ok := bySheet[idx]; !ok {
+			if idx == 0 {
+				r.add(LayoutMissing, idx, nil, true, "Отсутствует обложка")
+			} else {
+				r.add(LayoutMissing, idx, nil, true, "Отсутствует разворот %d", idx)
+			}
+		}
+	}
+	if r.Ok() && len(bySheet) != r.Sheets {
+		r.add(LayoutCount, 0, nil, true, "Количество разворотов %d, ожидалось %d", len(bySheet), r.Sheets)
+	}
+	return r, nil
+}
@@ -0,0 +1,109 @@
+package transform
+
+import (
+	"testing"
+)
+
+func TestValidateLayout(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []string
+		params   LayoutParams
+		ok       bool
+		kinds    []string
+		warnings int
+	}{
+		{
+			name:   "surface with cover",
+			files:  []string{"surface_[0](oblozhka).jpg", "surface_[1].jpg", "surface_[2].jpg", "surface_[0]_preview.png"},
+			params: LayoutParams{HasCover: true, PageCount: 3},
+			ok:     true,
+		},
+		{
+			name:   "surface without cover",
+			files:  []string{"surface_[0].jpg", "surface_[1].jpg"},
+			params: LayoutParams{PageCount: 2},
+			ok:     true,
+		},
+		{
+			name:   "fake cover",
+			files:  []string{"surface_[0].jpg", "surface_[1].jpg", "surface_[2].jpg"},
+			params: LayoutParams{FakeCover: true, PageCount: 3},
+			ok:     true,
+		},
+		{
+			name:   "missing sheet",
+			files:  []string{"surface_[0].jpg", "surface_[1].jpg", "surface_[3].jpg"},
+			params: LayoutParams{HasCover: true, PageCount: 4},
+			kinds:  []string{LayoutMissing},
+		},
+		{
+			name:   "duplicate sheet",
+			files:  []string{"surface_[0].jpg", "surface_[1].jpg", "surface_[1](copy).jpg"},
+			params: LayoutParams{HasCover: true, PageCount: 2},
+			kinds:  []string{LayoutDuplicate},
+		},
+		{
+			name:   "out of range",
+			files:  []string{"surface_[0].jpg", "surface_[1].jpg", "surface_[5].jpg"},
+			params: LayoutParams{HasCover: true, PageCount: 2},
+			kinds:  []string{LayoutUnexpected},
+		},
+		{
+			name:     "unexpected files are warnings",
+			files:    []string{"surface_[0].jpg", "surface_[1].jpg", "readme.txt", "photo.jpg"},
+			params:   LayoutParams{HasCover: true, PageCount: 2},
+			ok:       true,
+			warnings: 2,
+		},
+		{
+			name:   "maket",
+			files:  []string{"cover_1-(a).jpg", "page_1-(a).jpg", "page_2-(a).jpg"},
+			params: LayoutParams{HasCover: true, Maket: true, Spreads: 2, PageCount: 2},
+			ok:     true,
+		},
+		{
+			name:   "maket spreads vs sku",
+			files:  []string{"cover_1-(a).jpg", "page_1-(a).jpg", "page_2-(a).jpg"},
+			params: LayoutParams{HasCover: true, Maket: true, Spreads: 3, PageCount: 2},
+			kinds:  []string{LayoutMaket},
+		},
+		{
+			name:   "maket missing cover",
+			files:  []string{"page_1-(a).jpg", "page_2-(a).jpg"},
+			params: LayoutParams{HasCover: true, Maket: true, Spreads: 2, PageCount: 2},
+			kinds:  []string{LayoutMissing},
+		},
+		{
+			name:   "cover but alias without cover",
+			files:  []string{"cover_1-(a).jpg", "page_1-(a).jpg", "page_2-(a).jpg"},
+			params: LayoutParams{Maket: true, Spreads: 2, PageCount: 2},
+			kinds:  []string{LayoutCover},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := ValidateLayout(tt.files, tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Ok() != tt.ok {
+				t.Fatalf("Ok() = %v, issues %+v", r.Ok(), r.Issues)
+			}
+			for _, k := range tt.kinds {
+				found := false
+				for _, is := range r.Issues {
+					if is.Kind == k && is.Fatal {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("issue %s not found in %+v", k, r.Issues)
+				}
+			}
+			if len(r.Warnings()) != tt.warnings {
+				t.Errorf("Warnings() = %v, want %d", r.Warnings(), tt.warnings)
+			}
+		})
+	}
+}
@@ -2,11 +2,16 @@ package transform
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"math"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	pc "github.com/egorka-gh/pixlpark/photocycle"
+	pp "github.com/egorka-gh/pixlpark/pixlpark/service"
 	log "github.com/go-kit/kit/log"
 )
 
@@ -23,12 +28,22 @@ func NewManager(factory Factory, concurrency, interval int, logger log.Logger) *
 		logger = log.NewNopLogger()
 	}
 
-	return &Manager{
+	m := &Manager{
 		factory:     factory,
 		concurrency: concurrency,
 		interval:    interval,
 		logger:      logger,
 	}
+	m.SetStages(StageLimits{})
+	return m
+}
+
+// Breaker reports remote service (PP) availability
+type Breaker interface {
+	//IsOpen service is considered unavailable
+	IsOpen() bool
+	//State human-friendly state
+	State() string
 }
 
 // Manager is queue manager of transform items (Transform)
@@ -38,6 +53,17 @@ type Manager struct {
 	interval    int //sleep interval in sec
 	logger      log.Logger
 
+	//running transforms limit shared with other managers
+	budget *Budget
+	//transform stage pools
+	pipeline *Pipeline
+	//zip download speed limiter
+	bandwidth *Bandwidth
+
+	//remote service state, manager backs off while breaker is open
+	breaker Breaker
+	backoff int //current sleep multiplier
+
 	//run control
 	chWork       <-chan struct{}
 	chWorkBackup <-chan struct{}
@@ -47,9 +73,20 @@ type Manager struct {
 	wg     sync.WaitGroup
 	cancel context.CancelFunc
 
+	//graceful stop, draining - new orders are not fetched
+	draining   int32
+	drainTimer *time.Timer
+
+	//loop heartbeat, last loop progress (unix nano)
+	beat int64
+
 	//last time run for daily tasks
 	dailyTasksTime time.Time
 
+	//work folder cleanup
+	janitor     *Janitor
+	janitorTime time.Time
+
 	//current transforms
 	mu         sync.Mutex            // guards transforms
 	transforms map[string]*Transform // ID -> transform
@@ -60,6 +97,9 @@ type Manager struct {
 	debug     bool
 }
 
+//errDraining manager is draining, new orders are not fetched
+var errDraining = errors.New("Manager is draining")
+
 //provider creates and run trusforms (factory function)
 type provider func(ctx context.Context) *Transform
 
@@ -93,15 +133,22 @@ Loop:
 			//run work vs specified interval
 			//create context
 			ctx, m.cancel = context.WithCancel(context.Background())
+			m.heartbeat()
 			m.doWork(ctx)
+			if m.isDraining() {
+				//started transforms are done, stop
+				m.cancel()
+				break Loop
+			}
 			//contex canceled?
 			if ctx.Err() == nil {
 				//release context
 				m.cancel()
 				//sleep
+				m.heartbeat()
 				m.mu.Lock()
 				m.chWork = nil
-				m.timer = time.AfterFunc(time.Duration(m.interval)*time.Second, m.play)
+				m.timer = time.AfterFunc(m.sleepInterval(), m.play)
 				m.mu.Unlock()
 			}
 		case _, ok := <-m.chControl:
@@ -114,7 +161,61 @@ Loop:
 			break Loop
 		}
 	}
-	//TODO finalizers??
+	//stop drain timers
+	m.mu.Lock()
+	if m.drainTimer != nil {
+		m.drainTimer.Stop()
+	}
+	m.mu.Unlock()
+}
+
+//sleepInterval returns sleep interval
+//while service breaker is open interval grows up to 4 x interval
+func (m *Manager) sleepInterval() time.Duration {
+	if m.backoff < 1 || !m.isServiceDown() {
+		m.backoff = 1
+	}
+	if m.isServiceDown() && m.backoff < 4 {
+		m.backoff *= 2
+	}
+	return time.Duration(m.interval*m.backoff) * time.Second
+}
+
+//isServiceDown checks if service breaker is open
+func (m *Manager) isServiceDown() bool {
+	return m.breaker != nil && m.breaker.IsOpen()
+}
+
+//SetBudget sets running transforms limit shared by several managers (sources)
+//budget is taken by transform stage, factory parallel item workers take free slots of the same budget
+func (m *Manager) SetBudget(b *Budget) {
+	m.budget = b
+	m.pipeline.budget = b
+	m.factory.SetBudget(b)
+}
+
+//SetStages sets stage pools concurrency (download, extract, transform, publish), not set limits are manager threads
+//must be called before Start
+func (m *Manager) SetStages(limits StageLimits) {
+	m.pipeline = NewPipeline(limits, m.concurrency)
+	m.pipeline.budget = m.budget
+	m.factory.SetPipeline(m.pipeline)
+}
+
+//SetBandwidth sets zip download speed limiter, limit and throughput are shown in manager info
+func (m *Manager) SetBandwidth(b *Bandwidth) {
+	m.bandwidth = b
+	m.factory.SetBandwidth(b)
+}
+
+//SetJanitor sets work folder janitor, janitor runs before loading new orders
+func (m *Manager) SetJanitor(j *Janitor) {
+	m.janitor = j
+}
+
+//SetBreaker sets remote service breaker, manager skips work while breaker is open
+func (m *Manager) SetBreaker(breaker Breaker) {
+	m.breaker = breaker
 }
 
 //machine control
@@ -143,6 +244,7 @@ func (m *Manager) Start() {
 	m.wg = sync.WaitGroup{}
 	m.wg.Add(1)
 
+	m.heartbeat()
 	go m.machine()
 }
 
@@ -214,6 +316,42 @@ func (m *Manager) Quit() {
 	close(m.chControl)
 }
 
+//Drain stops manager gracefully, non blocking
+//new orders are not fetched, running transforms are completed till timeout,
+//after timeout transforms are stopped at next stage boundary (restarters resume them after start),
+//transforms that still run after grace are canceled
+//use Wait to waite manager stop, any calls to Start and Pause will panic (send to closed chControl)
+func (m *Manager) Drain(timeout, grace time.Duration) {
+	m.logger.Log("Drain", timeout.String())
+	atomic.StoreInt32(&m.draining, 1)
+	m.mu.Lock()
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+	m.chWork = nil
+	m.currState = "Завершение текущих заказов"
+	m.drainTimer = time.AfterFunc(timeout, func() {
+		m.logger.Log("Drain", "stop at stage boundary")
+		m.pipeline.stop()
+		m.mu.Lock()
+		m.currState = "Остановка"
+		m.drainTimer = time.AfterFunc(grace, func() {
+			m.logger.Log("Drain", "cancel")
+			if m.cancel != nil {
+				m.cancel()
+			}
+		})
+		m.mu.Unlock()
+	})
+	m.mu.Unlock()
+	close(m.chControl)
+}
+
+//isDraining checks if manager is draining (stops gracefully)
+func (m *Manager) isDraining() bool {
+	return atomic.LoadInt32(&m.draining) == 1
+}
+
 //Wait blocks caller till manager stops
 func (m *Manager) Wait() {
 	m.wg.Wait()
@@ -229,6 +367,110 @@ type ManagerInfo struct {
 	OrderCount    int     `json:"count"`
 	QueueLen      int     `json:"queue"`
 	DownloadSpeed float64 `json:"speed"`
+	Breaker       string  `json:"breaker"`
+	//Janitor last cleanup report
+	Janitor *JanitorReport `json:"janitor,omitempty"`
+	//Bandwidth current download limit and throughput
+	Bandwidth *BandwidthInfo `json:"bandwidth,omitempty"`
+	//Stages stage pools state
+	Stages []StageInfo `json:"stages,omitempty"`
+}
+
+//ManagerHealth manager loop liveness
+type ManagerHealth struct {
+	Started  bool `json:"started"`
+	Paused   bool `json:"paused"`
+	Draining bool `json:"draining"`
+	//Beat last loop progress (work start, order fetch, transform complete, sleep)
+	Beat time.Time `json:"beat"`
+	//Stale loop is considered stuck if Beat is older
+	Stale time.Duration `json:"-"`
+}
+
+//IsStale checks if manager loop has no progress during Stale
+func (h ManagerHealth) IsStale(now time.Time) bool {
+	return h.Started && !h.Paused && now.Sub(h.Beat) > h.Stale
+}
+
+//heartbeat marks manager loop progress
+func (m *Manager) heartbeat() {
+	atomic.StoreInt64(&m.beat, time.Now().UnixNano())
+}
+
+//Health returns manager loop liveness
+//loop is stale if there is no progress during max sleep interval + timeout (longest transform)
+func (m *Manager) Health(timeout time.Duration) ManagerHealth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := ManagerHealth{
+		Started:  m.IsStarted(),
+		Paused:   m.isPaused,
+		Draining: m.isDraining(),
+		Stale:    time.Duration(m.interval*4)*time.Second + timeout,
+	}
+	if b := atomic.LoadInt64(&m.beat); b > 0 {
+		h.Beat = time.Unix(0, b)
+	}
+	return h
+}
+
+//Reprint rebuilds sheets or books of published order (see Factory.Reprint)
+//refuses if order transform is running
+func (m *Manager) Reprint(ctx context.Context, r ReprintRequest) ([]pc.PrintGroup, error) {
+	release, err := m.lockItem(r.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	//caller can be detached (see service runDetached), so log errors
+	res, err := m.factory.Reprint(ctx, r)
+	if err != nil {
+		m.logger.Log("reprint", r.OrderID, "error", err)
+	}
+	return res, err
+}
+
+//RetransformItem rebuilds one order item into its cycle order (see Factory.RetransformItem)
+//refuses if order transform is running
+func (m *Manager) RetransformItem(ctx context.Context, orderID string) (pc.Order, error) {
+	release, err := m.lockItem(orderID)
+	if err != nil {
+		return pc.Order{}, err
+	}
+	defer release()
+	//caller can be detached (see service runDetached), so log errors
+	res, err := m.factory.RetransformItem(ctx, orderID)
+	if err != nil {
+		m.logger.Log("retransform", orderID, "error", err)
+	}
+	return res, err
+}
+
+//lockItem registers pp order of cycle item order (<source>_<pp order id>-<n>) as running transform
+//refuses if order transform is running, release unregisters order
+func (m *Manager) lockItem(orderID string) (release func(), err error) {
+	_, ppID, _, err := parseItemID(orderID)
+	if err != nil {
+		return nil, err
+	}
+	if m.isDraining() {
+		return nil, ErrStopped{fmt.Errorf("Заказ %s не запущен (остановка сервиса)", ppID)}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, active := m.transforms[ppID]; active {
+		return nil, ErrTransform{fmt.Errorf("Заказ %s в обработке", ppID)}
+	}
+	if m.transforms == nil {
+		m.transforms = make(map[string]*Transform)
+	}
+	//placeholder transform, completed on release
+	t := &Transform{ppOrder: pp.Order{ID: ppID}, Start: time.Now(), Done: make(chan struct{})}
+	m.transforms[ppID] = t
+	return func() {
+		close(t.Done)
+		m.monTransform(ppID, nil)
+	}, nil
 }
 
 //GetInfo returns ManagerInfo
@@ -252,13 +494,36 @@ func (m *Manager) GetInfo() (info ManagerInfo) {
 		QueueLen:      m.factory.QueueLen(),
 		DownloadSpeed: math.Round(speed*100/float64(1024*1024)) / 100,
 	}
+	if m.breaker != nil {
+		inf.Breaker = m.breaker.State()
+	}
+	if m.janitor != nil {
+		r := m.janitor.Report()
+		inf.Janitor = &r
+	}
+	if m.bandwidth != nil {
+		b := m.bandwidth.Info()
+		inf.Bandwidth = &b
+	}
+	if m.pipeline != nil {
+		inf.Stages = m.pipeline.Info()
+	}
 
 	return inf
 }
 
 //run regular sequense, new first then restart stuck orders
 func (m *Manager) doWork(ctx context.Context) {
+	//clean up work folder (free space before load)
+	m.runJanitor(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+
 	//load new
+	if m.backOff() {
+		return
+	}
 	m.currState = "Загрузка"
 	err := m.runQueue(ctx, m.factory.LoadNew, true)
 	if err != nil || ctx.Err() != nil {
@@ -269,13 +534,29 @@ func (m *Manager) doWork(ctx context.Context) {
 	//run restarters
 
 	//finalize prepared
+	if m.backOff() {
+		return
+	}
 	m.currState = "Перезапуск не завершенных"
 	err = m.runQueue(ctx, m.factory.FinalizeRestart, false)
 	if err != nil || ctx.Err() != nil {
 		m.logNotNilErr("FinalizeRestart", err, ctx.Err())
 		return
 	}
+	//restart confirmed soft errors
+	if m.backOff() {
+		return
+	}
+	m.currState = "Перезапуск подтвержденных"
+	err = m.runQueue(ctx, m.factory.SoftErrorRestart, true)
+	if err != nil || ctx.Err() != nil {
+		m.logNotNilErr("SoftErrorRestart", err, ctx.Err())
+		return
+	}
 	//restart broken transforms
+	if m.backOff() {
+		return
+	}
 	m.currState = "Перезапуск не подготовленных"
 	err = m.runQueue(ctx, m.factory.TransformRestart, false)
 	if err != nil || ctx.Err() != nil {
@@ -283,6 +564,9 @@ func (m *Manager) doWork(ctx context.Context) {
 		return
 	}
 	//restart broken loads
+	if m.backOff() {
+		return
+	}
 	m.currState = "Перезапуск не загруженных"
 	err = m.runQueue(ctx, m.factory.LoadRestart, true)
 	if err != nil || ctx.Err() != nil {
@@ -291,6 +575,9 @@ func (m *Manager) doWork(ctx context.Context) {
 	}
 
 	//daily tasks
+	if m.backOff() {
+		return
+	}
 	if m.dailyTasksTime.IsZero() || time.Since(m.dailyTasksTime).Hours() > 24 {
 		m.dailyTasksTime = time.Now()
 		//sync cycle vs pixel
@@ -305,6 +592,42 @@ func (m *Manager) doWork(ctx context.Context) {
 	m.currState = "Ожидание"
 }
 
+//runJanitor runs janitor if interval passed
+func (m *Manager) runJanitor(ctx context.Context) {
+	if m.janitor == nil {
+		return
+	}
+	if !m.janitorTime.IsZero() && time.Since(m.janitorTime) < m.janitor.Interval() {
+		return
+	}
+	m.janitorTime = time.Now()
+	m.currState = "Очистка рабочей папки"
+	m.mu.Lock()
+	active := make(map[string]bool, len(m.transforms))
+	for id := range m.transforms {
+		active[id] = true
+	}
+	m.mu.Unlock()
+	r, err := m.janitor.Run(ctx, active)
+	if err != nil {
+		m.logNotNilErr("Janitor", err)
+		return
+	}
+	if r.Removed > 0 || r.Quarantined > 0 {
+		m.logger.Log("Janitor", fmt.Sprintf("removed:%d; quarantined:%d; reclaimed:%d", r.Removed, r.Quarantined, r.Reclaimed))
+	}
+}
+
+//backOff checks if service is down, if so skips rest of work till next run
+func (m *Manager) backOff() bool {
+	if !m.isServiceDown() {
+		return false
+	}
+	m.currState = "Сайт не доступен"
+	m.logger.Log("backoff", m.breaker.State())
+	return true
+}
+
 func (m *Manager) logNotNilErr(key string, errs ...error) {
 	for _, e := range errs {
 		if e != nil {
@@ -314,11 +637,17 @@ func (m *Manager) logNotNilErr(key string, errs ...error) {
 	}
 }
 
-//TODO add transforms limit??
+//runQueue fetches and runs transforms till provider queue is empty
+//transforms are limited by pipeline stage pools (provider blocks till entry stage is free),
+//runQueue limits transforms in work by pipeline capacity (by threads if there is no pipeline)
 func (m *Manager) runQueue(ctx context.Context, provider provider, monitor bool) (err error) {
-	sem := make(chan bool, m.concurrency)
+	limit := m.concurrency
+	if m.pipeline != nil {
+		limit = m.pipeline.capacity()
+	}
+	sem := make(chan bool, limit)
 	for {
-		//waite concurrency limit
+		//waite in work limit
 		sem <- true
 		//check context done
 		if ctx.Err() != nil {
@@ -329,7 +658,14 @@ func (m *Manager) runQueue(ctx context.Context, provider provider, monitor bool)
 			//stop loop
 			break
 		}
+		//graceful stop, don't fetch new
+		if m.isDraining() {
+			err = errDraining
+			<-sem
+			break
+		}
 		//fetch next transform
+		m.heartbeat()
 		t := provider(ctx)
 		if t.IsComplete() {
 			if _, ok := t.Err().(ErrEmptyQueue); ok == false {
@@ -347,9 +683,12 @@ func (m *Manager) runQueue(ctx context.Context, provider provider, monitor bool)
 			//waite till transform complite
 			go func(t *Transform) {
 				//release semafor
-				defer func() { <-sem }()
+				defer func() {
+					<-sem
+				}()
 				//block till complite
 				t.Wait()
+				m.heartbeat()
 				//remove from monitor
 				m.monTransform(t.ID(), nil)
 			}(t)
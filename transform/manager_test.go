@@ -8,7 +8,9 @@ import (
 	"testing"
 	"time"
 
+	pc "github.com/egorka-gh/pixlpark/photocycle"
 	pp "github.com/egorka-gh/pixlpark/pixlpark/service"
+	"github.com/egorka-gh/pixlpark/storage"
 )
 
 func createProvider(name string, calls int32, counter chan<- int) provider {
@@ -79,6 +81,14 @@ func (f *testFactory) FinalizeRestart(ctx context.Context) *Transform {
 func (f *testFactory) DoOrder(ctx context.Context, notused string) *Transform {
 	return f.doOrder(ctx)
 }
+func (f *testFactory) Reprint(ctx context.Context, r ReprintRequest) ([]pc.PrintGroup, error) {
+	//noop
+	return nil, nil
+}
+func (f *testFactory) RetransformItem(ctx context.Context, orderID string) (pc.Order, error) {
+	//noop
+	return pc.Order{}, nil
+}
 func (f *testFactory) SoftErrorRestart(ctx context.Context) *Transform {
 	return f.softErrorRestart(ctx)
 }
@@ -88,6 +98,33 @@ func (f *testFactory) SyncCycle(ctx context.Context) *Transform {
 func (f *testFactory) SetDebug(debug bool) {
 	//noop
 }
+func (f *testFactory) SetPreflight(p Preflight) {
+	//noop
+}
+func (f *testFactory) SetCopyOptions(o CopyOptions) {
+	//noop
+}
+func (f *testFactory) SetStorage(s storage.Storage) {
+	//noop
+}
+func (f *testFactory) SetArchive(a *Archive) {
+	//noop
+}
+func (f *testFactory) SetRouting(r Routing) {
+	//noop
+}
+func (f *testFactory) SetBudget(b *Budget) {
+	//noop
+}
+func (f *testFactory) SetBandwidth(b *Bandwidth) {
+	//noop
+}
+func (f *testFactory) SetPipeline(p *Pipeline) {
+	//noop
+}
+func (f *testFactory) SetCluster(c Cluster) {
+	//noop
+}
 func (f *testFactory) QueueLen() int {
 	//noop
 	return 0
@@ -326,6 +363,60 @@ func Test_quitManager(t *testing.T) {
 
 }
 
+func Test_drainManager(t *testing.T) {
+	var calls int32 = 3
+	var doneCalls int32
+	chCounter := make(chan int, 4*calls)
+	f, _ := createFactory(calls, 1, chCounter)
+	m := NewManager(f, 3, 5, nil)
+	start := time.Now()
+	m.Start()
+	//drain while LoadNew transforms are running
+	time.AfterFunc(100*time.Millisecond, func() {
+		m.Drain(5*time.Second, time.Second)
+	})
+	m.Wait()
+	runSec := time.Since(start).Seconds()
+	//let counter relay complete
+	time.Sleep(50 * time.Millisecond)
+	doneCalls = int32(len(chCounter))
+	if runSec > 1.0 {
+		t.Errorf("Manager not drained. Expected run time < %.2fs got %.2f", 1.0, runSec)
+	}
+	//running transforms are completed (not canceled), new ones are not fetched
+	if doneCalls != calls {
+		t.Errorf("Expected completed transforms %d got %d", calls, doneCalls)
+	}
+}
+
+func Test_managerHealth(t *testing.T) {
+	chCounter := make(chan int, 20)
+	f, _ := createFactory(1, 1, chCounter)
+	m := NewManager(f, 1, 5, nil)
+	if h := m.Health(time.Minute); h.Started {
+		t.Error("Manager not started, expected Started false")
+	}
+	m.Start()
+	time.Sleep(100 * time.Millisecond)
+	h := m.Health(time.Minute)
+	if !h.Started || h.Beat.IsZero() {
+		t.Fatalf("Manager started, expected heartbeat, got %+v", h)
+	}
+	if h.IsStale(time.Now()) {
+		t.Error("Manager heartbeat expected fresh")
+	}
+	//sleep interval 5 min x 4 + 1 min
+	if !h.IsStale(h.Beat.Add(22 * time.Minute)) {
+		t.Error("Manager heartbeat expected stale")
+	}
+	m.Pause()
+	if h = m.Health(time.Minute); !h.Paused || h.IsStale(h.Beat.Add(22*time.Minute)) {
+		t.Errorf("Paused manager is not stale, got %+v", h)
+	}
+	m.Quit()
+	m.Wait()
+}
+
 func Test_pauseManager(t *testing.T) {
 	var calls int32 = 3
 	var cycles int32 = 5
@@ -385,3 +476,78 @@ func Test_pauseManager(t *testing.T) {
 		t.Errorf("Manager not paused while running")
 	}
 }
+
+type testBreaker struct {
+	open bool
+}
+
+func (b *testBreaker) IsOpen() bool {
+	return b.open
+}
+func (b *testBreaker) State() string {
+	if b.open {
+		return "GetOrder:open"
+	}
+	return ""
+}
+
+func Test_backOffManager(t *testing.T) {
+	var calls int32 = 3
+	chCounter := make(chan int, 4*calls)
+	f, _ := createFactory(calls, 1, chCounter)
+	b := &testBreaker{open: true}
+	m := NewManager(f, 1, 5, nil)
+	m.SetBreaker(b)
+
+	m.doWork(context.Background())
+	if len(chCounter) != 0 {
+		t.Errorf("Expected no calls while breaker is open, got %d", len(chCounter))
+	}
+	if inf := m.GetInfo(); inf.Breaker != "GetOrder:open" {
+		t.Errorf("Expected breaker state %q got %q", "GetOrder:open", inf.Breaker)
+	}
+	interval := time.Duration(m.interval) * time.Second
+	if d := m.sleepInterval(); d != 2*interval {
+		t.Errorf("Expected sleep interval %s got %s", 2*interval, d)
+	}
+	if d := m.sleepInterval(); d != 4*interval {
+		t.Errorf("Expected sleep interval %s got %s", 4*interval, d)
+	}
+	if d := m.sleepInterval(); d != 4*interval {
+		t.Errorf("Expected sleep interval %s got %s", 4*interval, d)
+	}
+	b.open = false
+	if d := m.sleepInterval(); d != interval {
+		t.Errorf("Expected sleep interval %s got %s", interval, d)
+	}
+}
+
+func Test_lockItem(t *testing.T) {
+	m := NewManager(&testFactory{}, 1, 5, nil)
+	release, err := m.lockItem("23_100-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.lockItem("23_100-0"); err == nil {
+		t.Error("sibling item of running order: expected error")
+	}
+	if _, err = m.Reprint(context.Background(), ReprintRequest{OrderID: "23_100-0", Sheets: []int{1}}); err == nil {
+		t.Error("reprint of running order: expected error")
+	}
+	if info := m.GetInfo(); info.OrderIds != "100" {
+		t.Errorf("running orders = %s, want 100", info.OrderIds)
+	}
+	release()
+	if release, err = m.lockItem("23_100-0"); err != nil {
+		t.Errorf("released order: %v", err)
+	} else {
+		release()
+	}
+	if _, err = m.lockItem("23_100@"); err == nil {
+		t.Error("base order id: expected error")
+	}
+	atomic.StoreInt32(&m.draining, 1)
+	if _, err = m.lockItem("23_100-0"); err == nil {
+		t.Error("draining manager: expected error")
+	}
+}
@@ -0,0 +1,158 @@
+package transform
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cavaliercoder/grab"
+
+	pp "github.com/egorka-gh/pixlpark/pixlpark/service"
+)
+
+//OrderStatus order state in OrderSource, each source maps it to own order states (see pixlparkSource)
+type OrderStatus string
+
+//order states of OrderSource
+const (
+	//StatusReady ready to load
+	StatusReady OrderStatus = "ready"
+	//StatusLoading fetched by transform
+	StatusLoading OrderStatus = "loading"
+	//StatusAwaitingReply waits customer reply (confirmation)
+	StatusAwaitingReply OrderStatus = "awaitingReply"
+	//StatusConfirmed customer confirmed processing (after StatusAwaitingReply)
+	StatusConfirmed OrderStatus = "confirmed"
+	//StatusPrinting transformed, in production
+	StatusPrinting OrderStatus = "printing"
+	//StatusCancelled cancelled by customer or shop
+	StatusCancelled OrderStatus = "cancelled"
+	//StatusDefect aborted by transform
+	StatusDefect OrderStatus = "defect"
+)
+
+//OrderSource shop the transform is fed from (pixlpark site, drop folder ...)
+//orders and items are described by pixlpark types, order Status holds OrderStatus
+//(source states that has no OrderStatus are kept as is)
+type OrderSource interface {
+	//Pending returns all orders in state, sorted by date DESC
+	Pending(ctx context.Context, state OrderStatus) ([]pp.Order, error)
+	//GetOrder returns order by id
+	GetOrder(ctx context.Context, id string) (pp.Order, error)
+	//GetOrderItems returns order items
+	GetOrderItems(ctx context.Context, id string) ([]pp.OrderItem, error)
+	//Download starts order zip download to fileName, limit - optional download speed limiter
+	Download(ctx context.Context, order pp.Order, fileName string, limit RateLimiter) (Download, error)
+	//SetStatus sets order state, notify - notify customer
+	SetStatus(ctx context.Context, id string, state OrderStatus, notify bool) error
+	//AddNote adds order comment on behalf of user
+	AddNote(ctx context.Context, id, user, note string) error
+}
+
+//Download order zip download in progress
+type Download interface {
+	//Err blocks till download is complete and returns download error
+	Err() error
+	//IsComplete returns true if download is complete
+	IsComplete() bool
+	//Cancel cancels download, blocks till download is complete
+	Cancel() error
+	//BytesPerSecond returns download speed
+	BytesPerSecond() float64
+	//Duration returns download duration
+	Duration() time.Duration
+}
+
+//pixlparkSource OrderSource implementation over pixlpark api
+type pixlparkSource struct {
+	client pp.PPService
+}
+
+//NewPixlparkSource creates OrderSource of pixlpark site
+func NewPixlparkSource(client pp.PPService) OrderSource {
+	return &pixlparkSource{client: client}
+}
+
+//pixlparkStates OrderStatus -> pixlpark order state
+var pixlparkStates = map[OrderStatus]string{
+	StatusReady:         pp.StateReadyToProcessing,
+	StatusLoading:       pp.StatePrepressCoordination,
+	StatusAwaitingReply: pp.StatePrepressCoordinationAwaitingReply,
+	StatusConfirmed:     pp.StatePrepressCoordinationComplete,
+	StatusPrinting:      pp.StatePrinting,
+	StatusCancelled:     pp.StateCancelled,
+	StatusDefect:        pp.StatePrintedWithDefect,
+}
+
+//toPixlpark maps OrderStatus to pixlpark state
+func toPixlpark(state OrderStatus) (string, error) {
+	st, ok := pixlparkStates[state]
+	if !ok {
+		return "", fmt.Errorf("Unknown order status '%s'", state)
+	}
+	return st, nil
+}
+
+//fromPixlpark sets order Status to OrderStatus, unmapped pixlpark states are kept
+func fromPixlpark(o *pp.Order) {
+	for st, ppState := range pixlparkStates {
+		if o.Status == ppState {
+			o.Status = string(st)
+			return
+		}
+	}
+}
+
+func (s *pixlparkSource) Pending(ctx context.Context, state OrderStatus) ([]pp.Order, error) {
+	ppState, err := toPixlpark(state)
+	if err != nil {
+		return nil, err
+	}
+	cnt, err := s.client.CountOrders(ctx, []string{ppState})
+	if err != nil || cnt == 0 {
+		return nil, err
+	}
+	orders, err := s.client.GetOrders(ctx, ppState, 0, 0, cnt, 0)
+	for i := range orders {
+		fromPixlpark(&orders[i])
+	}
+	return orders, err
+}
+
+func (s *pixlparkSource) GetOrder(ctx context.Context, id string) (pp.Order, error) {
+	o, err := s.client.GetOrder(ctx, id)
+	fromPixlpark(&o)
+	return o, err
+}
+
+func (s *pixlparkSource) GetOrderItems(ctx context.Context, id string) ([]pp.OrderItem, error) {
+	return s.client.GetOrderItems(ctx, id)
+}
+
+func (s *pixlparkSource) Download(ctx context.Context, order pp.Order, fileName string, limit RateLimiter) (Download, error) {
+	req, err := grab.NewRequest(fileName, order.DownloadLink)
+	if err != nil {
+		return nil, err
+	}
+	//TODO prevent reusing connection
+	req.HTTPRequest.Close = true
+	req = req.WithContext(ctx)
+	req.SkipExisting = true
+	req.NoResume = true
+	if limit != nil {
+		req.RateLimiter = limit
+	}
+	return grab.NewClient().Do(req), nil
+}
+
+func (s *pixlparkSource) SetStatus(ctx context.Context, id string, state OrderStatus, notify bool) error {
+	ppState, err := toPixlpark(state)
+	if err != nil {
+		return err
+	}
+	return s.client.SetOrderStatus(ctx, id, ppState, notify)
+}
+
+func (s *pixlparkSource) AddNote(ctx context.Context, id, user, note string) error {
+	return s.client.AddOrderComment(ctx, id, user, note)
+}
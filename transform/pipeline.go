@@ -0,0 +1,185 @@
+package transform
+
+import "sync"
+
+//Stage transform pipeline stage
+type Stage int
+
+const (
+	//StageDownload fetch order and zip download (network)
+	StageDownload Stage = iota
+	//StageExtract unzip and preflight (disk)
+	StageExtract
+	//StageTransform items transform to cycle orders (cpu, disk)
+	StageTransform
+	//StagePublish cycle orders creation, states in source and cycle, zip archive (db, network)
+	StagePublish
+	stageCount
+)
+
+var stageNames = [stageCount]string{"download", "extract", "transform", "publish"}
+
+func (s Stage) String() string {
+	if s < 0 || s >= stageCount {
+		return "unknown"
+	}
+	return stageNames[s]
+}
+
+//StageLimits stage pools concurrency, 0 - manager threads
+type StageLimits struct {
+	Download  int
+	Extract   int
+	Transform int
+	Publish   int
+}
+
+//StageInfo stage pool state
+type StageInfo struct {
+	Name    string `json:"name"`
+	Workers int    `json:"workers"`
+	Busy    int    `json:"busy"`
+	Queued  int    `json:"queued"`
+}
+
+//Pipeline stage pools of transforms, transform holds slot of current stage only,
+//so slow downloads don't block transforms and vice versa
+//stages are connected by bounded queues (queue len = stage workers),
+//transform holds slot of current stage till it gets place in the queue of next stage (back pressure),
+//slot of current stage is released once transform enters the queue
+//shared transforms budget is taken by transform stage
+//stopped pipeline (service shutdown) doesn't let transforms to next stage, except publish (transform is done)
+type Pipeline struct {
+	pools  [stageCount]stagePool
+	budget *Budget
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+type stagePool struct {
+	slots chan struct{}
+	queue chan struct{}
+}
+
+//NewPipeline creates stage pools, not set limits are taken from threads
+func NewPipeline(limits StageLimits, threads int) *Pipeline {
+	if threads < 1 {
+		threads = 1
+	}
+	p := &Pipeline{stopCh: make(chan struct{})}
+	for s, n := range [stageCount]int{limits.Download, limits.Extract, limits.Transform, limits.Publish} {
+		if n < 1 {
+			n = threads
+		}
+		p.pools[s] = stagePool{slots: make(chan struct{}, n), queue: make(chan struct{}, n)}
+	}
+	return p
+}
+
+//capacity max transforms held by pipeline (in slots or in queues)
+func (p *Pipeline) capacity() int {
+	var res int
+	for _, pl := range p.pools {
+		res += cap(pl.slots) + cap(pl.queue)
+	}
+	return res
+}
+
+//stop stops transforms at stage boundary
+func (p *Pipeline) stop() {
+	if p == nil {
+		return
+	}
+	p.stopOnce.Do(func() {
+		close(p.stopCh)
+	})
+}
+
+//stopped checks if pipeline is stopped
+func (p *Pipeline) stopped() bool {
+	if p == nil {
+		return false
+	}
+	select {
+	case <-p.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+//enter moves transform to stage s, blocks till stage slot is free
+//slot of current stage is released once transform enters queue of stage s
+//returns false if transform context is done or pipeline is stopped, slot of current stage is still held only
+//if it happens before transform enters the queue (it's released by leave)
+func (p *Pipeline) enter(t *Transform, s Stage) bool {
+	if p == nil || (t.staged && t.stage == s) {
+		return true
+	}
+	//done transform is published anyway
+	stop := p.stopCh
+	if s == StagePublish {
+		stop = nil
+	}
+	if stop != nil && p.stopped() {
+		return false
+	}
+	pl := &p.pools[s]
+	//waite place in stage queue, holding current slot
+	select {
+	case pl.queue <- struct{}{}:
+	case <-t.ctx.Done():
+		return false
+	case <-stop:
+		return false
+	}
+	//in queue, release current slot and waite stage slot
+	p.leave(t)
+	select {
+	case pl.slots <- struct{}{}:
+		<-pl.queue
+	case <-t.ctx.Done():
+		<-pl.queue
+		return false
+	case <-stop:
+		<-pl.queue
+		return false
+	}
+	t.stage, t.staged = s, true
+	if s == StageTransform {
+		if !p.budget.acquire(t.ctx) {
+			p.leave(t)
+			return false
+		}
+		t.budgeted = true
+	}
+	return true
+}
+
+//leave releases stage slot (and budget) of transform
+func (p *Pipeline) leave(t *Transform) {
+	if p == nil || !t.staged {
+		return
+	}
+	if t.budgeted {
+		p.budget.release()
+		t.budgeted = false
+	}
+	<-p.pools[t.stage].slots
+	t.staged = false
+}
+
+//Info returns stage pools state
+func (p *Pipeline) Info() []StageInfo {
+	res := make([]StageInfo, 0, stageCount)
+	for s, pl := range p.pools {
+		res = append(res, StageInfo{
+			Name:    Stage(s).String(),
+			Workers: cap(pl.slots),
+			Busy:    len(pl.slots),
+			Queued:  len(pl.queue),
+		})
+	}
+	return res
+}
@@ -0,0 +1,106 @@
+package transform
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+
+	pc "github.com/egorka-gh/pixlpark/photocycle"
+)
+
+func newStageTransform(ctx context.Context) *Transform {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Transform{Done: make(chan struct{}), ctx: ctx, cancel: cancel}
+}
+
+func TestPipeline_enter(t *testing.T) {
+	b := NewBudget(1)
+	p := NewPipeline(StageLimits{Download: 1, Transform: 1}, 2)
+	p.budget = b
+	if p.capacity() != 12 {
+		t.Errorf("capacity() = %d, want 12", p.capacity())
+	}
+
+	//slow download doesn't block transform stage
+	d := newStageTransform(context.Background())
+	if !p.enter(d, StageDownload) {
+		t.Fatal("enter download failed")
+	}
+	tr := newStageTransform(context.Background())
+	if !p.enter(tr, StageTransform) || b.InUse() != 1 {
+		t.Fatal("enter transform failed")
+	}
+
+	//stage is busy, transform waits in queue till context is done
+	d2 := newStageTransform(context.Background())
+	time.AfterFunc(20*time.Millisecond, d2.cancel)
+	if p.enter(d2, StageDownload) {
+		t.Error("enter busy stage must block till ctx is done")
+	}
+	if inf := p.Info()[StageDownload]; inf.Busy != 1 || inf.Queued != 0 {
+		t.Errorf("download info = %+v, want busy 1 queued 0", inf)
+	}
+
+	//moving to next stage releases previous slot and budget
+	if !p.enter(tr, StagePublish) || b.InUse() != 0 {
+		t.Error("enter publish failed or budget not released")
+	}
+	if inf := p.Info()[StageTransform]; inf.Busy != 0 {
+		t.Errorf("transform stage busy = %d, want 0", inf.Busy)
+	}
+	p.leave(tr)
+	p.leave(d)
+	for _, inf := range p.Info() {
+		if inf.Busy != 0 || inf.Queued != 0 {
+			t.Errorf("stage %s is not released %+v", inf.Name, inf)
+		}
+	}
+
+	var np *Pipeline
+	if !np.enter(d2, StageDownload) {
+		t.Error("nil pipeline must not block")
+	}
+	np.leave(d2)
+}
+
+func TestPipeline_stop(t *testing.T) {
+	p := NewPipeline(StageLimits{}, 1)
+	tr := newStageTransform(context.Background())
+	if !p.enter(tr, StageExtract) {
+		t.Fatal("enter extract failed")
+	}
+	p.stop()
+	p.stop()
+	if p.enter(tr, StageTransform) {
+		t.Error("stopped pipeline must not let to transform stage")
+	}
+	if inf := p.Info()[StageExtract]; inf.Busy != 1 {
+		t.Errorf("stopped transform must keep its stage, busy = %d", inf.Busy)
+	}
+	if !p.enter(tr, StagePublish) {
+		t.Error("stopped pipeline must let to publish stage")
+	}
+}
+
+func Test_staged(t *testing.T) {
+	rep := &claimRepository{claims: make(map[string]testClaim)}
+	fc := NewSourceFactory(&testSource{}, rep, 23, 0, t.TempDir(), "", "", "", nil).(*baseFactory)
+	p := NewPipeline(StageLimits{}, 1)
+	fc.SetPipeline(p)
+	p.stop()
+
+	tr := newStageTransform(context.Background())
+	tr.logger = log.NewNopLogger()
+	tr.pcBaseOrder = pc.Order{ID: "23_1@"}
+	fc.run(tr, fc.staged(StageTransform, func(t *Transform) stateFunc {
+		panic("stopped transform must not run stage")
+	}))
+	if _, ok := tr.Err().(ErrStopped); !ok {
+		t.Errorf("transform error %v, want ErrStopped", tr.Err())
+	}
+	if !rep.hasState(pc.StateTransform) {
+		t.Error("stop stage is not logged")
+	}
+}
@@ -0,0 +1,422 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"golang.org/x/image/draw"
+
+	pc "github.com/egorka-gh/pixlpark/photocycle"
+)
+
+//Preflight image normalisation settings
+type Preflight struct {
+	//Enabled preflight stage is skipped if false
+	Enabled bool
+	//ThumbFolder root folder for preview thumbnails and reports (ThumbFolder/ppOrderID/...), empty - no thumbnails
+	ThumbFolder string
+	//ThumbSize max side of thumbnail in px
+	ThumbSize int
+	//Quality jpeg quality of converted files
+	Quality int
+}
+
+//PreflightChange describes preflight result for one file
+type PreflightChange struct {
+	//File path relative to order folder
+	File string `json:"file"`
+	//Result new file path if file was converted
+	Result string `json:"result,omitempty"`
+	//Actions performed on file, empty if file is not changed
+	Actions []string `json:"actions,omitempty"`
+	//Thumb thumbnail path relative to order thumb folder
+	Thumb string `json:"thumb,omitempty"`
+	//Err file can't be processed, file is left as is
+	Err string `json:"error,omitempty"`
+}
+
+//PreflightReport is preflight result of order, saved to ThumbFolder/ppOrderID/preflight.json
+type PreflightReport struct {
+	OrderID string            `json:"order_id"`
+	Date    time.Time         `json:"date"`
+	Changed int               `json:"changed"`
+	Files   []PreflightChange `json:"files"`
+}
+
+const preflightReportName = "preflight.json"
+
+//SetPreflight sets preflight stage settings
+func (fc *baseFactory) SetPreflight(p Preflight) {
+	if p.ThumbSize <= 0 {
+		p.ThumbSize = 300
+	}
+	if p.Quality <= 0 || p.Quality > 100 {
+		p.Quality = 95
+	}
+	fc.preflightCfg = p
+}
+
+//preflight normalises unziped images (EXIF orientation, unsupported formats to sRGB JPEG) and creates thumbnails
+//optional stage, just forwards to transformItems if disabled
+//in/out states: statePixelLoadStarted in PP and StateUnzip in cycle
+func (fc *baseFactory) preflight(t *Transform) stateFunc {
+	if !fc.preflightCfg.Enabled {
+		return fc.staged(StageTransform, fc.transformItems)
+	}
+	started := time.Now()
+	logger := log.With(t.logger, "stage", "preflight")
+	logger.Log("event", "start")
+
+	basePath := filepath.Join(fc.wrkFolder, t.ppOrder.ID)
+	if !folderExists(basePath) {
+		//restarted after zip streaming, unzip first
+		logger.Log("event", "unzip")
+		return fc.unzip
+	}
+	thumbPath := ""
+	if fc.preflightCfg.ThumbFolder != "" {
+		thumbPath = filepath.Join(fc.preflightCfg.ThumbFolder, t.ppOrder.ID)
+		if err := recreateFolder(thumbPath); err != nil {
+			logger.Log("error", err.Error())
+			t.err = ErrFileSystem{err}
+			_ = fc.setCycleState(t, pc.StateUnzip, pc.StateErrFileSystem, t.err.Error())
+			return fc.closeTransform
+		}
+	}
+
+	report := PreflightReport{OrderID: t.ppOrder.ID, Date: started, Files: make([]PreflightChange, 0)}
+	err := filepath.Walk(basePath, func(fp string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		//check if transform context is canceled
+		select {
+		case <-t.ctx.Done():
+			return t.ctx.Err()
+		default:
+		}
+		if fi.IsDir() || !allowedExt[filepath.Ext(fi.Name())] || strings.Contains(fi.Name(), "_preview.") {
+			return nil
+		}
+		rel, _ := filepath.Rel(basePath, fp)
+		ch, err := fc.preflightFile(fp, rel, thumbPath)
+		if err != nil {
+			return err
+		}
+		if len(ch.Actions) > 0 {
+			report.Changed++
+		}
+		report.Files = append(report.Files, ch)
+		return nil
+	})
+	if err != nil {
+		if t.ctx.Err() != nil {
+			t.err = t.ctx.Err()
+			return fc.closeTransform
+		}
+		logger.Log("error", err.Error())
+		t.err = ErrFileSystem{err}
+		_ = fc.setCycleState(t, pc.StateUnzip, pc.StateErrFileSystem, t.err.Error())
+		return fc.closeTransform
+	}
+
+	if thumbPath != "" {
+		if err = writeReport(filepath.Join(thumbPath, preflightReportName), report); err != nil {
+			logger.Log("warning", fmt.Sprintf("Preflight report error:%s", err.Error()))
+		}
+	}
+	msg := fmt.Sprintf("preflight files=%d; changed=%d; time=%s", len(report.Files), report.Changed, time.Since(started).String())
+	_ = fc.setCycleState(t, 0, pc.StateUnzip, msg)
+	logger.Log("event", "end", "files", len(report.Files), "changed", report.Changed, "time", time.Since(started).String())
+	return fc.staged(StageTransform, fc.transformItems)
+}
+
+//preflightFile normalises one file, returns error only on file system errors
+func (fc *baseFactory) preflightFile(fp, rel, thumbPath string) (PreflightChange, error) {
+	ch := PreflightChange{File: filepath.ToSlash(rel)}
+	data, err := ioutil.ReadFile(fp)
+	if err != nil {
+		return ch, err
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		ch.Err = err.Error()
+		return ch, nil
+	}
+
+	orientation := 1
+	if format == "jpeg" {
+		orientation = exifOrientation(data)
+	}
+	convert := needConvert(format, cfg.ColorModel)
+	if orientation == 1 && !convert && thumbPath == "" {
+		return ch, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		ch.Err = err.Error()
+		return ch, nil
+	}
+	if orientation != 1 || convert {
+		var rgb *image.RGBA
+		if convert {
+			rgb = toRGB(img)
+			ch.Actions = append(ch.Actions, fmt.Sprintf("%s %s -> sRGB jpeg", format, colorModelName(cfg.ColorModel)))
+		}
+		if orientation != 1 {
+			if rgb == nil {
+				rgb = toRGB(img)
+			}
+			rgb = orient(rgb, orientation)
+			ch.Actions = append(ch.Actions, fmt.Sprintf("exif orientation %d", orientation))
+		}
+		img = rgb
+		//always jpeg, keep name if it is jpeg allready
+		out := fp
+		if format != "jpeg" {
+			out = strings.TrimSuffix(fp, filepath.Ext(fp)) + ".jpg"
+			if _, err = os.Stat(out); err == nil {
+				ch.Actions = nil
+				ch.Err = fmt.Sprintf("file %s allready exists", filepath.Base(out))
+				return ch, nil
+			}
+		}
+		if err = writeJPEG(out, img, fc.preflightCfg.Quality); err != nil {
+			return ch, err
+		}
+		if out != fp {
+			if err = os.Remove(fp); err != nil {
+				return ch, err
+			}
+			ch.Result = filepath.ToSlash(strings.TrimSuffix(rel, filepath.Ext(rel)) + ".jpg")
+		}
+	}
+
+	if thumbPath != "" {
+		thumbRel := strings.TrimSuffix(rel, filepath.Ext(rel)) + ".jpg"
+		tfp := filepath.Join(thumbPath, thumbRel)
+		if err = os.MkdirAll(filepath.Dir(tfp), 0755); err != nil {
+			return ch, err
+		}
+		if err = writeJPEG(tfp, thumbnail(img, fc.preflightCfg.ThumbSize), 80); err != nil {
+			return ch, err
+		}
+		ch.Thumb = filepath.ToSlash(thumbRel)
+	}
+	return ch, nil
+}
+
+//needConvert checks if lab can't print image as is
+func needConvert(format string, cm color.Model) bool {
+	switch format {
+	case "jpeg":
+		return cm == color.CMYKModel
+	case "png":
+		return cm == color.RGBA64Model || cm == color.NRGBA64Model || cm == color.Gray16Model
+	}
+	//tiff, bmp, gif
+	return true
+}
+
+func colorModelName(cm color.Model) string {
+	switch cm {
+	case color.CMYKModel:
+		return "CMYK"
+	case color.RGBA64Model, color.NRGBA64Model:
+		return "16bit"
+	case color.Gray16Model:
+		return "16bit gray"
+	case color.GrayModel:
+		return "gray"
+	}
+	if _, ok := cm.(color.Palette); ok {
+		return "paletted"
+	}
+	return "RGB"
+}
+
+//toRGB converts image to 8bit RGB, transparent pixels are flattened on white
+//CMYK is converted without colour profile (naive conversion)
+func toRGB(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Over)
+	return dst
+}
+
+//orient applies EXIF orientation (2-8), returns image in normal orientation
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 && orientation <= 8 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+			si := src.PixOffset(x, y)
+			di := dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
+
+//thumbnail scales image to fit size x size
+func thumbnail(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	if w >= h {
+		h = h * size / w
+		w = size
+	} else {
+		w = w * size / h
+		h = size
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+//writeJPEG writes image via temp file to keep source intact on error
+func writeJPEG(fp string, img image.Image, quality int) (err error) {
+	tmp := fp + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+	if err = jpeg.Encode(f, img, &jpeg.Options{Quality: quality}); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fp)
+}
+
+func writeReport(fp string, report PreflightReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fp, data, 0644)
+}
+
+//LoadPreflightReport reads order preflight report from thumbs folder
+func LoadPreflightReport(thumbFolder, orderID string) (PreflightReport, error) {
+	var report PreflightReport
+	data, err := ioutil.ReadFile(filepath.Join(thumbFolder, orderID, preflightReportName))
+	if err != nil {
+		return report, err
+	}
+	err = json.Unmarshal(data, &report)
+	return report, err
+}
+
+//exifOrientation reads orientation tag from jpeg EXIF, returns 1 if not found
+func exifOrientation(data []byte) int {
+	//SOI
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		//SOS or EOI, no more metadata
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if size < 2 || i+2+size > len(data) {
+			return 1
+		}
+		seg := data[i+4 : i+2+size]
+		if marker == 0xE1 && len(seg) > 14 && string(seg[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(seg[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+//tiffOrientation reads orientation (0x0112) from IFD0 of EXIF tiff structure
+func tiffOrientation(tiff []byte) int {
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	n := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < n; e++ {
+		p := ifd + 2 + e*12
+		if p+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[p:]) == 0x0112 {
+			o := int(order.Uint16(tiff[p+8:]))
+			if o < 1 || o > 8 {
+				return 1
+			}
+			return o
+		}
+	}
+	return 1
+}
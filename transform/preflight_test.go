@@ -0,0 +1,171 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//exifJPEG encodes w x h jpeg with EXIF orientation tag (big endian tiff)
+func exifJPEG(t *testing.T, w, h, orientation int) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+	var tiff bytes.Buffer
+	tiff.WriteString("MM")
+	binary.Write(&tiff, binary.BigEndian, uint16(42))
+	binary.Write(&tiff, binary.BigEndian, uint32(8))
+	binary.Write(&tiff, binary.BigEndian, uint16(1))
+	//tag, type SHORT, count 1, value
+	binary.Write(&tiff, binary.BigEndian, uint16(0x0112))
+	binary.Write(&tiff, binary.BigEndian, uint16(3))
+	binary.Write(&tiff, binary.BigEndian, uint32(1))
+	binary.Write(&tiff, binary.BigEndian, uint16(orientation))
+	binary.Write(&tiff, binary.BigEndian, uint16(0))
+	binary.Write(&tiff, binary.BigEndian, uint32(0))
+
+	app1 := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	var res bytes.Buffer
+	res.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	binary.Write(&res, binary.BigEndian, uint16(len(app1)+2))
+	res.Write(app1)
+	res.Write(buf.Bytes()[2:])
+	return res.Bytes()
+}
+
+func Test_exifOrientation(t *testing.T) {
+	for _, o := range []int{1, 3, 6, 8} {
+		if got := exifOrientation(exifJPEG(t, 4, 2, o)); got != o {
+			t.Errorf("exifOrientation() = %d, want %d", got, o)
+		}
+	}
+	if got := exifOrientation([]byte("not a jpeg")); got != 1 {
+		t.Errorf("exifOrientation() = %d, want 1", got)
+	}
+}
+
+func Test_orient(t *testing.T) {
+	//2x1 red, blue
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+	src.Set(0, 0, red)
+	src.Set(1, 0, blue)
+
+	got := orient(src, 6)
+	if got.Bounds().Dx() != 1 || got.Bounds().Dy() != 2 {
+		t.Fatalf("orient(6) bounds = %v", got.Bounds())
+	}
+	//rotated clockwise, left pixel goes to top
+	if got.RGBAAt(0, 0) != red || got.RGBAAt(0, 1) != blue {
+		t.Errorf("orient(6) = %v, %v", got.RGBAAt(0, 0), got.RGBAAt(0, 1))
+	}
+	got = orient(src, 3)
+	if got.RGBAAt(0, 0) != blue || got.RGBAAt(1, 0) != red {
+		t.Errorf("orient(3) = %v, %v", got.RGBAAt(0, 0), got.RGBAAt(1, 0))
+	}
+}
+
+func Test_preflightFile(t *testing.T) {
+	wrk := t.TempDir()
+	thumbs := t.TempDir()
+	fc := &baseFactory{}
+	fc.SetPreflight(Preflight{Enabled: true, ThumbFolder: thumbs, ThumbSize: 50})
+
+	write := func(name string, data []byte) string {
+		fp := filepath.Join(wrk, name)
+		if err := ioutil.WriteFile(fp, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return fp
+	}
+	encode := func(enc func(*bytes.Buffer) error) []byte {
+		var buf bytes.Buffer
+		if err := enc(&buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	plain := write("plain.jpg", encode(func(b *bytes.Buffer) error {
+		return jpeg.Encode(b, image.NewRGBA(image.Rect(0, 0, 200, 100)), nil)
+	}))
+	rotated := write("rotated.jpg", exifJPEG(t, 200, 100, 6))
+	png16 := write("deep.png", encode(func(b *bytes.Buffer) error {
+		return png.Encode(b, image.NewRGBA64(image.Rect(0, 0, 20, 10)))
+	}))
+	gifFile := write("anim.gif", encode(func(b *bytes.Buffer) error {
+		return gif.Encode(b, image.NewPaletted(image.Rect(0, 0, 20, 10), color.Palette{color.White, color.Black}), nil)
+	}))
+
+	//not changed, thumbnail only
+	ch, err := fc.preflightFile(plain, "plain.jpg", thumbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ch.Actions) != 0 || ch.Thumb != "plain.jpg" {
+		t.Errorf("plain: %+v", ch)
+	}
+	if cfg := decodeConfig(t, filepath.Join(thumbs, "plain.jpg")); cfg.Width != 50 || cfg.Height != 25 {
+		t.Errorf("thumb size %dx%d", cfg.Width, cfg.Height)
+	}
+
+	//rotated in place
+	ch, err = fc.preflightFile(rotated, "rotated.jpg", thumbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ch.Actions) != 1 || ch.Result != "" {
+		t.Errorf("rotated: %+v", ch)
+	}
+	if cfg := decodeConfig(t, rotated); cfg.Width != 100 || cfg.Height != 200 {
+		t.Errorf("rotated size %dx%d", cfg.Width, cfg.Height)
+	}
+	if o := exifOrientation(readFile(t, rotated)); o != 1 {
+		t.Errorf("rotated orientation %d", o)
+	}
+
+	//converted to jpeg
+	for _, fp := range []string{png16, gifFile} {
+		rel := filepath.Base(fp)
+		ch, err = fc.preflightFile(fp, rel, thumbs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jpg := rel[:len(rel)-len(filepath.Ext(rel))] + ".jpg"
+		if len(ch.Actions) != 1 || ch.Result != jpg {
+			t.Errorf("%s: %+v", rel, ch)
+		}
+		if _, err = os.Stat(fp); !os.IsNotExist(err) {
+			t.Errorf("%s: original not removed", rel)
+		}
+		if _, format, err := image.DecodeConfig(bytes.NewReader(readFile(t, filepath.Join(wrk, jpg)))); err != nil || format != "jpeg" {
+			t.Errorf("%s: converted format %s, err %v", rel, format, err)
+		}
+	}
+}
+
+func readFile(t *testing.T, fp string) []byte {
+	data, err := ioutil.ReadFile(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func decodeConfig(t *testing.T, fp string) image.Config {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(readFile(t, fp)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
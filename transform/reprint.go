@@ -0,0 +1,374 @@
+package transform
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	pc "github.com/egorka-gh/pixlpark/photocycle"
+	"github.com/egorka-gh/pixlpark/storage"
+)
+
+//ReprintRequest sheets or books of published order to reprint
+type ReprintRequest struct {
+	//OrderID cycle order (pp order item) ID
+	OrderID string `json:"order_id"`
+	//Books book (copy) numbers, empty - all books
+	Books []int `json:"books"`
+	//Sheets sheet numbers (photo print - print number in print group), empty - all sheets
+	Sheets []int `json:"sheets"`
+}
+
+//Reprint rebuilds requested files of published order from work folder, archive or reloaded zip
+//and creates reprint print groups in cycle
+func (fc *baseFactory) Reprint(ctx context.Context, r ReprintRequest) ([]pc.PrintGroup, error) {
+	if len(r.Books) == 0 && len(r.Sheets) == 0 {
+		return nil, ErrParce{errors.New("Не указаны книги или листы для перепечатки")}
+	}
+	order, err := fc.pcClient.LoadOrder(ctx, r.OrderID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTransform{fmt.Errorf("Заказ %s не найден", r.OrderID)}
+		}
+		return nil, ErrRepository{err}
+	}
+	//sub order SourceID is ppOrderID-itemID (see transformItems)
+	i := strings.LastIndex(order.SourceID, "-")
+	if i <= 0 {
+		return nil, ErrParce{fmt.Errorf("Заказ %s не является элементом заказа", r.OrderID)}
+	}
+	ppID := order.SourceID[:i]
+	itemID, err := strconv.Atoi(order.SourceID[i+1:])
+	if err != nil {
+		return nil, ErrParce{fmt.Errorf("Заказ %s не является элементом заказа", r.OrderID)}
+	}
+	items, err := fc.src.GetOrderItems(ctx, ppID)
+	if err != nil {
+		return nil, ErrService{err}
+	}
+	idx := -1
+	for j, it := range items {
+		if it.ID == itemID {
+			idx = j
+			break
+		}
+	}
+	if idx == -1 {
+		return nil, ErrTransform{fmt.Errorf("Элемент заказа %s не найден на сайте", order.SourceID)}
+	}
+	item := items[idx]
+
+	groups, err := fc.pcClient.LoadPrintGroups(ctx, order.ID)
+	if err != nil {
+		return nil, ErrRepository{err}
+	}
+
+	src, release, err := fc.reprintSource(ctx, ppID)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	//rebuild file lists same way as transform does
+	var res []pc.PrintGroup
+	var lists [][]fileCopy
+	alias, list, _, err := fc.aliasFiles(ctx, src, &item, &order)
+	if err == nil {
+		if alias.ForwardState > 0 {
+			return nil, ErrTransform{fmt.Errorf("Элемент заказа %s не печатается (%s)", order.SourceID, alias.Alias)}
+		}
+		res, lists, err = reprintBooks(&order, groups, alias, list, item.Quantity, r)
+	} else if _, ok := err.(ErrCantTransform); ok {
+		var prints photoPrints
+		prints, _, err = fc.photoFiles(ctx, src, &item)
+		if err == nil {
+			res, lists, err = reprintPhotos(&order, groups, prints, r)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, ErrTransform{errors.New("Нет файлов для перепечатки")}
+	}
+
+	//photo prints are copied to cycle print folder/orderid/pg.Path/print
+	//book sheets are copied to cycle wrk folder/orderid/pg.Path/alias, cycle preprocess builds print files (same as transformAlias)
+	pubs := make([]*storage.Publication, 0, len(res))
+	defer func() {
+		for _, p := range pubs {
+			p.Abort()
+		}
+	}()
+	for j, pg := range res {
+		root, sub := fc.cyclePrtFolder, "print"
+		if pg.State == pc.StatePreprocessWaite {
+			root, sub = fc.cycleFolder, alias.Alias
+		}
+		pub, err := storage.Publish(fc.out, path.Join(root, order.FtpFolder, pg.Path))
+		if err != nil {
+			return nil, ErrFileSystem{err}
+		}
+		pubs = append(pubs, pub)
+		if _, err = fc.copyList(ctx, src, lists[j], path.Join(pub.Dir, sub), ""); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range pubs {
+		if err = p.Commit(); err != nil {
+			return nil, ErrFileSystem{err}
+		}
+	}
+
+	if err = fc.pcClient.AddPrintGroups(ctx, res); err != nil {
+		return nil, ErrRepository{err}
+	}
+	for _, pg := range res {
+		msg := fmt.Sprintf("Перепечатка группы %s: группа %s, книги %s, листы %s", pg.ReprintOf, pg.ID, joinNumbers(r.Books), joinNumbers(r.Sheets))
+		fc.pcClient.LogState(ctx, order.ID, pg.State, msg)
+	}
+	return res, nil
+}
+
+//reprintBooks selects book files by request, returns reprint print groups (cover, block) and their files
+//files are source sheets, groups are created in StatePreprocessWaite to be built by cycle preprocess
+func reprintBooks(order *pc.Order, groups []pc.PrintGroup, alias pc.Alias, list []fileCopy, quantity int, r ReprintRequest) ([]pc.PrintGroup, [][]fileCopy, error) {
+	//book -> sheet -> file, book 0 - sheets common for all books
+	byBook := make(map[int]map[int]fileCopy)
+	sheetSet := make(map[int]bool)
+	for _, fi := range list {
+		if !fi.Process {
+			continue
+		}
+		if byBook[fi.BookIdx] == nil {
+			byBook[fi.BookIdx] = make(map[int]fileCopy)
+		}
+		byBook[fi.BookIdx][fi.SheetIdx] = fi
+		sheetSet[fi.SheetIdx] = true
+	}
+	if quantity < 1 {
+		quantity = 1
+	}
+	books := r.Books
+	if len(books) == 0 {
+		for b := 1; b <= quantity; b++ {
+			books = append(books, b)
+		}
+	}
+	sheets := r.Sheets
+	if len(sheets) == 0 {
+		for s := range sheetSet {
+			sheets = append(sheets, s)
+		}
+		sort.Ints(sheets)
+	}
+
+	var cover, block []fileCopy
+	for _, b := range books {
+		if b < 1 || b > quantity {
+			return nil, nil, ErrParce{fmt.Errorf("Книга %d вне диапазона 1-%d", b, quantity)}
+		}
+		for _, s := range sheets {
+			fi, ok := byBook[b][s]
+			if !ok {
+				fi, ok = byBook[0][s]
+			}
+			if !ok {
+				return nil, nil, ErrParce{fmt.Errorf("Лист %d книги %d не найден", s, b)}
+			}
+			//NewName is '000-00...', set book
+			fi.BookIdx = b
+			fi.NewName = fmt.Sprintf("%03d%s", b, fi.NewName[3:])
+			if alias.HasCover && s == 0 {
+				cover = append(cover, fi)
+			} else {
+				block = append(block, fi)
+			}
+		}
+	}
+
+	var res []pc.PrintGroup
+	var lists [][]fileCopy
+	for part, files := range [][]fileCopy{cover, block} {
+		if len(files) == 0 {
+			continue
+		}
+		isCover := part == 0
+		var orig *pc.PrintGroup
+		for i := range groups {
+			g := &groups[i]
+			if g.ReprintOf != "" {
+				continue
+			}
+			if (g.BookPart == pc.BookPartBlock) != isCover {
+				orig = g
+				break
+			}
+		}
+		if orig == nil {
+			part := "блока"
+			if isCover {
+				part = "обложки"
+			}
+			return nil, nil, ErrTransform{fmt.Errorf("Группа печати %s не найдена, заказ %s не подготовлен в cycle", part, order.ID)}
+		}
+		pg := newReprintGroup(order, *orig, len(groups)+len(res)+1)
+		pg.State = pc.StatePreprocessWaite
+		bookSet := make(map[int]bool)
+		sheetSet := make(map[int]bool)
+		for _, fi := range files {
+			pg.Files = append(pg.Files, pc.PrintGroupFile{PrintGroupID: pg.ID, FileName: path.Join(alias.Alias, fi.NewName), PrintQtty: 1, Book: fi.BookIdx, Page: fi.SheetIdx, Caption: fi.OldName, BookPart: pg.BookPart})
+			bookSet[fi.BookIdx] = true
+			sheetSet[fi.SheetIdx] = true
+		}
+		pg.FileNum = len(files)
+		pg.Prints = len(files)
+		pg.BookNum = len(bookSet)
+		pg.SheetNum = len(sheetSet)
+		res = append(res, pg)
+		lists = append(lists, files)
+	}
+	return res, lists, nil
+}
+
+//reprintPhotos selects photo prints by request (sheet - print number in print group)
+func reprintPhotos(order *pc.Order, groups []pc.PrintGroup, prints photoPrints, r ReprintRequest) ([]pc.PrintGroup, [][]fileCopy, error) {
+	if len(r.Books) > 0 && len(r.Sheets) == 0 {
+		return nil, nil, ErrParce{errors.New("Для фотопечати укажите номера отпечатков (листы)")}
+	}
+	sheets := make(map[int]bool)
+	for _, s := range r.Sheets {
+		sheets[s] = true
+	}
+	var res []pc.PrintGroup
+	var lists [][]fileCopy
+	for i, list := range prints.lists {
+		if len(list) == 0 {
+			continue
+		}
+		pg := prints.printGroup(order, i)
+		var files []fileCopy
+		n := 0
+		for _, fi := range list {
+			if !fi.Process {
+				continue
+			}
+			n++
+			if sheets[n] {
+				files = append(files, fi)
+			}
+		}
+		if len(files) == 0 {
+			continue
+		}
+		origID := ""
+		for _, g := range groups {
+			if g.ReprintOf == "" && g.Path == pg.Path {
+				origID = g.ID
+				break
+			}
+		}
+		pg = newReprintGroup(order, pg, len(groups)+len(res)+1)
+		pg.ReprintOf = origID
+		for _, fi := range files {
+			pg.Files = append(pg.Files, pc.PrintGroupFile{PrintGroupID: pg.ID, FileName: path.Join("print", fi.NewName), Caption: fi.OldName, PrintQtty: fi.Qtty})
+			pg.Prints += fi.Qtty
+		}
+		pg.FileNum = len(files)
+		res = append(res, pg)
+		lists = append(lists, files)
+	}
+	return res, lists, nil
+}
+
+//newReprintGroup creates reprint print group vs params of original group, files not set
+func newReprintGroup(order *pc.Order, orig pc.PrintGroup, num int) pc.PrintGroup {
+	pg := orig
+	pg.ID = fmt.Sprintf("%s_%d", order.ID, num)
+	pg.OrderID = order.ID
+	pg.ReprintOf = orig.ID
+	pg.Path = fmt.Sprintf("reprint_%d", num)
+	pg.State = pc.StatePrintWaite
+	pg.Files = nil
+	pg.FileNum = 0
+	pg.Prints = 0
+	return pg
+}
+
+//reprintSource opens order source files: work folder, archive or zip loaded from pixlpark
+//release closes source and removes files restored or loaded for reprint
+func (fc *baseFactory) reprintSource(ctx context.Context, orderID string) (*orderSource, func(), error) {
+	if src, err := fc.openSource(orderID); err == nil {
+		return src, func() { src.Close() }, nil
+	}
+	restored := false
+	if fc.archive != nil {
+		_, err := fc.archive.Restore(orderID, fc.wrkFolder)
+		restored = err == nil
+	}
+	if !restored {
+		if err := fc.download(ctx, orderID); err != nil {
+			return nil, nil, err
+		}
+	}
+	src := folderSource(path.Join(fc.wrkFolder, orderID))
+	return src, func() {
+		src.Close()
+		if fc.Debug {
+			return
+		}
+		zipPath := filepath.Join(fc.wrkFolder, orderID+".zip")
+		if !restored && fc.archive != nil {
+			fc.archive.Put(orderID, zipPath)
+		} else {
+			os.Remove(zipPath)
+		}
+		os.RemoveAll(path.Join(fc.wrkFolder, orderID))
+	}, nil
+}
+
+//download loads order zip from order source and unzips it to work folder
+func (fc *baseFactory) download(ctx context.Context, orderID string) error {
+	order, err := fc.src.GetOrder(ctx, orderID)
+	if err != nil {
+		return ErrService{err}
+	}
+	fl := filepath.Join(fc.wrkFolder, orderID+".zip")
+	if err = os.Remove(fl); err != nil && !os.IsNotExist(err) {
+		return ErrFileSystem{err}
+	}
+	loader, err := fc.src.Download(ctx, order, fl, fc.rateLimiter())
+	if err != nil {
+		return ErrService{err}
+	}
+	if err = loader.Err(); err != nil {
+		return ErrService{err}
+	}
+	basePath := path.Join(fc.wrkFolder, orderID)
+	if err = recreateFolder(basePath); err != nil {
+		return ErrFileSystem{err}
+	}
+	if err = extractZip(fl, basePath); err != nil {
+		return ErrService{err}
+	}
+	return nil
+}
+
+//joinNumbers formats numbers list for log, empty - all
+func joinNumbers(nums []int) string {
+	if len(nums) == 0 {
+		return "все"
+	}
+	res := make([]string, 0, len(nums))
+	for _, n := range nums {
+		res = append(res, strconv.Itoa(n))
+	}
+	return strings.Join(res, ",")
+}
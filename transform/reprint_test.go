@@ -0,0 +1,92 @@
+package transform
+
+import (
+	"testing"
+
+	pc "github.com/egorka-gh/pixlpark/photocycle"
+)
+
+func Test_reprintBooks(t *testing.T) {
+	order := &pc.Order{ID: "23_100-0"}
+	groups := []pc.PrintGroup{
+		{ID: "23_100-0_1", BookPart: pc.BookPartCover, Path: "cover", Width: 300},
+		{ID: "23_100-0_2", BookPart: pc.BookPartBlock, Path: "block", Width: 200},
+	}
+	alias := pc.Alias{Alias: "book", HasCover: true}
+	//legacy layout: common sheets (book 0) + last sheet of last book
+	list := []fileCopy{
+		{OldName: "surface_[0].jpg", NewName: "000-00_309_5.jpg", SheetIdx: 0, Process: true},
+		{OldName: "surface_[1].jpg", NewName: "000-01.jpg", SheetIdx: 1, Process: true},
+		{OldName: "surface_[2].jpg", NewName: "000-02.jpg", SheetIdx: 2, Process: true},
+		{OldName: "surface_[2].jpg", NewName: "003-02.jpg", SheetIdx: 2, BookIdx: 3, Process: true},
+		{OldName: "surface_[3]_preview.jpg", SheetIdx: 3},
+	}
+
+	res, lists, err := reprintBooks(order, groups, alias, list, 3, ReprintRequest{Books: []int{2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || len(lists) != 2 {
+		t.Fatalf("reprintBooks() = %d groups, want cover and block", len(res))
+	}
+	cover, block := res[0], res[1]
+	if cover.ReprintOf != "23_100-0_1" || cover.Width != 300 || cover.ID != "23_100-0_3" || cover.State != pc.StatePreprocessWaite {
+		t.Errorf("cover group = %+v", cover)
+	}
+	if block.ReprintOf != "23_100-0_2" || block.ID != "23_100-0_4" || block.FileNum != 2 || block.BookNum != 1 {
+		t.Errorf("block group = %+v", block)
+	}
+	if lists[0][0].NewName != "002-00_309_5.jpg" || cover.Files[0].FileName != "book/002-00_309_5.jpg" {
+		t.Errorf("cover file = %s", lists[0][0].NewName)
+	}
+
+	//last book has own last sheet
+	_, lists, err = reprintBooks(order, groups, alias, list, 3, ReprintRequest{Books: []int{3}, Sheets: []int{2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lists) != 1 || lists[0][0].NewName != "003-02.jpg" || lists[0][0].BookIdx != 3 {
+		t.Errorf("lists = %+v", lists)
+	}
+
+	//errors
+	if _, _, err = reprintBooks(order, groups, alias, list, 3, ReprintRequest{Books: []int{4}}); err == nil {
+		t.Error("book out of range: expected error")
+	}
+	if _, _, err = reprintBooks(order, groups, alias, list, 3, ReprintRequest{Sheets: []int{5}}); err == nil {
+		t.Error("missing sheet: expected error")
+	}
+	if _, _, err = reprintBooks(order, groups[1:], alias, list, 3, ReprintRequest{Sheets: []int{0}}); err == nil {
+		t.Error("not prepared in cycle: expected error")
+	}
+}
+
+func Test_reprintPhotos(t *testing.T) {
+	order := &pc.Order{ID: "23_100-1"}
+	prints := photoPrints{
+		format:     pc.PhotoFormat{PrintWidth: 102, PrintHeight: 152, PrintPaper: 10, Cutting: 20, NBCutting: 19},
+		correction: "N",
+	}
+	prints.lists[0] = []fileCopy{
+		{OldName: "a.jpg", NewName: "1WN_0000.jpg", Qtty: 1, Process: true},
+		{OldName: "b.txt", Qtty: 1},
+		{OldName: "c.jpg", NewName: "2WN_0002.jpg", Qtty: 2, Process: true},
+	}
+	groups := []pc.PrintGroup{{ID: "23_100-1_1", Path: "w102-h152-p10-f_b"}}
+
+	res, lists, err := reprintPhotos(order, groups, prints, ReprintRequest{Sheets: []int{2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || len(lists[0]) != 1 || lists[0][0].OldName != "c.jpg" {
+		t.Fatalf("reprintPhotos() = %+v", lists)
+	}
+	pg := res[0]
+	if pg.ReprintOf != "23_100-1_1" || pg.ID != "23_100-1_2" || pg.Prints != 2 || pg.Cutting != 20 || pg.Path != "reprint_2" {
+		t.Errorf("print group = %+v", pg)
+	}
+
+	if _, _, err = reprintPhotos(order, groups, prints, ReprintRequest{Books: []int{1}}); err == nil {
+		t.Error("books for photo: expected error")
+	}
+}
@@ -0,0 +1,113 @@
+package transform
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	pc "github.com/egorka-gh/pixlpark/photocycle"
+)
+
+//RetransformItem rebuilds one pp order item into its cycle order (<source>_<pp order id>-<n>)
+//sibling orders and their cycle folders are not touched
+//refuses if cycle order has moved past StatePrintWaite, cycle order is created if it not exists (item was dropped)
+func (fc *baseFactory) RetransformItem(ctx context.Context, orderID string) (pc.Order, error) {
+	source, ppID, n, err := parseItemID(orderID)
+	if err != nil {
+		return pc.Order{}, err
+	}
+	if source != fc.source {
+		return pc.Order{}, ErrParce{fmt.Errorf("Заказ %s другого источника", orderID)}
+	}
+	old, err := fc.pcClient.LoadOrder(ctx, orderID)
+	if err != nil && err != sql.ErrNoRows {
+		return pc.Order{}, ErrRepository{err}
+	}
+	exists := err == nil
+	if exists && old.State > pc.StatePrintWaite {
+		return pc.Order{}, ErrTransform{fmt.Errorf("Заказ %s уже в работе (статус %d)", orderID, old.State)}
+	}
+
+	po, err := fc.src.GetOrder(ctx, ppID)
+	if err != nil {
+		return pc.Order{}, ErrService{err}
+	}
+	items, err := fc.src.GetOrderItems(ctx, ppID)
+	if err != nil {
+		return pc.Order{}, ErrService{err}
+	}
+	if n >= len(items) {
+		return pc.Order{}, ErrTransform{fmt.Errorf("Элемент заказа %s не найден на сайте", orderID)}
+	}
+	item := items[n]
+	base := fromPPOrder(&po, fc.source, "@")
+
+	src, release, err := fc.reprintSource(ctx, ppID)
+	if err != nil {
+		return pc.Order{}, err
+	}
+	defer release()
+
+	co, warnings, err := fc.transformItem(ctx, src, &po, &item, n)
+	if err != nil {
+		msg := fmt.Sprintf("Элемент заказа %s '%s' не обработан. Ошибка %s", co.SourceID, item.Name, err.Error())
+		fc.pcClient.LogState(ctx, base.ID, pc.StateErrPreprocess, msg)
+		return pc.Order{}, err
+	}
+
+	//replace cycle order, existing order is kept if insert fails
+	//state is checked again in transaction, cycle could start printing while item was rebuilt
+	if exists {
+		err = fc.pcClient.ReplaceOrder(ctx, co, pc.StatePrintWaite)
+	} else {
+		err = fc.pcClient.FillOrders(ctx, []pc.Order{co})
+	}
+	if err == sql.ErrNoRows {
+		return pc.Order{}, ErrTransform{fmt.Errorf("Заказ %s уже в работе", orderID)}
+	}
+	if err != nil {
+		return pc.Order{}, ErrRepository{err}
+	}
+	//forward intermediate state, siblings are in work states already
+	if err = fc.pcClient.StartOrders(ctx, fc.source, co.GroupID, base.ID); err != nil {
+		return pc.Order{}, ErrRepository{err}
+	}
+
+	msg := fmt.Sprintf("Элемент заказа %s '%s' подготовлен повторно", co.SourceID, item.Name)
+	if len(warnings) > 0 {
+		msg = fmt.Sprintf("%s. Предупреждения: %s", msg, joinWarnings(warnings))
+	}
+	fc.pcClient.LogState(ctx, base.ID, pc.StateTransform, msg)
+	fc.logger.Log("retransform", orderID, "item", item.ID)
+	if co, err = fc.pcClient.LoadOrder(ctx, orderID); err != nil {
+		return co, ErrRepository{err}
+	}
+	return co, nil
+}
+
+//parseItemID parses cycle order id of pp order item (<source>_<pp order id>-<n>)
+func parseItemID(id string) (source int, ppID string, n int, err error) {
+	errID := ErrParce{fmt.Errorf("Заказ %s не является элементом заказа", id)}
+	j := strings.Index(id, "_")
+	if j <= 0 {
+		return 0, "", 0, errID
+	}
+	if source, err = strconv.Atoi(id[:j]); err != nil {
+		return 0, "", 0, errID
+	}
+	s := id[j+1:]
+	i := strings.LastIndex(s, "-")
+	if i <= 0 {
+		return 0, "", 0, errID
+	}
+	n, err = strconv.Atoi(s[i+1:])
+	if err != nil || n < 0 {
+		return 0, "", 0, errID
+	}
+	if _, err = strconv.Atoi(s[:i]); err != nil {
+		return 0, "", 0, errID
+	}
+	return source, s[:i], n, nil
+}
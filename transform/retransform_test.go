@@ -0,0 +1,45 @@
+package transform
+
+import (
+	"context"
+	"testing"
+
+	pc "github.com/egorka-gh/pixlpark/photocycle"
+)
+
+func Test_parseItemID(t *testing.T) {
+	tests := []struct {
+		id      string
+		source  int
+		ppID    string
+		n       int
+		wantErr bool
+	}{
+		{"23_100500-2", 23, "100500", 2, false},
+		{"23_100500-0", 23, "100500", 0, false},
+		{"24_100500-2", 24, "100500", 2, false},
+		{"23_100500@", 0, "", 0, true},
+		{"23_100500", 0, "", 0, true},
+		{"23_abc-1", 0, "", 0, true},
+		{"x_100500-2", 0, "", 0, true},
+	}
+	for _, tt := range tests {
+		source, ppID, n, err := parseItemID(tt.id)
+		if (err != nil) != tt.wantErr || source != tt.source || ppID != tt.ppID || n != tt.n {
+			t.Errorf("parseItemID(%s) = %d, %s, %d, %v", tt.id, source, ppID, n, err)
+		}
+	}
+}
+
+func TestRetransformItem_printed(t *testing.T) {
+	rep := &testRepository{orders: map[string]pc.Order{
+		"23_100-1": {ID: "23_100-1", State: pc.StatePrint},
+	}}
+	fc := NewSourceFactory(&testSource{}, rep, 23, 0, t.TempDir(), "", "", "", nil)
+	if _, err := fc.RetransformItem(context.Background(), "23_100-1"); err == nil {
+		t.Error("order in print: expected error")
+	}
+	if _, err := fc.RetransformItem(context.Background(), "24_100-1"); err == nil {
+		t.Error("order of other source: expected error")
+	}
+}
@@ -0,0 +1,115 @@
+package transform
+
+import (
+	"strings"
+
+	pp "github.com/egorka-gh/pixlpark/pixlpark/service"
+)
+
+//Routing production routing settings, maps pixlpark orders to cycle productions
+type Routing struct {
+	//Rules are checked in order, first matched rule wins
+	Rules []RouteRule
+	//Default cycle production of orders not matched by rules, 0 - not matched orders are excluded
+	Default int
+}
+
+//RouteRule maps pixlpark order (item) to cycle production, empty conditions match any order
+//sku only rule doesn't accept orders (items are unknown in queue), it routes items of orders accepted by other rule or Default
+type RouteRule struct {
+	//Production cycle production
+	Production int
+	//AssignedTo pixlpark production (order AssignedToId)
+	AssignedTo int
+	//Region shipping region, compared vs delivery country, state or city (case insensitive)
+	Region string
+	//Sku item sku values (name -> value), item level condition
+	Sku map[string]string
+}
+
+//SetRouting sets production routing, if routing is empty
+//orders are filtered by factory pixlpark production and cycle production is not set
+func (fc *baseFactory) SetRouting(r Routing) {
+	fc.routing = r
+}
+
+//hasRouting checks if routing is set
+func (r Routing) hasRouting() bool {
+	return len(r.Rules) > 0 || r.Default > 0
+}
+
+//matchOrder checks order level conditions (AssignedTo, Region)
+func (rr RouteRule) matchOrder(o *pp.Order) bool {
+	if rr.AssignedTo > 0 && rr.AssignedTo != o.ProductionID {
+		return false
+	}
+	if rr.Region != "" {
+		a := o.DeliveryAddress
+		if !strings.EqualFold(rr.Region, a.Country) && !strings.EqualFold(rr.Region, a.State) && !strings.EqualFold(rr.Region, a.City) {
+			return false
+		}
+	}
+	return true
+}
+
+//matchItem checks item level conditions (Sku)
+func (rr RouteRule) matchItem(item *pp.OrderItem) bool {
+	if len(rr.Sku) == 0 {
+		return true
+	}
+	if item == nil {
+		return false
+	}
+	sku := item.Sku()
+	for k, v := range rr.Sku {
+		if !strings.EqualFold(sku[k], v) {
+			return false
+		}
+	}
+	return true
+}
+
+//skuOnly checks if rule has item level conditions only
+func (rr RouteRule) skuOnly() bool {
+	return len(rr.Sku) > 0 && rr.AssignedTo == 0 && rr.Region == ""
+}
+
+//accepts checks if order is routed to some cycle production (by order level conditions)
+//items of accepted order still can be not routed (see route), they are failed by transformItems
+func (fc *baseFactory) accepts(o *pp.Order) bool {
+	if !fc.routing.hasRouting() {
+		return fc.production <= 0 || o.ProductionID == fc.production
+	}
+	if fc.routing.Default > 0 {
+		return true
+	}
+	for _, rr := range fc.routing.Rules {
+		if !rr.skuOnly() && rr.matchOrder(o) {
+			return true
+		}
+	}
+	return false
+}
+
+//route returns cycle production of order item, 0 - not routed
+func (fc *baseFactory) route(o *pp.Order, item *pp.OrderItem) int {
+	for _, rr := range fc.routing.Rules {
+		if rr.matchOrder(o) && rr.matchItem(item) {
+			return rr.Production
+		}
+	}
+	return fc.routing.Default
+}
+
+//routeQueue excludes orders not routed to factory productions
+//pixlpark api can't filter orders by production (GetOrders filters by status, user and shipping only),
+//so queue is filtered after load
+func (fc *baseFactory) routeQueue(orders []pp.Order) []pp.Order {
+	res := orders[:0]
+	for _, o := range orders {
+		if fc.accepts(&o) {
+			res = append(res, o)
+		}
+	}
+	return res
+}
@@ -0,0 +1,68 @@
+package transform
+
+import (
+	"testing"
+
+	pp "github.com/egorka-gh/pixlpark/pixlpark/service"
+)
+
+func TestRouting(t *testing.T) {
+	fc := &baseFactory{production: 5}
+	orders := []pp.Order{
+		{ID: "1", ProductionID: 5},
+		{ID: "2", ProductionID: 6, DeliveryAddress: pp.DeliveryAddress{City: "Минск"}},
+		{ID: "3", ProductionID: 7},
+	}
+	//legacy, filter by pixlpark production
+	if q := fc.routeQueue(append([]pp.Order{}, orders...)); len(q) != 1 || q[0].ID != "1" {
+		t.Errorf("routeQueue() = %+v, want 1", q)
+	}
+	if p := fc.route(&orders[0], nil); p != 0 {
+		t.Errorf("route() = %d, want 0", p)
+	}
+
+	fc.SetRouting(Routing{Rules: []RouteRule{
+		{Production: 10, AssignedTo: 5, Sku: map[string]string{"paper": "matt"}},
+		{Production: 11, AssignedTo: 5},
+		{Production: 12, Region: "минск"},
+	}})
+	q := fc.routeQueue(append([]pp.Order{}, orders...))
+	if len(q) != 2 || q[0].ID != "1" || q[1].ID != "2" {
+		t.Errorf("routeQueue() = %+v, want 1, 2", q)
+	}
+	matt := pp.OrderItem{SkuItems: []pp.OrderItemSku{{Name: "paper", Value: "Matt"}}}
+	glossy := pp.OrderItem{SkuItems: []pp.OrderItemSku{{Name: "paper", Value: "glossy"}}}
+	for _, tt := range []struct {
+		order *pp.Order
+		item  *pp.OrderItem
+		want  int
+	}{
+		{&orders[0], &matt, 10},
+		{&orders[0], &glossy, 11},
+		{&orders[0], nil, 11},
+		{&orders[1], &matt, 12},
+		{&orders[2], &matt, 0},
+	} {
+		if got := fc.route(tt.order, tt.item); got != tt.want {
+			t.Errorf("route(%s) = %d, want %d", tt.order.ID, got, tt.want)
+		}
+	}
+
+	//sku only rule doesn't accept orders
+	fc.SetRouting(Routing{Rules: []RouteRule{
+		{Production: 10, Sku: map[string]string{"paper": "matt"}},
+		{Production: 11, AssignedTo: 6},
+	}})
+	if q = fc.routeQueue(append([]pp.Order{}, orders...)); len(q) != 1 || q[0].ID != "2" {
+		t.Errorf("routeQueue() = %+v, want 2", q)
+	}
+	if p := fc.route(&orders[1], &matt); p != 10 {
+		t.Errorf("route() = %d, want 10", p)
+	}
+
+	//default production accepts all
+	fc.SetRouting(Routing{Default: 20})
+	if q = fc.routeQueue(append([]pp.Order{}, orders...)); len(q) != 3 || fc.route(&orders[2], nil) != 20 {
+		t.Errorf("routeQueue() = %+v, want all", q)
+	}
+}
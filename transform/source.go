@@ -0,0 +1,219 @@
+package transform
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/egorka-gh/pixlpark/storage"
+)
+
+//CopyOptions sets how order files are delivered to cycle folders
+type CopyOptions struct {
+	//StreamZip read files directly from order zip (unzip stage is skipped), not used if preflight is enabled
+	StreamZip bool
+	//HardLinks link files instead of copy if source and target are on the same volume
+	HardLinks bool
+	//Workers parallel copy workers
+	Workers int
+	//ItemWorkers parallel transformed items of one order
+	ItemWorkers int
+}
+
+//SetCopyOptions sets files delivery settings
+func (fc *baseFactory) SetCopyOptions(o CopyOptions) {
+	if o.Workers <= 0 {
+		o.Workers = 1
+	}
+	if o.ItemWorkers <= 0 {
+		o.ItemWorkers = 1
+	}
+	fc.copyOpt = o
+}
+
+//streamZip returns true if unzip stage is skipped
+func (fc *baseFactory) streamZip() bool {
+	return fc.copyOpt.StreamZip && !fc.preflightCfg.Enabled
+}
+
+//orderSource order files, unziped work folder or order zip
+//paths in fsys are relative to order root (item folders)
+type orderSource struct {
+	fsys fs.FS
+	//dir os path of fsys root, empty if files are read from zip
+	dir string
+	zip *zip.ReadCloser
+}
+
+//folderSource returns source of os folder
+func folderSource(dir string) *orderSource {
+	return &orderSource{fsys: os.DirFS(dir), dir: dir}
+}
+
+//zipSource opens order zip, zip root folder is source root
+func zipSource(fileName string) (*orderSource, error) {
+	zr, err := zip.OpenReader(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var fsys fs.FS = zr
+	if root := zipRoot(&zr.Reader); root != "" {
+		if fsys, err = fs.Sub(zr, root); err != nil {
+			zr.Close()
+			return nil, err
+		}
+	}
+	return &orderSource{fsys: fsys, zip: zr}, nil
+}
+
+//zipRoot returns root folder of zip
+//expected that zip has 1 root folder vs sub folder for each order item (see unzip)
+func zipRoot(zr *zip.Reader) string {
+	for _, f := range zr.File {
+		name := strings.TrimLeft(f.Name, "/")
+		if i := strings.Index(name, "/"); i > 0 {
+			return name[:i]
+		}
+	}
+	return ""
+}
+
+//Close releases zip
+func (s *orderSource) Close() error {
+	if s.zip != nil {
+		return s.zip.Close()
+	}
+	return nil
+}
+
+//openSource opens order files, unziped folder if exists, else order zip if zip streaming is enabled
+func (fc *baseFactory) openSource(orderID string) (*orderSource, error) {
+	dir := path.Join(fc.wrkFolder, orderID)
+	if folderExists(dir) {
+		return folderSource(dir), nil
+	}
+	if !fc.streamZip() {
+		return nil, ErrSourceNotFound{fmt.Errorf("Folder not found '%s'", dir)}
+	}
+	src, err := zipSource(filepath.Join(fc.wrkFolder, orderID+".zip"))
+	if err != nil {
+		return nil, ErrSourceNotFound{err}
+	}
+	return src, nil
+}
+
+//copyList copies (links) list files vs Process to toFolder of output storage
+//if linkFrom is set files are linked from linkFrom folder of output storage (already delivered vs same NewName)
+//files are copied by CopyOptions.Workers in parallel
+func (fc *baseFactory) copyList(ctx context.Context, src *orderSource, list []fileCopy, toFolder, linkFrom string) (done int, err error) {
+	if err = fc.out.MkdirAll(toFolder); err != nil {
+		return 0, ErrFileSystem{err}
+	}
+	workers := fc.copyOpt.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	var copyErr error
+	var wg sync.WaitGroup
+	jobs := make(chan fileCopy)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fi := range jobs {
+				if err := fc.deliver(src, fi, path.Join(toFolder, fi.NewName), linkFrom); err != nil {
+					mu.Lock()
+					if copyErr == nil {
+						copyErr = err
+					}
+					mu.Unlock()
+					cancel()
+				}
+			}
+		}()
+	}
+feed:
+	for _, fi := range list {
+		if !fi.Process {
+			continue
+		}
+		select {
+		case jobs <- fi:
+			done++
+		case <-cctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if copyErr != nil {
+		return 0, ErrFileSystem{copyErr}
+	}
+	//check if transform context is canceled
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+	return done, nil
+}
+
+//deliver copies source file to dst of output storage
+//tries hard link if enabled: from linkFrom folder or from source folder if output storage is local
+func (fc *baseFactory) deliver(src *orderSource, fi fileCopy, dst, linkFrom string) (err error) {
+	name := path.Join(fi.OldPath, fi.OldName)
+	if fc.copyOpt.HardLinks {
+		if linkFrom != "" {
+			if err = fc.out.Link(path.Join(linkFrom, fi.NewName), dst); err == nil {
+				return nil
+			}
+		} else if src.dir != "" && storage.IsLocal(fc.out) {
+			if err = fc.out.Link(path.Join(filepath.ToSlash(src.dir), name), dst); err == nil {
+				return nil
+			}
+		}
+		//other volume or links are not supported, just copy
+	}
+
+	in, err := src.fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	sfi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if !sfi.Mode().IsRegular() {
+		return fmt.Errorf("CopyFile: non-regular source file %s (%q)", sfi.Name(), sfi.Mode().String())
+	}
+
+	out, err := fc.out.Create(dst)
+	if err != nil {
+		return
+	}
+	defer func() {
+		cerr := out.Close()
+		if err == nil {
+			err = cerr
+		}
+	}()
+	_, err = io.Copy(out, in)
+	return
+}
+
+//isNotExist reports whether err means that folder or file does not exist (os or zip)
+func isNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
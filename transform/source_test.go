@@ -0,0 +1,227 @@
+package transform
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/egorka-gh/pixlpark/storage"
+)
+
+//writeOrderZip creates order zip vs root folder orderID and item folder "1" with files
+func writeOrderZip(t testing.TB, dir, orderID string, files, size int) string {
+	fn := filepath.Join(dir, orderID+".zip")
+	f, err := os.Create(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	rnd := rand.New(rand.NewSource(1))
+	data := make([]byte, size)
+	for i := 0; i < files; i++ {
+		w, err := zw.Create(fmt.Sprintf("%s/1/surface_[%d].jpg", orderID, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rnd.Read(data)
+		if _, err = w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+//unzipAll extracts zip like unzip stage
+func unzipAll(t testing.TB, fn, basePath string) {
+	zr, err := zip.OpenReader(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	for _, file := range zr.File {
+		fp := replaceRootPath(file.Name, basePath)
+		if err = os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+			t.Fatal(err)
+		}
+		r, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := os.Create(fp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.Copy(w, r)
+		r.Close()
+		w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_orderSource(t *testing.T) {
+	wrk := t.TempDir()
+	out := t.TempDir()
+	fn := writeOrderZip(t, wrk, "123", 5, 1024)
+	fc := &baseFactory{wrkFolder: wrk, out: storage.NewLocal()}
+	fc.SetCopyOptions(CopyOptions{StreamZip: true, HardLinks: true, Workers: 3})
+
+	//no unziped folder, zip is streamed
+	src, err := fc.openSource("123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if src.zip == nil {
+		t.Fatal("openSource() expected zip source")
+	}
+	list, err := fillList(src.fsys, "1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 5 {
+		t.Fatalf("fillList() = %d files, want 5", len(list))
+	}
+	for i := range list {
+		list[i].NewName = fmt.Sprintf("001-%02d.jpg", i)
+	}
+	first := filepath.Join(out, "in")
+	done, err := fc.copyList(context.Background(), src, list, first, "")
+	if err != nil || done != 5 {
+		t.Fatalf("copyList() = %d, %v", done, err)
+	}
+
+	//second target is linked
+	second := filepath.Join(out, "print")
+	if done, err = fc.copyList(context.Background(), src, list, second, first); err != nil || done != 5 {
+		t.Fatalf("copyList() = %d, %v", done, err)
+	}
+
+	//compare vs unziped
+	unzipAll(t, fn, filepath.Join(wrk, "123"))
+	for _, fi := range list {
+		want := readFile(t, filepath.Join(wrk, "123", "1", fi.OldName))
+		if got := readFile(t, filepath.Join(first, fi.NewName)); !bytes.Equal(got, want) {
+			t.Errorf("%s: streamed content differs", fi.OldName)
+		}
+		s1, _ := os.Stat(filepath.Join(first, fi.NewName))
+		s2, _ := os.Stat(filepath.Join(second, fi.NewName))
+		if !os.SameFile(s1, s2) {
+			t.Errorf("%s: not linked", fi.NewName)
+		}
+	}
+
+	//unziped folder has priority
+	src2, err := fc.openSource("123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src2.Close()
+	if src2.zip != nil || src2.dir == "" {
+		t.Error("openSource() expected folder source")
+	}
+
+	//missing source
+	if _, err = fc.openSource("404"); err == nil {
+		t.Error("openSource() expected error")
+	} else if _, ok := err.(ErrSourceNotFound); !ok {
+		t.Errorf("openSource() error %T, want ErrSourceNotFound", err)
+	}
+}
+
+func Test_copyList_canceled(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.jpg"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fc := &baseFactory{out: storage.NewLocal()}
+	fc.SetCopyOptions(CopyOptions{Workers: 2})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	list := []fileCopy{{OldPath: ".", OldName: "a.jpg", NewName: "b.jpg", Process: true}}
+	if _, err := fc.copyList(ctx, folderSource(dir), list, filepath.Join(dir, "out"), ""); err != context.Canceled {
+		t.Errorf("copyList() error = %v, want context.Canceled", err)
+	}
+}
+
+const (
+	benchFiles = 20
+	benchSize  = 1 << 20
+)
+
+//BenchmarkUnzipCopy unzip to work folder, then copy to in and print folders (photo print path before streaming)
+func BenchmarkUnzipCopy(b *testing.B) {
+	wrk := b.TempDir()
+	fn := writeOrderZip(b, wrk, "1", benchFiles, benchSize)
+	b.SetBytes(benchFiles * benchSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		out := filepath.Join(wrk, "out")
+		base := filepath.Join(wrk, "unzip")
+		unzipAll(b, fn, base)
+		for _, target := range []string{"in", "print"} {
+			if err := recreateFolder(filepath.Join(out, target)); err != nil {
+				b.Fatal(err)
+			}
+			for j := 0; j < benchFiles; j++ {
+				name := fmt.Sprintf("surface_[%d].jpg", j)
+				if err := copyFile(filepath.Join(base, "1", name), filepath.Join(out, target, name)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+		os.RemoveAll(base)
+		os.RemoveAll(out)
+	}
+}
+
+func benchmarkStream(b *testing.B, opt CopyOptions) {
+	wrk := b.TempDir()
+	writeOrderZip(b, wrk, "1", benchFiles, benchSize)
+	fc := &baseFactory{wrkFolder: wrk, out: storage.NewLocal()}
+	fc.SetCopyOptions(opt)
+	b.SetBytes(benchFiles * benchSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		out := filepath.Join(wrk, "out")
+		src, err := fc.openSource("1")
+		if err != nil {
+			b.Fatal(err)
+		}
+		list, err := fillList(src.fsys, "1", 1)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err = fc.copyList(context.Background(), src, list, path.Join(out, "in"), ""); err != nil {
+			b.Fatal(err)
+		}
+		if _, err = fc.copyList(context.Background(), src, list, path.Join(out, "print"), path.Join(out, "in")); err != nil {
+			b.Fatal(err)
+		}
+		src.Close()
+		os.RemoveAll(out)
+	}
+}
+
+//BenchmarkStreamCopy streams from zip to in folder, links to print folder
+func BenchmarkStreamCopy(b *testing.B) {
+	benchmarkStream(b, CopyOptions{StreamZip: true, HardLinks: true, Workers: 4})
+}
+
+//BenchmarkStreamCopySerial streams from zip by one worker, streams to print folder
+func BenchmarkStreamCopySerial(b *testing.B) {
+	benchmarkStream(b, CopyOptions{StreamZip: true, Workers: 1})
+}
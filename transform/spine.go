@@ -0,0 +1,152 @@
+package transform
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	pc "github.com/egorka-gh/pixlpark/photocycle"
+	pp "github.com/egorka-gh/pixlpark/pixlpark/service"
+	"github.com/spf13/viper"
+)
+
+//SpineParams book components that define spine thickness
+type SpineParams struct {
+	Paper      string //sku paper
+	Binding    string //alias book type
+	EndPaper   string //sku endpaper, empty - no endpaper
+	InterLayer string //sku interlayer, empty - no interlayer
+	Sheets     int    //inner sheets (without cover)
+}
+
+//Spine book spine and cover geometry (mm)
+type Spine struct {
+	//Editor thickness by pp editor output, 0 - not set
+	Editor float64
+	//Calculated thickness by thickness table, 0 - can't calculate
+	Calculated float64
+	//Butt spine used for cover (rounded, vs butt_add)
+	Butt float64
+	//Width cover spread width, 0 - unknown
+	Width float64
+	//Warning editor and calculated thickness disagreement
+	Warning string
+}
+
+//thicknessTable book components thickness,
+//loaded from cycle DB (pp_book_thickness) or from config (book.thickness) if DB has no rows
+type thicknessTable struct {
+	mu     sync.Mutex
+	rows   []pc.BookThickness
+	loaded time.Time
+}
+
+func (t *thicknessTable) load(ctx context.Context, rep pc.Repository) ([]pc.BookThickness, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.rows == nil || time.Since(t.loaded) > catalogTTL {
+		rows, err := rep.LoadBookThickness(ctx)
+		if err != nil || len(rows) == 0 {
+			rows = []pc.BookThickness{}
+			if err = viper.UnmarshalKey("book.thickness", &rows); err != nil {
+				return nil, ErrTransform{fmt.Errorf("Ошибка чтения таблицы толщин: %s", err.Error())}
+			}
+		}
+		t.rows = rows
+		t.loaded = time.Now()
+	}
+	return t.rows, nil
+}
+
+//thickness calculates book thickness, returns false if paper is not in table
+func (t *thicknessTable) thickness(ctx context.Context, rep pc.Repository, p SpineParams) (float64, bool, error) {
+	rows, err := t.load(ctx, rep)
+	if err != nil {
+		return 0, false, err
+	}
+	paper, ok := lookupThickness(rows, pc.ThicknessPaper, p.Paper)
+	if !ok || p.Sheets <= 0 {
+		return 0, false, nil
+	}
+	res := paper * float64(p.Sheets)
+	if p.InterLayer != "" {
+		v, _ := lookupThickness(rows, pc.ThicknessInterLayer, p.InterLayer)
+		res += v * float64(p.Sheets)
+	}
+	if p.EndPaper != "" {
+		v, _ := lookupThickness(rows, pc.ThicknessEndPaper, p.EndPaper)
+		res += v
+	}
+	v, _ := lookupThickness(rows, pc.ThicknessBinding, p.Binding)
+	res += v
+	return math.Round(res*100) / 100, true, nil
+}
+
+//lookupThickness finds value by kind and code, row vs empty code matches any code
+func lookupThickness(rows []pc.BookThickness, kind, code string) (float64, bool) {
+	anyCode := -1
+	for i, r := range rows {
+		if r.Kind != kind {
+			continue
+		}
+		if r.Code == code {
+			return r.Value, true
+		}
+		if r.Code == "" && anyCode == -1 {
+			anyCode = i
+		}
+	}
+	if anyCode != -1 {
+		return rows[anyCode].Value, true
+	}
+	return 0, false
+}
+
+//spine calculates spine and cover spread width
+//editor thickness is used if set (cover is designed for it), disagreement vs table above book.thicknessTolerance is reported as warning
+//fixed sku butt is used if editor has no thickness, calculated thickness otherwise
+func (fc *baseFactory) spine(ctx context.Context, item *pp.OrderItem, p SpineParams) (Spine, error) {
+	s := Spine{Editor: item.Sizes.Thickness, Width: item.Sizes.Width}
+	calc, ok, err := fc.thickness.thickness(ctx, fc.pcClient, p)
+	if err != nil {
+		return s, err
+	}
+	if ok {
+		s.Calculated = calc
+	}
+
+	var thickness float64
+	switch {
+	case s.Editor > 0:
+		thickness = s.Editor
+		tolerance := viper.GetFloat64("book.thicknessTolerance")
+		if s.Calculated > 0 && tolerance > 0 && math.Abs(s.Editor-s.Calculated) > tolerance {
+			s.Warning = fmt.Sprintf("Толщина блока редактора %.1f мм, расчетная %.1f мм (бумага %s, разворотов %d)", s.Editor, s.Calculated, p.Paper, p.Sheets)
+		}
+	case item.Sku()["butt"] != "":
+		//book vs fixed butt/width
+		if bf, err := strconv.ParseFloat(item.Sku()["butt"], 64); err == nil {
+			s.Butt = bf
+		}
+	default:
+		thickness = s.Calculated
+	}
+	if thickness > 0 {
+		//round up butt to 2
+		s.Butt = math.Ceil(thickness/2.0) * 2.0
+		//correct width
+		if s.Width > 0 {
+			s.Width = s.Width - s.Editor + s.Butt
+		}
+	}
+	//butt static correction
+	if ba := item.Sku()["butt_add"]; ba != "" {
+		if baf, err := strconv.ParseFloat(ba, 64); err == nil {
+			s.Butt += baf
+		}
+	}
+	return s, nil
+}
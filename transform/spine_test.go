@@ -0,0 +1,114 @@
+package transform
+
+import (
+	"context"
+	"testing"
+
+	pc "github.com/egorka-gh/pixlpark/photocycle"
+	pp "github.com/egorka-gh/pixlpark/pixlpark/service"
+	"github.com/spf13/viper"
+)
+
+type thicknessRepository struct {
+	pc.Repository
+	rows []pc.BookThickness
+}
+
+func (r *thicknessRepository) LoadBookThickness(ctx context.Context) ([]pc.BookThickness, error) {
+	return r.rows, nil
+}
+
+func Test_baseFactory_spine(t *testing.T) {
+	viper.Set("book.thicknessTolerance", 1.0)
+	defer viper.Set("book.thicknessTolerance", nil)
+	fc := &baseFactory{
+		pcClient: &thicknessRepository{rows: []pc.BookThickness{
+			{Kind: pc.ThicknessPaper, Code: "10", Value: 0.5},
+			{Kind: pc.ThicknessPaper, Code: "11", Value: 0.8},
+			{Kind: pc.ThicknessInterLayer, Code: "", Value: 0.2},
+			{Kind: pc.ThicknessEndPaper, Code: "", Value: 1},
+			{Kind: pc.ThicknessBinding, Code: "1", Value: 3},
+		}},
+		thickness: &thicknessTable{},
+	}
+	item := func(width, thickness float64, sku ...string) *pp.OrderItem {
+		it := &pp.OrderItem{Sizes: pp.OrderItemSizes{Width: width, Thickness: thickness}}
+		opt := pp.OrderItemOption{}
+		for i := 0; i+1 < len(sku); i += 2 {
+			opt.SkuItems = append(opt.SkuItems, pp.OrderItemSku{Name: sku[i], Value: sku[i+1]})
+		}
+		it.Options = []pp.OrderItemOption{opt}
+		return it
+	}
+	tests := []struct {
+		name      string
+		item      *pp.OrderItem
+		params    SpineParams
+		wantCalc  float64
+		wantButt  float64
+		wantWidth float64
+		warning   bool
+	}{
+		{
+			name:      "calculated when editor has no thickness",
+			item:      item(400, 0),
+			params:    SpineParams{Paper: "10", Binding: "1", Sheets: 10},
+			wantCalc:  8,
+			wantButt:  8,
+			wantWidth: 408,
+		},
+		{
+			name:      "endpaper and interlayer",
+			item:      item(400, 0),
+			params:    SpineParams{Paper: "10", Binding: "2", EndPaper: "white", InterLayer: "karton", Sheets: 10},
+			wantCalc:  8,
+			wantButt:  8,
+			wantWidth: 408,
+		},
+		{
+			name:      "editor agrees",
+			item:      item(409, 9),
+			params:    SpineParams{Paper: "10", Binding: "1", Sheets: 11},
+			wantCalc:  8.5,
+			wantButt:  10,
+			wantWidth: 410,
+		},
+		{
+			name:      "editor disagrees",
+			item:      item(405, 5),
+			params:    SpineParams{Paper: "11", Binding: "1", Sheets: 10},
+			wantCalc:  11,
+			wantButt:  6,
+			wantWidth: 406,
+			warning:   true,
+		},
+		{
+			name:      "fixed sku butt",
+			item:      item(400, 0, "butt", "7", "butt_add", "1"),
+			params:    SpineParams{Paper: "10", Binding: "1", Sheets: 10},
+			wantCalc:  8,
+			wantButt:  8,
+			wantWidth: 400,
+		},
+		{
+			name:      "unknown paper",
+			item:      item(400, 0),
+			params:    SpineParams{Paper: "99", Binding: "1", Sheets: 10},
+			wantWidth: 400,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fc.spine(context.Background(), tt.item, tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Calculated != tt.wantCalc || got.Butt != tt.wantButt || got.Width != tt.wantWidth {
+				t.Errorf("spine() = %+v, want calculated %v, butt %v, width %v", got, tt.wantCalc, tt.wantButt, tt.wantWidth)
+			}
+			if (got.Warning != "") != tt.warning {
+				t.Errorf("spine() warning = %q, want %v", got.Warning, tt.warning)
+			}
+		})
+	}
+}
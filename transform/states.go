@@ -1,14 +1,11 @@
 package transform
 
-import (
-	pp "github.com/egorka-gh/pixlpark/pixlpark/service"
-)
-
+//order source states used by transform
 const (
-	statePixelStartLoad    = pp.StateReadyToProcessing
-	statePixelLoadStarted  = pp.StatePrepressCoordination
-	statePixelWaiteConfirm = pp.StatePrepressCoordinationAwaitingReply
-	//statePixelConfirmed    = pp.StatePrepressCoordinationComplete
+	statePixelStartLoad    = StatusReady
+	statePixelLoadStarted  = StatusLoading
+	statePixelWaiteConfirm = StatusAwaitingReply
+	statePixelConfirmed    = StatusConfirmed
 
-	statePixelAbort = pp.StatePrintedWithDefect
+	statePixelAbort = StatusDefect
 )
@@ -5,48 +5,156 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"io/fs"
 	"math"
 	"path"
 	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	pc "github.com/egorka-gh/pixlpark/photocycle"
 	pp "github.com/egorka-gh/pixlpark/pixlpark/service"
+	"github.com/egorka-gh/pixlpark/storage"
 	"github.com/spf13/viper"
 )
 
+//photoPrints photo item files by print groups, files have cycle names
+type photoPrints struct {
+	format     pc.PhotoFormat
+	correction string
+	//lists files with borders, files without borders
+	lists [2][]fileCopy
+}
+
+//printGroup returns print group of list i (0 - with borders, 1 - without borders), files not set
+func (p photoPrints) printGroup(order *pc.Order, i int) pc.PrintGroup {
+	pg := pc.PrintGroup{
+		OrderID: order.ID,
+		Paper:   p.format.PrintPaper,
+		Width:   p.format.PrintWidth,
+		Height:  p.format.PrintHeight,
+		State:   order.State,
+	}
+	if i == 0 {
+		//photos with border
+		pg.Cutting = p.format.Cutting
+		pg.Frame = p.format.Frame
+		pg.Path = fmt.Sprintf("w%d-h%d-p%d-f_b", p.format.PrintWidth, p.format.PrintHeight, p.format.PrintPaper)
+	} else {
+		//photos without border
+		pg.Cutting = p.format.NBCutting
+		pg.Frame = p.format.NBFrame
+		pg.Path = fmt.Sprintf("w%d-h%d-p%d-f_n", p.format.PrintWidth, p.format.PrintHeight, p.format.PrintPaper)
+	}
+	//set color correction code
+	if p.correction == "Y" {
+		pg.Correction = 16
+	}
+	return pg
+}
+
 //can't detect exactly if item is photo product
 //if something goes wrong just says errCantTransform
 //TODO add alias to mark photo products?
-func (fc *baseFactory) transformPhoto(ctx context.Context, item *pp.OrderItem, order *pc.Order) error {
+//returns per file warnings (image doesn't fit print format), warnings don't stop transform
+func (fc *baseFactory) transformPhoto(ctx context.Context, src *orderSource, item *pp.OrderItem, order *pc.Order) ([]string, error) {
+	prints, warnings, err := fc.photoFiles(ctx, src, item)
+	if err != nil {
+		return nil, err
+	}
+
+	//copy & create order printgroup(s)/printgroupfiles
+	order.PrintGroups = make([]pc.PrintGroup, 0, 2)
+
+	//order print and ftpin folders are published (replaced) after all files are copied
+	outPub, err := storage.Publish(fc.out, path.Join(fc.cyclePrtFolder, order.FtpFolder))
+	if err != nil {
+		return nil, ErrFileSystem{err}
+	}
+	defer outPub.Abort()
+	wrkPub, err := storage.Publish(fc.out, path.Join(fc.cycleFolder, order.FtpFolder))
+	if err != nil {
+		return nil, ErrFileSystem{err}
+	}
+	defer wrkPub.Abort()
+
+	for i, list := range prints.lists {
+		if len(list) == 0 {
+			continue
+		}
+		pg := prints.printGroup(order, i)
+		pg.ID = fmt.Sprintf("%s_%d", order.ID, len(order.PrintGroups)+1)
+
+		//copy to cycle ftpin folder/orderid/pg.Path
+		_, err = fc.copyList(ctx, src, list, path.Join(wrkPub.Dir, pg.Path), "")
+		if err != nil {
+			return nil, err
+		}
+
+		//copy (link ftpin files) to cycle print folder
+		done, err := fc.copyList(ctx, src, list, path.Join(outPub.Dir, pg.Path, "print"), path.Join(wrkPub.Dir, pg.Path))
+		if err != nil {
+			return nil, err
+		}
+		if done > 0 {
+			pg.FileNum = done
+			pg.Files = make([]pc.PrintGroupFile, 0, done)
+			for _, fi := range list {
+				if fi.Process {
+					pg.Files = append(pg.Files, pc.PrintGroupFile{PrintGroupID: pg.ID, FileName: path.Join("print", fi.NewName), Caption: fi.OldName, PrintQtty: fi.Qtty})
+					pg.Prints += fi.Qtty
+				}
+			}
+			order.FotosNum += pg.Prints
+			order.PrintGroups = append(order.PrintGroups, pg)
+		}
+	}
+	//print and ftpin folders are replaced together (print files are linked to ftpin files)
+	if err = storage.CommitAll(outPub, wrkPub); err != nil {
+		return nil, ErrFileSystem{err}
+	}
+	//TODO check for empty order/pgs
+	//create in BD move to redyToPrint state after pp state change
+	return warnings, nil
+}
+
+//photoFiles checks photo item sku and files, files are renamed to cycle names
+//returns per file warnings (image doesn't fit print format)
+func (fc *baseFactory) photoFiles(ctx context.Context, src *orderSource, item *pp.OrderItem) (photoPrints, []string, error) {
+	var prints photoPrints
 	p, ok := item.Sku()["paper"]
 	if !ok || p == "" {
-		return errCantTransform
+		return prints, nil, errCantTransform
 		//return ErrCantTransform{errors.New("Не указан алиас бумаги (paper)")}
 	}
 	paper, err := strconv.Atoi(p)
 	if err != nil || paper == 0 {
-		return ErrTransform{fmt.Errorf("Не верное значение sku бумаги (paper) %s", p)}
+		return prints, nil, ErrTransform{fmt.Errorf("Не верное значение sku бумаги (paper) %s", p)}
 	}
 
 	w, ok := item.Sku()["width"]
 	if !ok || w == "" {
-		return ErrTransform{errors.New("Не указан sku ширины (width)")}
+		return prints, nil, ErrTransform{errors.New("Не указан sku ширины (width)")}
 	}
 	width, err := strconv.Atoi(w)
 	if err != nil || width == 0 {
-		return ErrTransform{fmt.Errorf("Не верное значение sku ширины (width) %s", w)}
+		return prints, nil, ErrTransform{fmt.Errorf("Не верное значение sku ширины (width) %s", w)}
 	}
 
 	h, ok := item.Sku()["height"]
 	if !ok || h == "" {
-		return ErrTransform{errors.New("Не указан sku длины (height)")}
+		return prints, nil, ErrTransform{errors.New("Не указан sku длины (height)")}
 	}
 	height, err := strconv.Atoi(h)
 	if err != nil || height == 0 {
-		return ErrTransform{fmt.Errorf("Не верное значение sku длины (height) %s", h)}
+		return prints, nil, ErrTransform{fmt.Errorf("Не верное значение sku длины (height) %s", h)}
+	}
+
+	format, err := fc.catalog.format(ctx, fc.pcClient, width, height, paper)
+	if err != nil {
+		return prints, nil, err
 	}
 
 	//check if color correction set
@@ -56,19 +164,14 @@ func (fc *baseFactory) transformPhoto(ctx context.Context, item *pp.OrderItem, o
 	}
 
 	//scan item folder for subfolders vs name like copies_d+
-	itemPath := path.Join(fc.wrkFolder, fmt.Sprintf("%d", item.OrderID), item.DirectoryName)
-	itemFolder, err := folderOpen(itemPath)
-	if err != nil {
-		return ErrFileSystem{err}
-	}
-	defer itemFolder.Close()
-	itemFolderFiles, err := itemFolder.Readdir(-1)
+	itemPath := item.DirectoryName
+	itemFolderFiles, err := fs.ReadDir(src.fsys, itemPath)
 	if err != nil {
-		return ErrFileSystem{err}
+		return prints, nil, ErrFileSystem{err}
 	}
 	rei, err := regexp.Compile(`^copies_(\d+)`)
 	if err != nil {
-		return err
+		return prints, nil, err
 	}
 	withBorders := make([]fileCopy, 0, item.Quantity)
 	noBorders := make([]fileCopy, 0, item.Quantity)
@@ -84,7 +187,7 @@ func (fc *baseFactory) transformPhoto(ctx context.Context, item *pp.OrderItem, o
 		}
 		printsCount, err := strconv.Atoi(sm[1])
 		if err != nil {
-			return err
+			return prints, nil, err
 		}
 		if printsCount < 1 {
 			printsCount = 1
@@ -92,123 +195,53 @@ func (fc *baseFactory) transformPhoto(ctx context.Context, item *pp.OrderItem, o
 		//process subfolder
 		basePath := path.Join(itemPath, fi.Name())
 		//check for subfolders borders and noborders
-		bList, _ := fillList(path.Join(basePath, "borders"), printsCount)
-		nbList, _ := fillList(path.Join(basePath, "noborders"), printsCount)
+		bList, _ := fillList(src.fsys, path.Join(basePath, "borders"), printsCount)
+		nbList, _ := fillList(src.fsys, path.Join(basePath, "noborders"), printsCount)
 		if len(bList) == 0 && len(nbList) == 0 {
 			//folders not exists or empty
-			return ErrTransform{errors.New("Folders with photo not exists or empty (borders, noborders)")}
+			return prints, nil, ErrTransform{errors.New("Folders with photo not exists or empty (borders, noborders)")}
 		}
 		withBorders = append(withBorders, bList...)
 		noBorders = append(noBorders, nbList...)
 	}
-	//rename files to awoid name conflicts
-	for i, fl := range withBorders {
-		withBorders[i].NewName = fmt.Sprintf("%dW%s_%04d%s", fl.Qtty, correction, i, filepath.Ext(fl.OldName))
-	}
-	for i, fl := range noBorders {
-		noBorders[i].NewName = fmt.Sprintf("%dC%s_%04d%s", fl.Qtty, correction, i, filepath.Ext(fl.OldName))
-	}
-
-	//copy & create order printgroup(s)/printgroupfiles
-	order.PrintGroups = make([]pc.PrintGroup, 0, 2)
-
-	outPath := path.Join(fc.cyclePrtFolder, order.FtpFolder)
-	wrkPath := path.Join(fc.cycleFolder, order.FtpFolder)
-	//clear order print folder
-	err = recreateFolder(outPath)
-	if err != nil {
-		return ErrFileSystem{err}
-	}
-	//clear order ftpin folder
-	err = recreateFolder(wrkPath)
-	if err != nil {
-		return ErrFileSystem{err}
-	}
-
+	//validate images
+	warnings := make([]string, 0)
 	lsts := [][]fileCopy{withBorders, noBorders}
-	for i, list := range lsts {
-		if len(list) == 0 {
-			continue
-		}
-		var pg pc.PrintGroup
-		if i == 0 {
-			//photos with border
-			pg = pc.PrintGroup{
-				ID:      fmt.Sprintf("%s_%d", order.ID, len(order.PrintGroups)+1),
-				OrderID: order.ID,
-				Paper:   paper,
-				Width:   width,
-				Height:  height,
-				Cutting: 20,
-				Frame:   15,
-				Path:    fmt.Sprintf("w%d-h%d-p%d-f_b", width, height, paper),
-				State:   order.State,
+	for _, list := range lsts {
+		for _, fl := range list {
+			if !fl.Process {
+				continue
 			}
-		} else {
-			//photos without border
-			pg = pc.PrintGroup{
-				ID:      fmt.Sprintf("%s_%d", order.ID, len(order.PrintGroups)+1),
-				OrderID: order.ID,
-				Paper:   paper,
-				Width:   width,
-				Height:  height,
-				Cutting: 19,
-				Frame:   0,
-				Path:    fmt.Sprintf("w%d-h%d-p%d-f_n", width, height, paper),
-				State:   order.State,
+			name, _ := filepath.Rel(itemPath, path.Join(fl.OldPath, fl.OldName))
+			w, err := checkPhoto(src.fsys, path.Join(fl.OldPath, fl.OldName), format)
+			if err != nil {
+				return prints, nil, ErrTransform{fmt.Errorf("Файл %s не читается: %s", name, err.Error())}
 			}
-		}
-		//set color correction code
-		if correction == "Y" {
-			pg.Correction = 16
-		}
-
-		//copy to cycle ftpin folder/orderid/pg.Path
-		_, err = listCopy(ctx, list, path.Join(wrkPath, pg.Path))
-		if err != nil {
-			return err
-		}
-
-		//copy to cycle print folder
-		done, err := listCopy(ctx, list, path.Join(outPath, pg.Path, "print"))
-		if err != nil {
-			return err
-		}
-		if done > 0 {
-			pg.FileNum = done
-			pg.Files = make([]pc.PrintGroupFile, 0, done)
-			for _, fi := range list {
-				if fi.Process {
-					pg.Files = append(pg.Files, pc.PrintGroupFile{PrintGroupID: pg.ID, FileName: path.Join("print", fi.NewName), Caption: fi.OldName, PrintQtty: fi.Qtty})
-					pg.Prints += fi.Qtty
-				}
+			if w != "" {
+				warnings = append(warnings, fmt.Sprintf("%s: %s", name, w))
 			}
-			order.FotosNum += pg.Prints
-			order.PrintGroups = append(order.PrintGroups, pg)
 		}
 	}
-	//TODO check for empty order/pgs
-	//create in BD move to redyToPrint state after pp state change
-	return nil
-}
 
-func (fc *baseFactory) transformAlias(ctx context.Context, item *pp.OrderItem, order *pc.Order) error {
-	//try build by alias
-	a, ok := item.Sku()["alias"]
-	if !ok || a == "" {
-		return errCantTransform
+	//rename files to awoid name conflicts
+	for i, fl := range withBorders {
+		withBorders[i].NewName = fmt.Sprintf("%dW%s_%04d%s", fl.Qtty, correction, i, filepath.Ext(fl.OldName))
 	}
-
-	if item.DirectoryName == "" {
-		return ErrParce{errors.New("Не указана папка в zip (item.DirectoryName)")}
+	for i, fl := range noBorders {
+		noBorders[i].NewName = fmt.Sprintf("%dC%s_%04d%s", fl.Qtty, correction, i, filepath.Ext(fl.OldName))
 	}
 
-	alias, err := fc.pcClient.LoadAlias(ctx, a)
+	prints.format = format
+	prints.correction = correction
+	prints.lists = [2][]fileCopy{withBorders, noBorders}
+	return prints, warnings, nil
+}
+
+//returns not fatal layout issues as warnings
+func (fc *baseFactory) transformAlias(ctx context.Context, src *orderSource, item *pp.OrderItem, order *pc.Order) ([]string, error) {
+	alias, list, warnings, err := fc.aliasFiles(ctx, src, item, order)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			err = fmt.Errorf("Алиас '%s' не найден в БД", a)
-		}
-		return ErrTransform{err}
+		return nil, err
 	}
 	//check if state forwarded
 	if alias.ForwardState > 0 {
@@ -230,7 +263,51 @@ func (fc *baseFactory) transformAlias(ctx context.Context, item *pp.OrderItem, o
 			pg.BookType = 9
 		}
 		order.PrintGroups[0] = pg
-		return nil
+		return nil, nil
+	}
+	//copy to cycle wrk folder/orderid/alias
+	//order folder is published (replaced) after all files are copied
+	pub, err := storage.Publish(fc.out, path.Join(fc.cycleFolder, order.FtpFolder))
+	if err != nil {
+		return nil, ErrFileSystem{err}
+	}
+	defer pub.Abort()
+	done, err := fc.copyList(ctx, src, list, path.Join(pub.Dir, alias.Alias), "")
+	if err != nil {
+		return nil, err
+	}
+	if err = pub.Commit(); err != nil {
+		return nil, ErrFileSystem{err}
+	}
+	//update order
+	order.FotosNum = done
+	return warnings, nil
+}
+
+//aliasFiles checks book layout and returns book files vs cycle names ('000-00.jpg')
+//files are not returned if alias state is forwarded
+//returns not fatal layout issues as warnings
+func (fc *baseFactory) aliasFiles(ctx context.Context, src *orderSource, item *pp.OrderItem, order *pc.Order) (pc.Alias, []fileCopy, []string, error) {
+	var alias pc.Alias
+	//try build by alias
+	a, ok := item.Sku()["alias"]
+	if !ok || a == "" {
+		return alias, nil, nil, errCantTransform
+	}
+
+	if item.DirectoryName == "" {
+		return alias, nil, nil, ErrParce{errors.New("Не указана папка в zip (item.DirectoryName)")}
+	}
+
+	alias, err := fc.pcClient.LoadAlias(ctx, a)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = fmt.Errorf("Алиас '%s' не найден в БД", a)
+		}
+		return alias, nil, nil, ErrTransform{err}
+	}
+	if alias.ForwardState > 0 {
+		return alias, nil, nil, nil
 	}
 	/*
 		//TODO implement other types (magnets etc)
@@ -239,102 +316,88 @@ func (fc *baseFactory) transformAlias(ctx context.Context, item *pp.OrderItem, o
 			//book
 	*/
 	order.HasCover = alias.HasCover
-	//get file list
-	basePath := path.Join(fc.wrkFolder, fmt.Sprintf("%d", item.OrderID), item.DirectoryName)
-	list, err := fillList(basePath, 1)
-	if err != nil {
-		return ErrSourceNotFound{err}
-	}
-	if len(list) == 0 {
-		return ErrSourceNotFound{fmt.Errorf("Empty folder '%s'", basePath)}
-	}
-
-	//check maket
-	var pages int
-	isMaket := false
+	//check layout
 	//book without cover, but has fake page for cover (placeholder)
 	_, fakeCover := item.Sku()["fake_cover"]
-	if fakeCover {
-		item.PageCount--
-	}
+	lp := LayoutParams{HasCover: alias.HasCover, FakeCover: fakeCover, PageCount: item.PageCount}
 	pagesStr, ok := item.Sku()["maket"]
 	if ok && pagesStr != "" {
-		isMaket = true
-		if pages, err = strconv.Atoi(pagesStr); err != nil {
-			return ErrParce{fmt.Errorf("Неверный формат SKU maket, ожидалось число разворотов. Ошибка:'%s'", err)}
-		}
-		if (pages) != item.PageCount {
-			return ErrParce{errors.New("Количество разворотов заказа не соответствует значению SKU maket")}
+		lp.Maket = true
+		if lp.Spreads, err = strconv.Atoi(pagesStr); err != nil {
+			return alias, nil, nil, ErrParce{fmt.Errorf("Неверный формат SKU maket, ожидалось число разворотов. Ошибка:'%s'", err)}
 		}
-		//add cover
-		item.PageCount++
 	}
-
-	err = listIndexSheets(list, alias.HasCover, isMaket, fakeCover)
+	//personalised books has layout per copy
+	books, err := layoutBooks(src.fsys, item.DirectoryName, item.Quantity, lp)
 	if err != nil {
-		return ErrParce{err}
-	}
-
-	//compact, remove vs Process==false
-	lst := make([]fileCopy, 0, len(list))
-	for i := range list {
-		if list[i].Process {
-			lst = append(lst, list[i])
+		return alias, nil, nil, err
+	}
+	var list []fileCopy
+	warnings := make([]string, 0)
+	errs := make([]string, 0)
+	for _, b := range books {
+		prefix := ""
+		if b.Copy > 0 {
+			prefix = fmt.Sprintf("Экземпляр %d: ", b.Copy)
+		}
+		if !b.Report.Ok() {
+			errs = append(errs, prefix+b.Report.Error())
+			continue
+		}
+		for _, w := range b.Report.Warnings() {
+			warnings = append(warnings, prefix+w)
+		}
+		//compact, remove vs Process==false
+		for _, fi := range b.files {
+			if fi.Process {
+				fi.BookIdx = b.Copy
+				list = append(list, fi)
+			}
 		}
 	}
-	if len(lst) == 0 {
-		//empty order or parse error
-		return ErrParce{errors.New("Empty order or parse error")}
-	}
-	if len(lst) != item.PageCount {
-		//empty order or parse error
-		//TODO Fatal?? pixel cann't rebuild zip
-		return ErrParce{errors.New("Wrong page count or parse error")}
+	if len(errs) > 0 {
+		return alias, nil, nil, ErrParce{errors.New(strings.Join(errs, "; "))}
 	}
-	list = lst
+	item.PageCount = books[0].Report.Sheets
 
 	//set book index
 	//TODO valid only for books, recheck for other products
-	if item.Quantity <= 1 {
-		//one book
-		for i := 0; i < len(list); i++ {
-			list[i].BookIdx = 1
-		}
-	} else {
-		//create copy of last item and set book
-		fi := list[len(list)-1]
-		fi.BookIdx = item.Quantity
-		list = append(list, fi)
-	}
-
-	//check butt if 0 set by sku
-	butt := item.Sizes.Thickness
-	width := item.Sizes.Width
-	if butt == 0 {
-		//maybe book vs fixed butt/width
-		b, ok := item.Sku()["butt"]
-		if ok && b != "" {
-			if bf, err := strconv.ParseFloat(b, 64); err == nil {
-				butt = bf
+	if books[0].Copy == 0 {
+		if item.Quantity <= 1 {
+			//one book
+			for i := 0; i < len(list); i++ {
+				list[i].BookIdx = 1
 			}
+		} else {
+			//create copy of last item and set book
+			fi := list[len(list)-1]
+			fi.BookIdx = item.Quantity
+			list = append(list, fi)
 		}
-	} else {
-		//round up butt to 2
-		butt = math.Ceil(butt/2.0) * 2.0
-		//correct width
-		width = width - item.Sizes.Thickness + butt
-		/*
-			//width is truncated (not rounded) so
-			width = width + (butt - math.Floor(item.Sizes.Thickness))
-		*/
-	}
-	//butt static correction
-	ba, ok := item.Sku()["butt_add"]
-	if ok && ba != "" {
-		if baf, err := strconv.ParseFloat(ba, 64); err == nil {
-			butt += baf
-		}
 	}
+
+	//spine & cover width
+	report := books[0].Report
+	sp := SpineParams{
+		Paper:      item.Sku()["paper"],
+		Binding:    strconv.Itoa(alias.Type),
+		EndPaper:   item.Sku()["endpaper"],
+		InterLayer: item.Sku()["interlayer"],
+		Sheets:     report.Sheets,
+	}
+	if report.First == 0 {
+		//exclude cover
+		sp.Sheets--
+	}
+	spine, err := fc.spine(ctx, item, sp)
+	if err != nil {
+		return alias, nil, nil, err
+	}
+	if spine.Warning != "" {
+		warnings = append(warnings, spine.Warning)
+	}
+	butt := spine.Butt
+	width := spine.Width
 	order.Butt = butt
 	//set output names
 	//decode filenames to cycle names '000-00.jpg'
@@ -350,21 +413,7 @@ func (fc *baseFactory) transformAlias(ctx context.Context, item *pp.OrderItem, o
 			list[i].NewName = fmt.Sprintf("%03d-%02d%s%s", list[i].BookIdx, list[i].SheetIdx, sufix, filepath.Ext(list[i].OldName))
 		}
 	}
-	//copy to cycle wrk folder/orderid/alias
-	outPath := path.Join(fc.cycleFolder, order.FtpFolder)
-	//clear order folder
-	err = recreateFolder(outPath)
-	if err != nil {
-		return ErrFileSystem{err}
-	}
-	outPath = path.Join(outPath, alias.Alias)
-	done, err := listCopy(ctx, list, outPath)
-	if err != nil {
-		return err
-	}
-	//update order
-	order.FotosNum = done
-	return nil
+	return alias, list, warnings, nil
 }
 
 //FromPPOrder converts PP order to photocycle order
@@ -441,15 +490,8 @@ type fileCopy struct {
 	BookIdx  int
 }
 
-func fillList(path string, qtty int) ([]fileCopy, error) {
-
-	f, err := folderOpen(path)
-	if err != nil {
-		return []fileCopy{}, err
-	}
-	defer f.Close()
-
-	list, err := f.Readdir(-1)
+func fillList(fsys fs.FS, dir string, qtty int) ([]fileCopy, error) {
+	list, err := fs.ReadDir(fsys, dir)
 	if err != nil {
 		return []fileCopy{}, err
 	}
@@ -457,7 +499,7 @@ func fillList(path string, qtty int) ([]fileCopy, error) {
 	var res = make([]fileCopy, 0, len(list))
 	for _, fi := range list {
 		if !fi.IsDir() {
-			res = append(res, fileCopy{OldPath: path, OldName: fi.Name(), NewName: fi.Name(), Process: allowedExt[filepath.Ext(fi.Name())], Qtty: qtty})
+			res = append(res, fileCopy{OldPath: dir, OldName: fi.Name(), NewName: fi.Name(), Process: allowedExt[filepath.Ext(fi.Name())], Qtty: qtty})
 		}
 	}
 	return res, nil
@@ -497,13 +539,14 @@ func listIndexSheets(list []fileCopy, hasCover, isMaket, fakeCover bool) error {
 	}
 	for i, fi := range list {
 		if fi.Process {
-			if rep.MatchString(fi.OldName) {
+			name := sheetName(fi.OldName)
+			if rep.MatchString(name) {
 				//exclude preview
 				list[i].Process = false
 			} else {
 				//check if cover for maket
 				if rec != nil {
-					sm := rec.FindStringSubmatch(fi.OldName)
+					sm := rec.FindStringSubmatch(name)
 					if len(sm) > 0 {
 						//cover
 						//TODO valid while it is only one cover
@@ -516,7 +559,7 @@ func listIndexSheets(list []fileCopy, hasCover, isMaket, fakeCover bool) error {
 				}
 
 				//get surface or page index
-				sm := rei.FindStringSubmatch(fi.OldName)
+				sm := rei.FindStringSubmatch(name)
 				if len(sm) != 2 {
 					list[i].Process = false
 				} else {
@@ -536,29 +579,3 @@ func listIndexSheets(list []fileCopy, hasCover, isMaket, fakeCover bool) error {
 	}
 	return nil
 }
-
-func listCopy(ctx context.Context, list []fileCopy, toFolder string) (done int, err error) {
-	//clear output folder
-
-	if err = recreateFolder(toFolder); err != nil {
-		return 0, ErrFileSystem{err}
-	}
-	//copy to cycle print folder
-	//copy files
-	for _, fi := range list {
-		//check if transform context is canceled
-		select {
-		case <-ctx.Done():
-			return 0, ctx.Err()
-		default:
-			// keep working
-		}
-		if fi.Process {
-			if err = copyFile(path.Join(fi.OldPath, fi.OldName), path.Join(toFolder, fi.NewName)); err != nil {
-				return 0, ErrFileSystem{err}
-			}
-			done++
-		}
-	}
-	return
-}
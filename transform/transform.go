@@ -5,7 +5,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/cavaliercoder/grab"
 	log "github.com/go-kit/kit/log"
 
 	pc "github.com/egorka-gh/pixlpark/photocycle"
@@ -15,7 +14,10 @@ import (
 // Transform represents the transform of pixlpark oreder to photocycle orders.
 type Transform struct {
 	//PP state in which the order must be fetched
-	fetchState string
+	fetchState OrderStatus
+
+	//confirmed soft error is confirmed, failed items are dropped
+	confirmed bool
 
 	//ppOrder pixelpark original order
 	ppOrder pp.Order
@@ -24,7 +26,16 @@ type Transform struct {
 	pcBaseOrder pc.Order
 
 	//pcOrders photocycle orders transform result, pixelpark order items transformed to photocycle orders
-	//pcOrders []pc.Order
+	pcOrders []pc.Order
+
+	//pipeline stage held by transform, budgeted - holds shared budget slot
+	stage    Stage
+	staged   bool
+	budgeted bool
+
+	//claim cluster claim of cycle base order, claimStop stops claim heartbeat
+	claim     string
+	claimStop chan struct{}
 
 	// Start specifies the time at which the file transfer started.
 	Start time.Time
@@ -45,8 +56,8 @@ type Transform struct {
 	// Response.
 	cancel context.CancelFunc
 
-	// loader grab loader
-	loader *grab.Response
+	// loader order zip download
+	loader Download
 
 	//contextual logger
 	logger log.Logger